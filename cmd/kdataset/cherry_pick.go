@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kuberlab/pluk/pkg/types"
+	"github.com/spf13/cobra"
+)
+
+type cherryPickCmd struct {
+	workspace string
+	name      string
+	version   string
+	from      string
+	fromType  string
+	paths     []string
+	conflict  string
+}
+
+func NewCherryPickCmd() *cobra.Command {
+	pick := &cherryPickCmd{}
+	cmd := &cobra.Command{
+		Use:   "cherry-pick <workspace> <entity-name>:<version> --from [<workspace>/]<entity-name>:<version> --path <path> [--path <path>] [--conflict fail|skip|overwrite]",
+		Short: "Copy files and directories from another version into the editing version.",
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			// Validation
+			if len(args) < 2 {
+				return errors.New("Too few arguments.")
+			}
+			nameVersion := strings.Split(args[1], ":")
+			if len(nameVersion) != 2 {
+				return fmt.Errorf(
+					"%v and version is invalid. Must be in form <%v-name>:<version>",
+					entityType.Value, entityType.Value,
+				)
+			}
+			if pick.from == "" {
+				return errors.New("Provide source version with --from.")
+			}
+			if len(pick.paths) == 0 {
+				return errors.New("Provide at least one --path.")
+			}
+			switch pick.conflict {
+			case "fail", "skip", "overwrite":
+			default:
+				return fmt.Errorf("Unknown conflict policy %v. Must be one of fail, skip, overwrite", pick.conflict)
+			}
+
+			pick.workspace = args[0]
+			pick.name = nameVersion[0]
+			pick.version = nameVersion[1]
+
+			return pick.run()
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(
+		&pick.from,
+		"from",
+		"",
+		"Source version in form [<workspace>/]<entity-name>:<version> or <version> of the same entity",
+	)
+	f.StringVar(
+		&pick.fromType,
+		"from-type",
+		"",
+		"Source entity type, the same as --type by default",
+	)
+	f.StringArrayVar(
+		&pick.paths,
+		"path",
+		[]string{},
+		"File or directory to copy, may be repeated",
+	)
+	f.StringVar(
+		&pick.conflict,
+		"conflict",
+		"fail",
+		"What to do with existing files: fail, skip or overwrite",
+	)
+
+	return cmd
+}
+
+// source parses --from value.
+func (cmd *cherryPickCmd) source() (types.CherryPick, error) {
+	pick := types.CherryPick{DType: cmd.fromType, Paths: cmd.paths, Conflict: cmd.conflict}
+	from := cmd.from
+	if i := strings.Index(from, "/"); i >= 0 {
+		pick.Workspace = from[:i]
+		from = from[i+1:]
+	}
+	nameVersion := strings.Split(from, ":")
+	switch len(nameVersion) {
+	case 1:
+		pick.Version = nameVersion[0]
+	case 2:
+		pick.Name = nameVersion[0]
+		pick.Version = nameVersion[1]
+	}
+	if pick.Version == "" || (pick.Workspace != "" && pick.Name == "") {
+		return pick, fmt.Errorf("Invalid source %v. Must be in form [<workspace>/]<entity-name>:<version>", cmd.from)
+	}
+	return pick, nil
+}
+
+func (cmd *cherryPickCmd) run() (err error) {
+	client, err := initClient()
+	if err != nil {
+		return err
+	}
+
+	logrus.Debug("Run cherry-pick...")
+
+	pick, err := cmd.source()
+	if err != nil {
+		return err
+	}
+	res, err := client.CherryPick(entityType.Value, cmd.workspace, cmd.name, cmd.version, pick)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	logrus.Infof(
+		"Copied %v files (%v bytes) to %v %v:%v, skipped %v.",
+		res.Files, res.Size, entityType.Value, cmd.name, cmd.version, res.Skipped,
+	)
+	return
+}
@@ -158,6 +158,7 @@ func newRootCmd() *cobra.Command {
 		NewVersionsCmd(),
 		NewDatasetDeleteCmd(),
 		NewVersionDeleteCmd(),
+		NewCherryPickCmd(),
 	)
 	return rootCmd
 }
@@ -11,6 +11,7 @@ import (
 	"io"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/kuberlab/pluk/pkg/types"
 	"github.com/spf13/cobra"
 )
 
@@ -19,12 +20,16 @@ type pullCmd struct {
 	name      string
 	version   string
 	output    string
+	format    string
+	prefixes  []string
+	include   []string
+	exclude   []string
 }
 
 func NewPullCmd() *cobra.Command {
 	pull := &pullCmd{}
 	cmd := &cobra.Command{
-		Use:   "pull <workspace> <entity-name>:<version> [-O output-file.tar]",
+		Use:   "pull <workspace> <entity-name>:<version> [-O output-file.tar] [--format tar|tar.gz|tar.zst|zip] [--prefix path] [--include pattern] [--exclude pattern]",
 		Short: "Download the data entity archive.",
 		RunE: func(cmd *cobra.Command, args []string) (err error) {
 			// Validation
@@ -44,8 +49,14 @@ func NewPullCmd() *cobra.Command {
 			pull.name = nameVersion[0]
 			pull.version = nameVersion[1]
 
+			switch pull.format {
+			case "tar", "tar.gz", "tar.zst", "zip":
+			default:
+				return fmt.Errorf("Unknown format %v. Must be one of tar, tar.gz, tar.zst, zip", pull.format)
+			}
+
 			if pull.output == "" {
-				pull.output = fmt.Sprintf("%v-%v.%v.tar", workspace, pull.name, pull.version)
+				pull.output = fmt.Sprintf("%v-%v.%v.%v", workspace, pull.name, pull.version, pull.format)
 			}
 
 			return pull.run()
@@ -60,6 +71,30 @@ func NewPullCmd() *cobra.Command {
 		"",
 		"Output filename",
 	)
+	f.StringVar(
+		&pull.format,
+		"format",
+		"tar",
+		"Archive format: tar, tar.gz, tar.zst or zip",
+	)
+	f.StringArrayVar(
+		&pull.prefixes,
+		"prefix",
+		[]string{},
+		"Download only files under the path prefix, may be repeated",
+	)
+	f.StringArrayVar(
+		&pull.include,
+		"include",
+		[]string{},
+		"Download only files matching the glob pattern, may be repeated",
+	)
+	f.StringArrayVar(
+		&pull.exclude,
+		"exclude",
+		[]string{},
+		"Skip files and dirs matching the glob pattern, may be repeated",
+	)
 
 	return cmd
 }
@@ -77,11 +112,22 @@ func (cmd *pullCmd) run() (err error) {
 	}
 	defer f.Close()
 
-	size, err := client.EntityTarSize(entityType.Value, cmd.workspace, cmd.name, cmd.version)
-	if err != nil {
-		logrus.Fatal(err)
+	opts := types.ArchiveOpts{
+		Format:   cmd.format,
+		Prefixes: cmd.prefixes,
+		Include:  cmd.include,
+		Exclude:  cmd.exclude,
+	}
+
+	// Size of compressed archives is unknown until downloaded.
+	var size int64 = 0
+	if cmd.format == "tar" {
+		size, err = client.EntityTarSize(entityType.Value, cmd.workspace, cmd.name, cmd.version, opts)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		logrus.Debugf("Tar archive size = %v", size)
 	}
-	logrus.Debugf("Tar archive size = %v", size)
 
 	bar := pb.New64(size).SetUnits(pb.U_BYTES)
 	w := io.MultiWriter(f, bar)
@@ -90,7 +136,7 @@ func (cmd *pullCmd) run() (err error) {
 	bar.ShowSpeed = true
 	bar.Start()
 
-	err = client.DownloadEntity(entityType.Value, cmd.workspace, cmd.name, cmd.version, w)
+	err = client.DownloadEntityArchive(entityType.Value, cmd.workspace, cmd.name, cmd.version, opts, w)
 	if err != nil {
 		bar.Finish()
 		logrus.Fatal(err)
@@ -24,6 +24,10 @@ import (
 
 type pushCmd struct {
 	chunkSize   int
+	cdc         bool
+	compress    bool
+	hash        string
+	hashVersion byte
 	concurrency int64
 	name        string
 	version     string
@@ -72,6 +76,27 @@ func NewPushCmd() *cobra.Command {
 		1024000,
 		"Chunk-size for scanning",
 	)
+	f.BoolVarP(
+		&push.cdc,
+		"cdc",
+		"",
+		false,
+		"Use content-defined chunking: similar files share most of their chunks. "+
+			"--chunk-size is used as the average chunk size.",
+	)
+	f.BoolVarP(
+		&push.compress,
+		"compress",
+		"",
+		false,
+		"Store chunks compressed on the server.",
+	)
+	f.StringVar(
+		&push.hash,
+		"hash",
+		chunk_io.HashSHA512,
+		fmt.Sprintf("Hash algorithm for chunks: %v or %v.", chunk_io.HashSHA512, chunk_io.HashSHA256),
+	)
 	f.StringVar(
 		&push.comment,
 		"comment",
@@ -132,6 +157,13 @@ func NewPushCmd() *cobra.Command {
 
 func (cmd *pushCmd) run() error {
 	logrus.Debugf("Concurrency is set to %v.", cmd.concurrency)
+	var err error
+	if cmd.hashVersion, err = chunk_io.HashVersion(cmd.hash); err != nil {
+		logrus.Fatal(err)
+	}
+	if chunk_io.MaxChunkedSize(cmd.chunkSize, cmd.cdc) > types.MaxChunkSize {
+		logrus.Fatalf("Chunks of --chunk-size %v can exceed the maximum of %v.", cmd.chunkSize, types.MaxChunkSize)
+	}
 	cwd, err := os.Getwd()
 	if err != nil {
 		logrus.Fatal(err)
@@ -199,6 +231,13 @@ func (cmd *pushCmd) run() error {
 	//}
 	defer client.Close()
 
+	// Keep uploaded chunks from GC until the file structure is saved.
+	session, err := client.CreatePushSession(entityType.Value, cmd.workspace, cmd.name, cmd.version)
+	if err != nil {
+		logrus.Debugf("Push session is not available: %v", err)
+		session = nil
+	}
+
 	logrus.Debug("Run push...")
 	var totalSize int64 = 0
 	var fileCount int64 = 0
@@ -285,6 +324,11 @@ func (cmd *pushCmd) run() error {
 	// Wait for emptying fileChan
 	<-syncCh
 	flushBuf(true)
+	if session != nil {
+		if err = client.ClosePushSession(entityType.Value, cmd.workspace, cmd.name, cmd.version, session.ID); err != nil {
+			logrus.Warnf("Failed to close push session: %v", err)
+		}
+	}
 	//logrus.Debugf("File structure: %v", structure)
 
 	//if err = client.SaveFileStructure(
@@ -330,49 +374,46 @@ func (cmd *pushCmd) uploadChunks(
 	//lock := &sync.RWMutex{}
 	ctx := context.TODO()
 
-	var resp *types.ChunkCheck
-	checkAndUpload := func(chunkData []byte, hash string) {
-		defer func() {
-			//lock.Lock()
-			//bar.Add(len(chunkData))
-			//lock.Unlock()
-			sem.Release(1)
-		}()
+	uploadChunk := func(ch pendingChunk) {
+		defer sem.Release(1)
 
-		if !upload {
-			bar.Add(len(chunkData))
-			return
+		chReader := io.TeeReader(bytes.NewReader(ch.data), bar)
+		if err := client.SaveChunkReader(ch.hash, chReader, ch.version); err != nil {
+			_ = pool.Stop()
+			logrus.Fatalf("Failed to upload chunk: %v", err)
 		}
+	}
 
-		rd := bytes.NewReader(chunkData)
-		chReader := io.TeeReader(rd, bar)
-
-		//if cmd.websocket {
-		//	resp, err = client.CheckChunkWebsocket(hash)
-		//} else {
-		resp, err = client.CheckChunk(hash, types.ChunkVersion)
-		//}
+	// Chunks are checked in batches to save round trips.
+	batch := make([]pendingChunk, 0, checkBatchSize)
+	flushBatch := func() {
+		if len(batch) == 0 {
+			return
+		}
+		missing, err := cmd.missingChunks(client, batch)
 		if err != nil {
 			_ = pool.Stop()
-			logrus.Fatalf("Failed to check chunk: %v", err)
+			logrus.Fatalf("Failed to check chunks: %v", err)
 		}
-		if !resp.Exists || resp.Size != int64(len(chunkData)) {
-			// Upload chunk.
-			//if cmd.websocket {
-			//	if err = client.SaveChunkWebsocket(hash, chunkData); err != nil {
-			//		logrus.Errorf("Failed to upload chunk: %v", err)
-			//		os.Exit(1)
-			//	}
-			//} else {
-			if err = client.SaveChunkReader(hash, chReader, types.ChunkVersion); err != nil {
-				_ = pool.Stop()
-				logrus.Fatalf("Failed to upload chunk: %v", err)
+		for _, ch := range batch {
+			if !missing[ch.hash] {
+				bar.Add(len(ch.data))
+				continue
 			}
-			//}
-		} else {
+			sem.Acquire(ctx, 1)
+			go uploadChunk(ch)
+		}
+		batch = batch[:0]
+	}
+	checkAndUpload := func(chunkData []byte, hash string, version byte) {
+		if !upload {
 			bar.Add(len(chunkData))
+			return
+		}
+		batch = append(batch, pendingChunk{data: chunkData, hash: hash, version: version})
+		if len(batch) >= checkBatchSize {
+			flushBatch()
 		}
-		return
 	}
 
 	logrus.Infof("Computing files count and estimate directory space...")
@@ -397,13 +438,23 @@ func (cmd *pushCmd) uploadChunks(
 		if err != nil {
 			return err
 		}
-		r := chunk_io.NewChunkedReader(cmd.chunkSize, file)
+		var r *chunk_io.ChunkedReader
+		if cmd.cdc {
+			r = chunk_io.NewCDCChunkedReader(cmd.chunkSize, file)
+		} else {
+			r = chunk_io.NewChunkedReader(cmd.chunkSize, file)
+		}
+		r.Version = chunk_io.CombineChunkVersions(r.Version, cmd.hashVersion)
 		// Populate file structure.
 		hashed := &types.HashedFile{
 			Path:     strings.TrimPrefix(path, cwd+"/"),
 			Mode:     f.Mode(),
 			ModeTime: f.ModTime(),
 		}
+		version := r.Version
+		if cmd.compress {
+			version = chunk_io.CombineChunkVersions(version, types.ChunkVersionCompressed)
+		}
 		var chunkData []byte
 		var hash string
 		for {
@@ -417,12 +468,11 @@ func (cmd *pushCmd) uploadChunks(
 				break
 			}
 
-			sem.Acquire(ctx, 1)
-			go checkAndUpload(chunkData, hash)
+			checkAndUpload(chunkData, hash, version)
 
 			length := int64(len(chunkData))
 			hashed.Size += length
-			hashed.Hashes = append(hashed.Hashes, types.Hash{Hash: hash, Size: length, Version: types.ChunkVersion})
+			hashed.Hashes = append(hashed.Hashes, types.Hash{Hash: hash, Size: length, Version: version})
 
 		}
 		file.Close()
@@ -433,6 +483,8 @@ func (cmd *pushCmd) uploadChunks(
 		return nil
 	})
 
+	flushBatch()
+
 	// Wait for all.
 	//if cmd.websocket {
 	//	sem.Acquire(ctx, 1)
@@ -450,3 +502,42 @@ func (cmd *pushCmd) uploadChunks(
 	}
 	return nil
 }
+
+// Number of chunks checked on server at once.
+const checkBatchSize = 32
+
+type pendingChunk struct {
+	data    []byte
+	hash    string
+	version byte
+}
+
+// missingChunks returns hashes of chunks which need to be uploaded.
+// Servers without the batch check are asked about every chunk.
+func (cmd *pushCmd) missingChunks(client chunk_io.PlukClient, batch []pendingChunk) (map[string]bool, error) {
+	hashes := make([]types.Hash, len(batch))
+	for i, ch := range batch {
+		hashes[i] = types.Hash{Hash: ch.hash, Size: int64(len(ch.data)), Version: ch.version}
+	}
+
+	result := make(map[string]bool)
+	missing, err := client.CheckChunks(hashes)
+	if err == nil {
+		for _, h := range missing {
+			result[h.Hash] = true
+		}
+		return result, nil
+	}
+	logrus.Debugf("Batch chunk check failed: %v, check one by one", err)
+
+	for _, h := range hashes {
+		resp, err := client.CheckChunk(h.Hash, h.Version)
+		if err != nil {
+			return nil, err
+		}
+		if !resp.Exists || resp.Size != h.Size {
+			result[h.Hash] = true
+		}
+	}
+	return result, nil
+}
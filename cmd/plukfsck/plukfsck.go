@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kuberlab/pluk/pkg/db"
+	"github.com/kuberlab/pluk/pkg/gc"
+	"github.com/kuberlab/pluk/pkg/io"
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultLogLevel = "info"
+)
+
+var (
+	debug    bool
+	logLevel string
+)
+
+func initLogging() {
+	logrus.SetFormatter(&logrus.TextFormatter{TimestampFormat: "2006-01-02 15:04:05", FullTimestamp: true})
+
+	if debug {
+		logLevel = "debug"
+		_ = os.Setenv("DEBUG", "true")
+	}
+	lvl, err := logrus.ParseLevel(logLevel)
+	if err != nil {
+		logrus.SetLevel(logrus.DebugLevel)
+	} else {
+		logrus.SetLevel(lvl)
+	}
+	// Keep stdout for the report.
+	logrus.SetOutput(os.Stderr)
+}
+
+func initRoot(cmd *cobra.Command, args []string) error {
+	initLogging()
+	return nil
+}
+
+type fsckCmd struct {
+	repair bool
+	verify bool
+}
+
+func newFsckCmd() *cobra.Command {
+	fsck := &fsckCmd{}
+	var cmd = &cobra.Command{
+		Use:   "plukfsck",
+		Short: "Check consistency of pluk DB and chunk store.",
+		Long: "Check consistency of pluk DB and chunk store and print the report. " +
+			"DB and store are configured by the same environment variables as pluksrv. " +
+			"Stop pluksrv before repairing or use /admin/fsck endpoint instead.",
+		PersistentPreRunE: initRoot,
+		Run: func(cmd *cobra.Command, args []string) {
+			os.Exit(fsck.run())
+		},
+	}
+
+	p := cmd.PersistentFlags()
+	p.StringVar(&logLevel, "log-level", defaultLogLevel, "Logging level. One of (debug, info, warning, error)")
+	p.BoolVarP(&debug, "debug", "", false, "Enable debug level (shortcut for --log-level=debug).")
+	cmd.Flags().BoolVar(&fsck.repair, "repair", false, "Repair found issues.")
+	cmd.Flags().BoolVar(&fsck.verify, "verify", false, "Verify hashes of all chunks, not only sizes.")
+	return cmd
+}
+
+// run returns 1 if there are errors or issues left unrepaired.
+func (cmd *fsckCmd) run() int {
+	if err := io.InitStore(); err != nil {
+		logrus.Error(err)
+		return 1
+	}
+	db.DbMgr = db.NewMainDatabaseMgr()
+	defer db.DbMgr.Close()
+
+	report := gc.Fsck(db.DbMgr, cmd.repair, cmd.verify)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		logrus.Error(err)
+		return 1
+	}
+	if len(report.Errors) != 0 || report.Issues > report.Repaired {
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	cmd := newFsckCmd()
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+	"github.com/kuberlab/pluk/pkg/db"
+	maindb "github.com/kuberlab/pluk/pkg/db/gorm"
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultLogLevel = "info"
+)
+
+var (
+	debug    bool
+	logLevel string
+)
+
+func initLogging() {
+	logrus.SetFormatter(&logrus.TextFormatter{TimestampFormat: "2006-01-02 15:04:05", FullTimestamp: true})
+
+	if debug {
+		logLevel = "debug"
+		_ = os.Setenv("DEBUG", "true")
+	}
+	lvl, err := logrus.ParseLevel(logLevel)
+	if err != nil {
+		logrus.SetLevel(logrus.DebugLevel)
+	} else {
+		logrus.SetLevel(lvl)
+	}
+}
+
+func initRoot(cmd *cobra.Command, args []string) error {
+	initLogging()
+	return nil
+}
+
+// openDB opens the DB without applying migrations.
+func openDB() *gorm.DB {
+	return maindb.InitMain(db.NoOp)
+}
+
+func newMigrateCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "plukmigrate",
+		Short: "Show and apply pluk DB migrations.",
+		Long: "Show and apply pluk DB migrations. DB is configured by the same " +
+			"environment variables as pluksrv.",
+		PersistentPreRunE: initRoot,
+	}
+
+	p := cmd.PersistentFlags()
+	p.StringVar(&logLevel, "log-level", defaultLogLevel, "Logging level. One of (debug, info, warning, error)")
+	p.BoolVarP(&debug, "debug", "", false, "Enable debug level (shortcut for --log-level=debug).")
+
+	cmd.AddCommand(newStatusCmd(), newUpCmd(), newDownCmd())
+	return cmd
+}
+
+func newStatusCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "List applied and pending migrations.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conn := openDB()
+			defer conn.Close()
+
+			applied, err := db.AppliedMigrations(conn)
+			if err != nil {
+				return err
+			}
+			done := make(map[uint]*db.SchemaMigration)
+			for _, m := range applied {
+				done[m.ID] = m
+			}
+			for _, m := range db.Migrations() {
+				status := "pending"
+				if a, ok := done[m.ID]; ok {
+					status = fmt.Sprintf("applied at %v", a.AppliedAt.Format("2006-01-02 15:04:05"))
+				}
+				fmt.Printf("%4d  %-30s  %v\n", m.ID, m.Name, status)
+			}
+			return nil
+		},
+	}
+}
+
+func newUpCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "up",
+		Short: "Apply pending migrations.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conn := openDB()
+			defer conn.Close()
+
+			applied, err := db.MigrateUp(conn)
+			for _, m := range applied {
+				fmt.Printf("Applied %v: %v\n", m.ID, m.Name)
+			}
+			if err == nil && len(applied) == 0 {
+				fmt.Println("No pending migrations.")
+			}
+			return err
+		},
+	}
+}
+
+func newDownCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "down <migration>",
+		Short: "Revert applied migrations after the given one.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			to, err := strconv.ParseUint(args[0], 10, 32)
+			if err != nil {
+				return fmt.Errorf("Wrong migration %q: %v", args[0], err)
+			}
+			conn := openDB()
+			defer conn.Close()
+
+			reverted, err := db.MigrateDown(conn, uint(to))
+			for _, m := range reverted {
+				fmt.Printf("Reverted %v: %v\n", m.ID, m.Name)
+			}
+			return err
+		},
+	}
+}
+
+func main() {
+	cmd := newMigrateCmd()
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
@@ -1,16 +1,44 @@
 package api
 
 import (
+	"net/http"
+
 	"github.com/emicklei/go-restful"
+	"github.com/kuberlab/pluk/pkg/gc"
 	"github.com/kuberlab/pluk/pkg/utils"
 )
 
+// runGC starts GC in background. With dry_run=true it waits
+// for the report of what would be deleted instead.
 func (api *API) runGC(req *restful.Request, resp *restful.Response) {
+	if getBoolQueryParam(req, "dry_run") {
+		resp.WriteEntity(gc.DryRunGC())
+		return
+	}
 	utils.GCChan <- "Run GC by API request"
 	resp.Write([]byte("GC started!\n"))
 }
 
 func (api *API) runClearChunks(req *restful.Request, resp *restful.Response) {
+	if getBoolQueryParam(req, "dry_run") {
+		report := gc.DryRunClearChunks(api.mgr)
+		if report == nil {
+			WriteErrorString(resp, http.StatusConflict, "Clear chunks is already running")
+			return
+		}
+		resp.WriteEntity(report)
+		return
+	}
 	utils.GCClearChunks <- "Run by API request"
 	resp.Write([]byte("Clear chunks started!\n"))
 }
+
+func (api *API) gcStatus(req *restful.Request, resp *restful.Response) {
+	resp.WriteEntity(gc.Status())
+}
+
+// fsck checks consistency of the DB and the chunk store, with repair=true
+// it also fixes found issues. It waits for the running GC.
+func (api *API) fsck(req *restful.Request, resp *restful.Response) {
+	resp.WriteEntity(gc.Fsck(api.mgr, getBoolQueryParam(req, "repair"), getBoolQueryParam(req, "verify")))
+}
@@ -0,0 +1,259 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/kuberlab/pluk/pkg/db"
+	"github.com/kuberlab/pluk/pkg/gc"
+	plukio "github.com/kuberlab/pluk/pkg/io"
+	"github.com/kuberlab/pluk/pkg/types"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+func TestGCDryRun(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+	defer os.Unsetenv("GC_GRACE_PERIOD")
+
+	url := buildURL("dataset/workspace/dataset/versions/1.0.0/upload/file.txt")
+	resp, err := client.Post(url, "application/json", bytes.NewBufferString(fileData1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+
+	// Mark the version deleted without triggering GC.
+	dsv, err := db.DbMgr.GetDatasetVersion("dataset", "workspace", "dataset", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dsv.Deleted = true
+	if _, err = db.DbMgr.UpdateDatasetVersion(dsv); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("GC_GRACE_PERIOD", "0s")
+	resp, err = client.Get(buildURL("admin/gc?dry_run=true"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusOK, resp.StatusCode, t)
+	report := types.GCReport{}
+	if err = json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(true, report.DryRun, t)
+	utils.Assert(false, report.Running, t)
+	utils.Assert(0, len(report.Errors), t)
+	utils.Assert(1, len(report.Versions), t)
+	utils.Assert("1.0.0", report.Versions[0].Version, t)
+	utils.Assert(int64(1), report.Files, t)
+	utils.Assert(int64(1), report.Chunks, t)
+	utils.Assert(int64(len(fileData1)), report.Size, t)
+	utils.Assert([]string{plukio.CalcHash([]byte(fileData1), types.ChunkVersion)}, report.Hashes, t)
+
+	// Nothing is deleted.
+	files, err := db.DbMgr.ListFiles(db.File{Workspace: "workspace", DatasetName: "dataset", Version: "1.0.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(1, len(files), t)
+	_, err = db.DbMgr.GetDatasetVersion("dataset", "workspace", "dataset", "1.0.0")
+	utils.Assert(nil, err, t)
+
+	resp, err = client.Get(buildURL("admin/gc/status"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := types.GCStatus{}
+	if err = json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(true, status.GCDryRun.DryRun, t)
+	utils.Assert("done", status.GCDryRun.Phase, t)
+	utils.Assert(false, status.GCDryRun.FinishedAt == nil, t)
+	// Status of the real run is kept.
+	utils.Assert(true, status.GC == nil || !status.GC.DryRun, t)
+}
+
+func TestGCDryRunReleasedChunks(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+	defer os.Unsetenv("GC_GRACE_PERIOD")
+
+	url := buildURL("dataset/workspace/dataset/versions/1.0.0/upload/file.txt")
+	resp, err := client.Post(url, "application/json", bytes.NewBufferString(fileData1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+	dsv, err := db.DbMgr.GetDatasetVersion("dataset", "workspace", "dataset", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dsv.Deleted = true
+	if _, err = db.DbMgr.UpdateDatasetVersion(dsv); err != nil {
+		t.Fatal(err)
+	}
+
+	// Chunks released by the run are reported though they wait for the grace period.
+	os.Setenv("GC_GRACE_PERIOD", "1h")
+	dry := gc.DryRunGC()
+	utils.Assert([]string{plukio.CalcHash([]byte(fileData1), types.ChunkVersion)}, dry.Hashes, t)
+	utils.Assert(int64(len(fileData1)), dry.Size, t)
+
+	gc.GoGC()
+	utils.Assert(0, len(gc.Status().GC.Hashes), t)
+	utils.Assert(dry.Hashes, gc.Status().GCDryRun.Hashes, t)
+	os.Setenv("GC_GRACE_PERIOD", "0s")
+	gc.GoGC()
+	utils.Assert(dry.Hashes, gc.Status().GC.Hashes, t)
+}
+
+func TestClearChunksDryRun(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+	defer os.Unsetenv("GC_GRACE_PERIOD")
+
+	chunkHash := plukio.CalcHash([]byte(fileData2), types.ChunkVersion)
+	url := buildURL(fmt.Sprintf("chunks/%v/%v", chunkHash, types.ChunkVersion))
+	resp, err := client.Post(url, "application/json", bytes.NewBufferString(fileData2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+
+	os.Setenv("GC_GRACE_PERIOD", "0s")
+	resp, err = client.Get(buildURL("admin/clear-chunks?dry_run=true"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusOK, resp.StatusCode, t)
+	report := types.GCReport{}
+	if err = json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert("clear-chunks", report.Kind, t)
+	utils.Assert(int64(1), report.Chunks, t)
+	utils.Assert([]string{chunkHash}, report.Hashes, t)
+
+	_, exists := plukio.CheckLocalChunk(chunkHash, types.ChunkVersion)
+	utils.Assert(true, exists, t)
+
+	resp, err = client.Get(buildURL("admin/gc/status"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := types.GCStatus{}
+	if err = json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(int64(1), status.ClearChunksDryRun.Chunks, t)
+	utils.Assert(true, status.ClearChunks == nil || !status.ClearChunks.DryRun, t)
+}
+
+func fsckReport(t *testing.T, query string) *types.FsckReport {
+	resp, err := client.Get(buildURL("admin/fsck" + query))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusOK, resp.StatusCode, t)
+	report := &types.FsckReport{}
+	if err = json.NewDecoder(resp.Body).Decode(report); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(0, len(report.Errors), t)
+	return report
+}
+
+func TestFsck(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+
+	url := buildURL("dataset/workspace/dataset/versions/1.0.0/upload/file.txt")
+	resp, err := client.Post(url, "application/json", bytes.NewBufferString(fileData1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+	utils.Assert(int64(0), fsckReport(t, "").Issues, t)
+
+	// Break every table.
+	chunk, err := db.DbMgr.GetChunk(plukio.CalcHash([]byte(fileData1), types.ChunkVersion), types.ChunkVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DbMgr.CreateFileChunk(&db.FileChunk{FileID: 9999, ChunkID: chunk.ID}); err != nil {
+		t.Fatal(err)
+	}
+	chunk, _ = db.DbMgr.GetChunk(chunk.Hash, chunk.Version)
+	chunk.Size++
+	chunk.RefCount = 7
+	if _, err = db.DbMgr.UpdateChunk(chunk); err != nil {
+		t.Fatal(err)
+	}
+	broken := &db.File{
+		Path: "broken.txt", Size: 10, DatasetName: "dataset", DatasetType: "dataset",
+		Workspace: "workspace", Version: "1.0.0",
+	}
+	if err = db.DbMgr.CreateFile(broken); err != nil {
+		t.Fatal(err)
+	}
+	dsv, _ := db.DbMgr.GetDatasetVersion("dataset", "workspace", "dataset", "1.0.0")
+	dsv.FileCount = 5
+	if _, err = db.DbMgr.UpdateDatasetVersion(dsv); err != nil {
+		t.Fatal(err)
+	}
+
+	report := fsckReport(t, "")
+	utils.Assert(int64(1), report.OrphanFileChunks, t)
+	utils.Assert(1, len(report.BrokenFiles), t)
+	utils.Assert("broken.txt", report.BrokenFiles[0].Path, t)
+	utils.Assert(int64(1), report.WrongRefCounts, t)
+	utils.Assert(1, len(report.Versions), t)
+	utils.Assert(int64(5), report.Versions[0].FileCount, t)
+	utils.Assert(int64(2), report.Versions[0].ActualFileCount, t)
+	utils.Assert(1, len(report.WrongSizeChunks), t)
+	utils.Assert(int64(len(fileData1)), report.WrongSizeChunks[0].StoreSize, t)
+	utils.Assert(int64(5), report.Issues, t)
+	utils.Assert(int64(0), report.Repaired, t)
+
+	report = fsckReport(t, "?repair=true")
+	utils.Assert(int64(5), report.Issues, t)
+	utils.Assert(int64(5), report.Repaired, t)
+	utils.Assert(int64(0), fsckReport(t, "").Issues, t)
+
+	dsv, _ = db.DbMgr.GetDatasetVersion("dataset", "workspace", "dataset", "1.0.0")
+	utils.Assert(int64(1), dsv.FileCount, t)
+	utils.Assert(int64(len(fileData1)), dsv.Size, t)
+	chunk, _ = db.DbMgr.GetChunk(chunk.Hash, chunk.Version)
+	utils.Assert(int64(1), chunk.RefCount, t)
+	utils.Assert(int64(len(fileData1)), chunk.Size, t)
+
+	// Corrupt data of the same size is found only by hash.
+	data := []byte(strings.ToUpper(fileData1))
+	if _, err = plukio.Store().Put(chunk.Hash, chunk.Version, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(int64(0), fsckReport(t, "").Issues, t)
+	report = fsckReport(t, "?verify=true&repair=true")
+	utils.Assert(1, len(report.CorruptChunks), t)
+	utils.Assert(int64(1), report.Repaired, t)
+
+	report = fsckReport(t, "")
+	utils.Assert(1, len(report.MissingChunks), t)
+	utils.Assert(chunk.Hash, report.MissingChunks[0].Hash, t)
+}
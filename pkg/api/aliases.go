@@ -0,0 +1,109 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/kuberlab/pluk/pkg/types"
+)
+
+func (api *API) listAliases(req *restful.Request, resp *restful.Response) {
+	workspace := req.PathParameter("workspace")
+	name := req.PathParameter("name")
+	master := api.masterClient(req)
+
+	dataset, err := api.ds.GetDataset(currentType(req), workspace, name, master)
+	if err != nil {
+		WriteError(resp, EntityNotFoundError(req, name, err))
+		return
+	}
+	aliases, err := dataset.Aliases()
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+	resp.WriteEntity(types.VersionAliasList{Aliases: aliases})
+}
+
+func (api *API) getAlias(req *restful.Request, resp *restful.Response) {
+	workspace := req.PathParameter("workspace")
+	name := req.PathParameter("name")
+	alias := req.PathParameter("alias")
+	master := api.masterClient(req)
+
+	dataset, err := api.ds.GetDataset(currentType(req), workspace, name, master)
+	if err != nil {
+		WriteError(resp, EntityNotFoundError(req, name, err))
+		return
+	}
+	version := dataset.ResolveVersion(alias)
+	if version == alias {
+		WriteErrorString(resp, http.StatusNotFound, fmt.Sprintf("Alias %v not found", alias))
+		return
+	}
+	resp.WriteEntity(types.VersionAlias{
+		Alias:     alias,
+		Version:   version,
+		Workspace: workspace,
+		Name:      name,
+		DType:     currentType(req),
+	})
+}
+
+func (api *API) setAlias(req *restful.Request, resp *restful.Response) {
+	workspace := req.PathParameter("workspace")
+	name := req.PathParameter("name")
+	alias := req.PathParameter("alias")
+	master := api.masterClient(req)
+
+	body := types.VersionAlias{}
+	if err := req.ReadEntity(&body); err != nil {
+		WriteStatusError(resp, http.StatusBadRequest, err)
+		return
+	}
+	if body.Version == "" {
+		WriteErrorString(resp, http.StatusBadRequest, "Provide version")
+		return
+	}
+
+	dataset, err := api.ds.GetDataset(currentType(req), workspace, name, master)
+	if err != nil {
+		WriteError(resp, EntityNotFoundError(req, name, err))
+		return
+	}
+	res, err := dataset.SetAlias(alias, body.Version)
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+
+	api.ds.PushMessageAlias(res)
+	resp.WriteEntity(res)
+}
+
+func (api *API) deleteAlias(req *restful.Request, resp *restful.Response) {
+	workspace := req.PathParameter("workspace")
+	name := req.PathParameter("name")
+	alias := req.PathParameter("alias")
+	master := api.masterClient(req)
+
+	dataset, err := api.ds.GetDataset(currentType(req), workspace, name, master)
+	if err != nil {
+		WriteError(resp, EntityNotFoundError(req, name, err))
+		return
+	}
+	if err = dataset.DeleteAlias(alias); err != nil {
+		WriteError(resp, err)
+		return
+	}
+
+	api.ds.PushMessageAlias(&types.VersionAlias{
+		Alias:     alias,
+		Workspace: workspace,
+		Name:      name,
+		DType:     currentType(req),
+		Deleted:   true,
+	})
+	resp.WriteHeader(http.StatusNoContent)
+}
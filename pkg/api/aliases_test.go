@@ -0,0 +1,109 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/kuberlab/pluk/pkg/types"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+func setTestAlias(t *testing.T, alias, version string) int {
+	data, _ := json.Marshal(types.VersionAlias{Version: version})
+	url := buildURL("dataset/workspace/dataset/aliases/" + alias)
+	req, _ := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestVersionAliases(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+
+	upload := func(version, data string) {
+		url := buildURL("dataset/workspace/dataset/versions/" + version + "/upload/file.txt")
+		resp, err := client.Post(url, "application/json", bytes.NewBufferString(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		utils.Assert(http.StatusCreated, resp.StatusCode, t)
+	}
+	readFile := func(version string) (int, string) {
+		resp, err := client.Get(buildURL("dataset/workspace/dataset/versions/" + version + "/raw/file.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, mustRead(resp.Body)
+	}
+
+	upload("1.0.0", fileData1)
+	resp, err := client.Post(buildURL("dataset/workspace/dataset/versions/1.0.1"), "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+	upload("1.0.1", fileData2)
+
+	utils.Assert(http.StatusOK, setTestAlias(t, "stable", "1.0.0"), t)
+	utils.Assert(http.StatusOK, setTestAlias(t, "latest", "1.0.1"), t)
+	utils.Assert(http.StatusNotFound, setTestAlias(t, "next", "2.0.0"), t)
+	// Aliases can't look like versions.
+	utils.Assert(http.StatusBadRequest, setTestAlias(t, "2.0.0", "1.0.0"), t)
+
+	code, data := readFile("stable")
+	utils.Assert(http.StatusOK, code, t)
+	utils.Assert(fileData1, data, t)
+	_, data = readFile("latest")
+	utils.Assert(fileData2, data, t)
+
+	resp, err = client.Get(buildURL("dataset/workspace/dataset/aliases"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list types.VersionAliasList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(2, len(list.Aliases), t)
+	utils.Assert("latest", list.Aliases[0].Alias, t)
+	utils.Assert("1.0.1", list.Aliases[0].Version, t)
+
+	// Move the alias.
+	utils.Assert(http.StatusOK, setTestAlias(t, "latest", "1.0.0"), t)
+	_, data = readFile("latest")
+	utils.Assert(fileData1, data, t)
+
+	req, _ := http.NewRequest(http.MethodDelete, buildURL("dataset/workspace/dataset/aliases/latest"), nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusNoContent, resp.StatusCode, t)
+	code, _ = readFile("latest")
+	utils.Assert(http.StatusNotFound, code, t)
+
+	// Aliases of a deleted version are deleted with it.
+	utils.Assert(http.StatusOK, setTestAlias(t, "next", "1.0.1"), t)
+	req, _ = http.NewRequest(http.MethodDelete, buildURL("dataset/workspace/dataset/versions/1.0.1"), nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusNoContent, resp.StatusCode, t)
+	resp, err = client.Get(buildURL("dataset/workspace/dataset/aliases/next"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusNotFound, resp.StatusCode, t)
+	code, data = readFile("stable")
+	utils.Assert(http.StatusOK, code, t)
+	utils.Assert(fileData1, data, t)
+}
@@ -107,6 +107,8 @@ func NewApiContainer(api *API, prefix string) *restful.Container {
 	ws.Route(ws.GET("/workspaces/{workspace}/{entityType}/{dataset}/permission").To(api.checkDatasetPermission))
 	ws.Route(ws.POST("/workspaces/{workspace}/{entityType}/{dataset}/spec").To(api.postSpec))
 	ws.Route(ws.POST("/workspaces/{workspace}/{entityType}/{dataset}/versions/{version}/spec").To(api.postVersionSpec))
+	ws.Route(ws.GET("/workspaces/{workspace}/usage").To(api.workspaceUsage))
+	ws.Route(ws.GET("/workspaces/{workspace}/search").To(api.searchDatasets))
 
 	// Items
 	//ws.Route(ws.GET("/{entityType}").To(api.datasets))
@@ -120,6 +122,7 @@ func NewApiContainer(api *API, prefix string) *restful.Container {
 	ws.Route(ws.POST("/{entityType}/{workspace}/{name}/versions/{version}").To(api.createVersion))
 	ws.Route(ws.GET("/{entityType}/{workspace}/{name}/versions/{version}/get").To(api.getVersion))
 	ws.Route(ws.POST("/{entityType}/{workspace}/{name}/versions/{version}/clone/{targetVersion}").To(api.cloneVersion))
+	ws.Route(ws.GET("/{entityType}/{workspace}/{name}/versions/{version}/diff/{targetVersion}").To(api.diffVersions))
 	ws.Route(ws.POST("/{entityType}/{workspace}/{name}/versions/{version}/commit").To(api.commitVersion))
 	ws.Route(ws.GET("/{entityType}/{workspace}/{name}/versions/{version}/fs").To(api.getDatasetFS))
 	ws.Route(ws.DELETE("/{entityType}/{workspace}/{name}/versions/{version}").To(api.deleteVersion))
@@ -129,6 +132,34 @@ func NewApiContainer(api *API, prefix string) *restful.Container {
 	ws.Route(ws.GET("/{entityType}/{workspace}/{name}/versions/{version}/raw/{path:*}").To(api.fsReadFile))
 	ws.Route(ws.POST("/{entityType}/{workspace}/{name}/versions/{version}/upload/{path:*}").To(api.uploadDatasetFile))
 	ws.Route(ws.DELETE("/{entityType}/{workspace}/{name}/versions/{version}/upload/{path:*}").To(api.deleteDatasetFile))
+	ws.Route(ws.POST("/{entityType}/{workspace}/{name}/versions/{version}/move").To(api.moveDatasetFiles))
+	ws.Route(ws.POST("/{entityType}/{workspace}/{name}/versions/{version}/copy").To(api.copyDatasetFiles))
+	ws.Route(ws.POST("/{entityType}/{workspace}/{name}/versions/{version}/cherry-pick").To(api.cherryPick))
+
+	// Description and labels of datasets and versions.
+	ws.Route(ws.GET("/{entityType}/{workspace}/{name}/metadata").To(api.getMetadata))
+	ws.Route(ws.PUT("/{entityType}/{workspace}/{name}/metadata").To(api.setMetadata))
+	ws.Route(ws.DELETE("/{entityType}/{workspace}/{name}/metadata/labels/{key}").To(api.deleteLabel))
+	ws.Route(ws.GET("/{entityType}/{workspace}/{name}/versions/{version}/metadata").To(api.getMetadata))
+	ws.Route(ws.PUT("/{entityType}/{workspace}/{name}/versions/{version}/metadata").To(api.setMetadata))
+	ws.Route(ws.DELETE("/{entityType}/{workspace}/{name}/versions/{version}/metadata/labels/{key}").To(api.deleteLabel))
+
+	// Version aliases, such as "latest", accepted anywhere in place of {version} for reading.
+	ws.Route(ws.GET("/{entityType}/{workspace}/{name}/aliases").To(api.listAliases))
+	ws.Route(ws.GET("/{entityType}/{workspace}/{name}/aliases/{alias}").To(api.getAlias))
+	ws.Route(ws.PUT("/{entityType}/{workspace}/{name}/aliases/{alias}").To(api.setAlias))
+	ws.Route(ws.DELETE("/{entityType}/{workspace}/{name}/aliases/{alias}").To(api.deleteAlias))
+
+	// Resumable upload of a single file by parts.
+	ws.Route(ws.POST("/{entityType}/{workspace}/{name}/versions/{version}/sessions").To(api.createUploadSession))
+	ws.Route(ws.GET("/{entityType}/{workspace}/{name}/versions/{version}/sessions/{session}").To(api.getUploadSession))
+	ws.Route(ws.PUT("/{entityType}/{workspace}/{name}/versions/{version}/sessions/{session}/parts/{part}").To(api.uploadSessionPart))
+	ws.Route(ws.POST("/{entityType}/{workspace}/{name}/versions/{version}/sessions/{session}/complete").To(api.completeUploadSession))
+	ws.Route(ws.DELETE("/{entityType}/{workspace}/{name}/versions/{version}/sessions/{session}").To(api.abortUploadSession))
+
+	// Push of many files, chunks uploaded with the session header are kept by GC until it is closed.
+	ws.Route(ws.POST("/{entityType}/{workspace}/{name}/versions/{version}/push-sessions").To(api.createPushSession))
+	ws.Route(ws.DELETE("/{entityType}/{workspace}/{name}/versions/{version}/push-sessions/{session}").To(api.closePushSession))
 
 	// Save file structure for version.
 	ws.Route(ws.POST("/{entityType}/{workspace}/{name}/{version}").To(api.saveFS))
@@ -139,6 +170,8 @@ func NewApiContainer(api *API, prefix string) *restful.Container {
 	ws.Route(ws.GET("/chunks/{hash}/{version}").To(api.checkChunk))
 	ws.Route(ws.GET("/chunks/{hash}/download").To(api.downloadChunk))
 	ws.Route(ws.GET("/chunks/{hash}/download/{version}").To(api.downloadChunk))
+	// Check many chunks at once, returns the chunks to upload
+	ws.Route(ws.POST("/chunks/check").To(api.checkChunks))
 	// Save hashed file chunk
 	ws.Route(ws.POST("/chunks/{hash}").To(api.saveChunk))
 	ws.Route(ws.POST("/chunks/{hash}/{version}").To(api.saveChunk))
@@ -150,7 +183,13 @@ func NewApiContainer(api *API, prefix string) *restful.Container {
 
 	// admin
 	ws.Route(ws.GET("/admin/gc").To(api.runGC))
+	ws.Route(ws.GET("/admin/gc/status").To(api.gcStatus))
 	ws.Route(ws.GET("/admin/clear-chunks").To(api.runClearChunks))
+	ws.Route(ws.GET("/admin/fsck").To(api.fsck))
+	ws.Route(ws.GET("/admin/quotas").To(api.listQuotas))
+	ws.Route(ws.GET("/admin/quotas/{workspace}").To(api.getQuota))
+	ws.Route(ws.PUT("/admin/quotas/{workspace}").To(api.setQuota))
+	ws.Route(ws.DELETE("/admin/quotas/{workspace}").To(api.deleteQuota))
 
 	ws.Filter(setCurrentType)
 
@@ -56,6 +56,13 @@ func teardown(fname string) {
 		"dataset_versions",
 		"datasets",
 		"file_chunks",
+		"quotas",
+		"upload_sessions",
+		"upload_chunks",
+		"push_sessions",
+		"push_chunks",
+		"version_aliases",
+		"labels",
 	}
 
 	for _, t := range allTables {
@@ -1,15 +1,21 @@
 package api
 
 import (
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/emicklei/go-restful"
 	plukio "github.com/kuberlab/pluk/pkg/io"
+	"github.com/kuberlab/pluk/pkg/types"
 )
 
+// Maximum number of chunks checked in one request.
+const maxChunkCheckBatch = 10000
+
 func (api *API) chunkVersion(req *restful.Request) byte {
 	versionRaw := req.PathParameter("version")
 	// Faster
@@ -19,15 +25,19 @@ func (api *API) chunkVersion(req *restful.Request) byte {
 	if versionRaw == "" {
 		return 0
 	}
-	var version int64 = 0
+	var version uint64 = 0
 	if versionRaw != "" {
-		version, _ = strconv.ParseInt(versionRaw, 10, 8)
+		version, _ = strconv.ParseUint(versionRaw, 10, 8)
 	}
 	return byte(version)
 }
 
 func (api *API) checkChunk(req *restful.Request, resp *restful.Response) {
 	hash := req.PathParameter("hash")
+	if err := api.pinChunks(req, hash); err != nil {
+		WriteError(resp, err)
+		return
+	}
 
 	chunkCheck, err := plukio.CheckChunk(hash, api.chunkVersion(req))
 	if err != nil {
@@ -37,6 +47,38 @@ func (api *API) checkChunk(req *restful.Request, resp *restful.Response) {
 	resp.WriteEntity(chunkCheck)
 }
 
+func (api *API) checkChunks(req *restful.Request, resp *restful.Response) {
+	hashes := make([]types.Hash, 0)
+	if err := req.ReadEntity(&hashes); err != nil {
+		WriteStatusError(resp, http.StatusBadRequest, err)
+		return
+	}
+	if len(hashes) > maxChunkCheckBatch {
+		WriteErrorString(
+			resp,
+			http.StatusBadRequest,
+			fmt.Sprintf("Too many chunks to check, maximum is %v", maxChunkCheckBatch),
+		)
+		return
+	}
+	// Existing chunks are pinned too since the push is going to reference them.
+	pinned := make([]string, len(hashes))
+	for i, h := range hashes {
+		pinned[i] = h.Hash
+	}
+	if err := api.pinChunks(req, pinned...); err != nil {
+		WriteError(resp, err)
+		return
+	}
+
+	missing, err := plukio.CheckChunks(hashes)
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+	resp.WriteEntity(missing)
+}
+
 func (api *API) downloadChunk(req *restful.Request, resp *restful.Response) {
 	hash := req.PathParameter("hash")
 	file, err := plukio.GetChunkByHash(hash, api.chunkVersion(req))
@@ -44,19 +86,48 @@ func (api *API) downloadChunk(req *restful.Request, resp *restful.Response) {
 		WriteStatusError(resp, http.StatusNotFound, err)
 		return
 	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			logrus.Error(err)
+		}
+	}()
 
+	// Chunk content never changes, so its hash is a strong ETag.
+	resp.Header().Set("ETag", fmt.Sprintf(`"%v"`, hash))
+	resp.Header().Set("Content-Type", "application/octet-stream")
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		http.ServeContent(resp.ResponseWriter, req.Request, hash, time.Time{}, seeker)
+		return
+	}
 	resp.WriteHeader(http.StatusOK)
 	io.Copy(resp, file)
-	err = file.Close()
-	if err != nil {
-		logrus.Error(err)
-	}
 }
 
 func (api *API) saveChunk(req *restful.Request, resp *restful.Response) {
 	hash := req.PathParameter("hash")
+	version := api.chunkVersion(req)
+
+	// Chunks are not bound to a dataset, so the quota is checked
+	// only if the workspace is known.
+	workspace := req.QueryParameter("workspace")
+	if workspace == "" {
+		workspace = req.HeaderParameter("X-Workspace-Name")
+	}
+	if workspace != "" {
+		if _, exists := plukio.CheckLocalChunk(hash, version); !exists {
+			if err := api.checkChunkQuota(workspace, req.Request.ContentLength); err != nil {
+				WriteError(resp, err)
+				return
+			}
+		}
+	}
+
+	if err := api.pinChunks(req, hash); err != nil {
+		WriteError(resp, err)
+		return
+	}
 
-	if err := plukio.SaveChunk(hash, api.chunkVersion(req), req.Request.Body, true); err != nil {
+	if err := plukio.SaveChunk(hash, version, req.Request.Body, true); err != nil {
 		WriteStatusError(resp, http.StatusInternalServerError, err)
 		return
 	}
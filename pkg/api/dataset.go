@@ -50,21 +50,18 @@ func (api *API) allDatasets(req *restful.Request, resp *restful.Response) {
 func (api *API) datasets(req *restful.Request, resp *restful.Response) {
 	workspace := req.PathParameter("workspace")
 
-	sets, err := api.ds.ListDatasets(currentType(req), workspace)
+	selector, err := utils.ParseLabelSelector(req.QueryParameter("labels"))
 	if err != nil {
-		WriteError(resp, err)
+		WriteStatusError(resp, http.StatusBadRequest, err)
 		return
 	}
-	ds := types.DataSetList{}
-	for _, d := range sets {
-		ds.Items = append(
-			ds.Items,
-			types.Dataset{Name: d.Name, Workspace: d.Workspace, DType: d.Type},
-		)
-	}
-	if len(ds.Items) == 0 {
-		ds.Items = make([]types.Dataset, 0)
+
+	sets, err := api.ds.SearchDatasets(currentType(req), workspace, selector, "")
+	if err != nil {
+		WriteError(resp, err)
+		return
 	}
+	ds := types.DataSetList{Items: sets}
 	sort.Sort(ds)
 	resp.WriteEntity(ds)
 }
@@ -73,6 +70,16 @@ func (api *API) downloadDataset(req *restful.Request, resp *restful.Response) {
 	version := req.PathParameter("version")
 	name := req.PathParameter("name")
 	workspace := req.PathParameter("workspace")
+	format, err := archiveFormat(req)
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+	filter, err := fileFilter(req)
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
 	master := api.masterClient(req)
 
 	dataset, err := api.ds.GetDataset(currentType(req), workspace, name, master)
@@ -94,22 +101,56 @@ func (api *API) downloadDataset(req *restful.Request, resp *restful.Response) {
 	}
 	dataset.FS = fs
 
-	sz, err := dataset.TarSize()
+	// Only the plain tar size is known before the archive is written.
+	if format == datasets.ArchiveTar {
+		sz, err := dataset.TarSize(filter)
+		if err != nil {
+			WriteStatusError(resp, http.StatusInternalServerError, err)
+			return
+		}
+		resp.Header().Add("Content-Length", fmt.Sprintf("%v", sz))
+	}
+	resp.Header().Add("Content-Type", datasets.ArchiveContentType(format))
+	resp.Header().Add(
+		"Content-Disposition",
+		fmt.Sprintf("attachment; filename=%s-%s.%s.%s", workspace, name, version, format),
+	)
+
+	err = dataset.Download(resp, format, filter)
 	if err != nil {
-		WriteStatusError(resp, http.StatusInternalServerError, err)
-		return
+		// The archive is partially sent, so the error can't be reported
+		// in the response: break the connection to show it is truncated.
+		logrus.Errorf("Download %v/%v:%v: %v", workspace, name, version, err)
+		panic(http.ErrAbortHandler)
 	}
+}
 
-	resp.Header().Add("Content-Type", "application/tar")
-	resp.Header().Add("Content-Length", fmt.Sprintf("%v", sz))
+func archiveFormat(req *restful.Request) (string, error) {
+	format := req.QueryParameter("format")
+	if format == "" {
+		format = datasets.ArchiveTar
+	}
+	if datasets.ArchiveContentType(format) == "" {
+		return "", errors.NewStatus(
+			http.StatusBadRequest,
+			fmt.Sprintf(
+				"Wrong format, allowed %v/%v/%v/%v",
+				datasets.ArchiveTar, datasets.ArchiveTarGz, datasets.ArchiveTarZst, datasets.ArchiveZip,
+			),
+		)
+	}
+	return format, nil
+}
 
-	err = dataset.Download(resp)
+// fileFilter selects files by "prefix", "include" and "exclude"
+// query parameters, each of them may be repeated.
+func fileFilter(req *restful.Request) (*datasets.FileFilter, error) {
+	query := req.Request.URL.Query()
+	filter, err := datasets.NewFileFilter(query["prefix"], query["include"], query["exclude"])
 	if err != nil {
-		WriteStatusError(resp, http.StatusInternalServerError, err)
-		return
+		return nil, errors.NewStatus(http.StatusBadRequest, err.Error())
 	}
-
-	//resp.Header().Add("Content-Disposition", fmt.Sprintf("attachment;filename=%s-%s.%s.tgz;", workspace, name, version))
+	return filter, nil
 }
 
 func (api *API) getDataset(req *restful.Request, resp *restful.Response) {
@@ -130,6 +171,25 @@ func (api *API) datasetTarSize(req *restful.Request, resp *restful.Response) {
 	version := req.PathParameter("version")
 	name := req.PathParameter("name")
 	workspace := req.PathParameter("workspace")
+	format, err := archiveFormat(req)
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+	if format != datasets.ArchiveTar {
+		// Size of compressed archives depends on the content.
+		WriteErrorString(
+			resp,
+			http.StatusBadRequest,
+			fmt.Sprintf("Size of %v archive can't be known in advance", format),
+		)
+		return
+	}
+	filter, err := fileFilter(req)
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
 	master := api.masterClient(req)
 
 	dataset, err := api.ds.GetDataset(currentType(req), workspace, name, master)
@@ -144,7 +204,7 @@ func (api *API) datasetTarSize(req *restful.Request, resp *restful.Response) {
 	}
 	dataset.FS = fs
 
-	sz, err := dataset.TarSize()
+	sz, err := dataset.TarSize(filter)
 	if err != nil {
 		WriteStatusError(resp, http.StatusInternalServerError, err)
 		return
@@ -265,6 +325,7 @@ func (api *API) fsCacheKeyPrefix(ds *datasets.Dataset) string {
 }
 
 func (api *API) getFS(dataset *datasets.Dataset, version string) (fs *plukio.ChunkedFileFS, err error) {
+	version = dataset.ResolveVersion(version)
 	fsRaw := api.fsCache.GetRaw(api.fsCacheKey(dataset, version))
 	if fsRaw == nil {
 		logrus.Infof("Caching FS %v:%v...", dataset.Name, version)
@@ -2,12 +2,18 @@ package api
 
 import (
 	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"sort"
 	"testing"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/kuberlab/lib/pkg/errors"
 	"github.com/kuberlab/pluk/pkg/db"
 	plukio "github.com/kuberlab/pluk/pkg/io"
@@ -486,14 +492,211 @@ func TestDownloadDataset(t *testing.T) {
 	utils.Assert(2, files, t)
 }
 
+func TestDownloadDatasetFormats(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+
+	expected := map[string]string{"file1.txt": fileData1, "folder/file2.txt": fileData2}
+	for path, data := range expected {
+		url := buildURL("dataset/workspace/dataset/versions/1.0.0/upload/" + path)
+		resp, err := client.Post(url, "application/json", bytes.NewBufferString(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		utils.Assert(http.StatusCreated, resp.StatusCode, t)
+	}
+
+	readTar := func(r io.Reader) map[string]string {
+		files := make(map[string]string)
+		reader := tar.NewReader(r)
+		for {
+			hd, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := ioutil.ReadAll(reader)
+			files[hd.Name] = string(data)
+		}
+		return files
+	}
+	download := func(format string) *http.Response {
+		resp, err := client.Get(buildURL("dataset/workspace/dataset/versions/1.0.0?format=" + format))
+		if err != nil {
+			t.Fatal(err)
+		}
+		utils.Assert(http.StatusOK, resp.StatusCode, t)
+		return resp
+	}
+
+	resp := download("tar.gz")
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(expected, readTar(gz), t)
+
+	resp = download("tar.zst")
+	zr, err := zstd.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(expected, readTar(zr), t)
+	zr.Close()
+
+	resp = download("zip")
+	data, _ := ioutil.ReadAll(resp.Body)
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range zipReader.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(r)
+		files[f.Name] = string(content)
+	}
+	utils.Assert(expected, files, t)
+
+	// Size is known only for plain tar.
+	resp, err = client.Get(buildURL("dataset/workspace/dataset/versions/1.0.0/tarsize?format=zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusBadRequest, resp.StatusCode, t)
+
+	resp, err = client.Get(buildURL("dataset/workspace/dataset/versions/1.0.0?format=rar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusBadRequest, resp.StatusCode, t)
+}
+
+func TestDownloadDatasetMissingChunk(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+
+	for path, data := range map[string]string{"file1.txt": fileData1, "folder/file2.txt": fileData2} {
+		url := buildURL("dataset/workspace/dataset/versions/1.0.0/upload/" + path)
+		resp, err := client.Post(url, "application/json", bytes.NewBufferString(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		utils.Assert(http.StatusCreated, resp.StatusCode, t)
+	}
+	hash := plukio.CalcHash([]byte(fileData2), types.ChunkVersion)
+	if err := plukio.Store().Delete(hash, types.ChunkVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	// The archive is already being sent, so the connection is broken
+	// instead of appending an error to it.
+	for _, format := range []string{"tar", "tar.gz"} {
+		resp, err := client.Get(buildURL("dataset/workspace/dataset/versions/1.0.0?format=" + format))
+		if err != nil {
+			// Broken before the buffered headers were sent.
+			continue
+		}
+		utils.Assert(http.StatusOK, resp.StatusCode, t)
+		_, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		utils.Assert(true, err != nil, t)
+	}
+}
+
+func TestDownloadDatasetFiltered(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+
+	paths := []string{
+		"file1.txt",
+		"data/a.csv",
+		"data/b.txt",
+		"data/tmp/c.csv",
+		"data_old/d.csv",
+		"models/m.bin",
+		"models/data/e.csv",
+	}
+	for _, path := range paths {
+		url := buildURL("dataset/workspace/dataset/versions/1.0.0/upload/" + path)
+		resp, err := client.Post(url, "application/json", bytes.NewBufferString(fileData1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		utils.Assert(http.StatusCreated, resp.StatusCode, t)
+	}
+
+	check := func(query string, expected []string) {
+		resp, err := client.Get(buildURL("dataset/workspace/dataset/versions/1.0.0?" + query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		utils.Assert(http.StatusOK, resp.StatusCode, t)
+		data, _ := ioutil.ReadAll(resp.Body)
+
+		files := make([]string, 0)
+		reader := tar.NewReader(bytes.NewReader(data))
+		for {
+			hd, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			files = append(files, hd.Name)
+		}
+		sort.Strings(files)
+		utils.Assert(expected, files, t)
+
+		resp, err = client.Get(buildURL("dataset/workspace/dataset/versions/1.0.0/tarsize?" + query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		utils.Assert(http.StatusOK, resp.StatusCode, t)
+		utils.Assert(fmt.Sprintf("%v\n", len(data)), mustRead(resp.Body), t)
+	}
+
+	check("prefix=data/", []string{"data/a.csv", "data/b.txt", "data/tmp/c.csv"})
+	check("prefix=data", []string{"data/a.csv", "data/b.txt", "data/tmp/c.csv"})
+	check("include=*.csv", []string{"data/a.csv", "data/tmp/c.csv", "data_old/d.csv", "models/data/e.csv"})
+	check("prefix=data&exclude=tmp", []string{"data/a.csv", "data/b.txt"})
+	check(
+		"include=data/**/*.csv&include=*.bin",
+		[]string{"data/a.csv", "data/tmp/c.csv", "models/m.bin"},
+	)
+	check("prefix=models/m.bin&prefix=file1.txt", []string{"file1.txt", "models/m.bin"})
+	check("prefix=file", []string{})
+	// Pattern with "/" is not matched against names in nested dirs.
+	check("exclude=data&exclude=data_old/*", []string{"file1.txt", "models/m.bin"})
+	check("exclude=/data&exclude=data_old", []string{"file1.txt", "models/data/e.csv", "models/m.bin"})
+
+	resp, err := client.Get(buildURL("dataset/workspace/dataset/versions/1.0.0?include=[a-"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusBadRequest, resp.StatusCode, t)
+}
+
 func TestUploadCorrectChunk(t *testing.T) {
 	fname := getFname()
 	setup(fname)
 	dbPrepare(t)
 	defer teardown(fname)
 
-	//hash1 := utils.CalcHash([]byte(fileData1))
-	hash2 := utils.CalcHash([]byte(fileData2))
+	//hash1 := plukio.CalcHash([]byte(fileData1), types.ChunkVersion)
+	hash2 := plukio.CalcHash([]byte(fileData2), types.ChunkVersion)
 
 	// Post chunk1 by hash2 (simulate corrupted data)
 	url := buildURL("chunks/" + hash2)
@@ -502,9 +705,9 @@ func TestUploadCorrectChunk(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+	utils.Assert(http.StatusBadRequest, resp.StatusCode, t)
 
-	// Check chunk2 by hash2 (to upload correct data)
+	// Corrupted data must not be saved.
 	url = buildURL("chunks/" + hash2)
 	resp, err = client.Get(url)
 	if err != nil {
@@ -516,13 +719,10 @@ func TestUploadCorrectChunk(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	utils.Assert(true, chunk.Exists, t)
-	utils.Assert(int64(len(fileData1)), chunk.Size, t)
+	utils.Assert(false, chunk.Exists, t)
 	utils.Assert(hash2, chunk.Hash, t)
-	utils.Assert(true, chunk.Size != int64(len(fileData2)), t)
 
 	// Upload correct data
-	// Post chunk1 by hash2 (simulate corrupted data)
 	url = buildURL("chunks/" + hash2)
 	resp, err = client.Post(url, "application/json", bytes.NewBufferString(fileData2))
 	if err != nil {
@@ -10,16 +10,16 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/Sirupsen/logrus"
 	"github.com/emicklei/go-restful"
 	"github.com/kuberlab/lib/pkg/errors"
 	"github.com/kuberlab/pluk/pkg/datasets"
 	plukio "github.com/kuberlab/pluk/pkg/io"
+	"github.com/kuberlab/pluk/pkg/plukclient"
 	"github.com/kuberlab/pluk/pkg/types"
-	"github.com/kuberlab/pluk/pkg/utils"
 )
 
 func (api *API) fsReadDir(req *restful.Request, resp *restful.Response) {
@@ -74,15 +74,22 @@ func (api *API) fsReadFile(req *restful.Request, resp *restful.Response) {
 		return
 	}
 	file = file.Clone()
+	defer file.Close()
 
-	resp.Header().Add("Content-Length", fmt.Sprintf("%v", file.Size))
+	// ServeContent handles Range and If-Range requests seeking
+	// to the requested chunks.
+	resp.Header().Set("ETag", fileETag(file))
 	setContentTypeByFile(filepath, resp)
-	resp.ResponseWriter.WriteHeader(http.StatusOK)
+	http.ServeContent(resp.ResponseWriter, req.Request, file.Name, file.ModTime, file)
+}
 
-	_, err = io.Copy(resp, file)
-	if err != nil {
-		logrus.Error(err)
+// fileETag identifies the file content by the hashes of its chunks.
+func fileETag(file *plukio.ChunkedFile) string {
+	hashes := make([]string, len(file.Chunks))
+	for i, c := range file.Chunks {
+		hashes[i] = c.Path
 	}
+	return fmt.Sprintf(`"%v"`, plukio.CalcHash([]byte(strings.Join(hashes, ",")), types.ChunkVersion)[:40])
 }
 
 func setContentTypeByFile(filepath string, resp *restful.Response) {
@@ -213,22 +220,45 @@ func (api *API) uploadDatasetFile(req *restful.Request, resp *restful.Response)
 		return
 	}
 
+	// Fail early if the body surely doesn't fit.
+	size := req.Request.ContentLength
+	if existing, err := api.mgr.GetFile(workspace, name, currentType(req), filepath, version); err == nil {
+		size -= existing.Size
+	}
+	if err = api.checkQuota(workspace, size, 0); err != nil {
+		WriteError(resp, err)
+		return
+	}
+
 	f, err := api.readAndSaveFile(req, resp)
 	if err != nil {
 		WriteError(resp, err)
 		return
 	}
-	fs := types.FileStructure{Files: []*types.HashedFile{f}}
-
-	api.lockForSave(workspace, name, version)
-	defer api.unlockForSave(workspace, name, version)
-	if err := dataset.Save(fs, version, "", false, false, true, true); err != nil {
+	if err = api.saveUploadedFile(dataset, version, f); err != nil {
 		WriteError(resp, err)
 		return
 	}
+	resp.WriteHeaderAndEntity(http.StatusCreated, f)
+}
+
+// saveUploadedFile adds the file which chunks are already saved to the version.
+// The file it overwrites is counted by the quota check and deleted only along with saving.
+func (api *API) saveUploadedFile(dataset *datasets.Dataset, version string, f *types.HashedFile) error {
+	api.lockForSave(dataset.Workspace, dataset.Name, version)
+	defer api.unlockForSave(dataset.Workspace, dataset.Name, version)
+
+	files := []*types.HashedFile{f}
+	err := api.checkStructureQuota(dataset.Type, dataset.Workspace, dataset.Name, version, files)
+	if err != nil {
+		return err
+	}
+	if err = dataset.ReplaceFiles(files, version); err != nil {
+		return err
+	}
 	// Invalidate cache
 	api.invalidateVersionCache(dataset, version)
-	resp.WriteHeaderAndEntity(http.StatusCreated, f)
+	return nil
 }
 
 func (api *API) lockForSave(ws, ds, version string) {
@@ -253,82 +283,273 @@ func (api *API) unlockForSave(ws, ds, version string) {
 }
 
 func (api *API) readAndSaveFile(req *restful.Request, resp *restful.Response) (f *types.HashedFile, err error) {
-	workspace := req.PathParameter("workspace")
-	name := req.PathParameter("name")
-	version := req.PathParameter("version")
 	filepath := req.PathParameter("path")
 
+	opts, err := getChunkOptions(req)
+	if err != nil {
+		return nil, err
+	}
+
+	f = &types.HashedFile{Path: filepath, Mode: os.FileMode(getFileMode(req)), ModeTime: time.Now()}
+	defer req.Request.Body.Close()
+
+	f.Hashes, f.Size, err = saveChunks(req.Request.Body, opts)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func getFileMode(req *restful.Request) uint32 {
 	modeRaw := req.QueryParameter("mode")
 	modeOct, _ := strconv.ParseUint(modeRaw, 8, 32)
 	mode := uint32(modeOct)
 	if modeOct == 0 {
 		mode = 0644
 	}
+	return mode
+}
 
-	tx := api.mgr.Begin()
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		} else {
-			tx.Commit()
-		}
-	}()
-	_, err = tx.GetFile(workspace, name, currentType(req), filepath, version)
-	if err == nil {
-		// File exists, need overwrite
-		// Delete related chunks
-		err = datasets.DeleteFiles(
-			tx, currentType(req), workspace, name, version, filepath, true, false,
-		)
-		if err != nil {
-			return nil, err
-		}
+// chunkOptions describe how uploaded data is split into chunks.
+type chunkOptions struct {
+	CDC         bool
+	Compress    bool
+	HashVersion byte
+}
 
+func getChunkOptions(req *restful.Request) (opts chunkOptions, err error) {
+	opts.CDC = getBoolQueryParam(req, "cdc")
+	opts.HashVersion, err = plukio.HashVersion(req.QueryParameter("hash"))
+	if err != nil {
+		return opts, errors.NewStatus(http.StatusBadRequest, err.Error())
 	}
+	opts.Compress = getBoolQueryParam(req, "compress")
+	return opts, nil
+}
 
-	f = &types.HashedFile{Path: filepath, Mode: os.FileMode(mode), ModeTime: time.Now(), Hashes: make([]types.Hash, 0)}
+// saveChunks splits data into chunks and saves those which don't exist yet.
+func saveChunks(body io.Reader, opts chunkOptions) ([]types.Hash, int64, error) {
 	var total int64 = 0
 	chunkSize := 1024000
-	reader := utils.NewPreciseReader(req.Request.Body)
-	defer req.Request.Body.Close()
-	var check *types.ChunkCheck
+
+	var reader *plukio.ChunkedReader
+	if opts.CDC {
+		reader = plukio.NewCDCChunkedReader(chunkSize, body)
+	} else {
+		reader = plukio.NewChunkedReader(chunkSize, body)
+	}
+	reader.Version = plukio.CombineChunkVersions(reader.Version, opts.HashVersion)
+	chunkVersion := reader.Version
+	if opts.Compress {
+		chunkVersion = plukio.CombineChunkVersions(chunkVersion, types.ChunkVersionCompressed)
+	}
+
+	hashes := make([]types.Hash, 0)
 	for {
-		buf := make([]byte, chunkSize)
-		read, errRead := reader.Read(buf)
+		data, hash, errRead := reader.NextChunk()
+		if errRead == io.EOF {
+			break
+		}
 		if errRead != nil {
-			if errRead != io.EOF {
-				return nil, err
-			}
+			return nil, 0, errRead
 		}
-		total += int64(read)
+		total += int64(len(data))
 
-		// Calc hash
-		hash := utils.CalcHash(buf)
 		// Check and save
-		check, err = plukio.CheckChunk(hash, types.ChunkVersion)
+		check, err := plukio.CheckChunk(hash, chunkVersion)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
-		f.Hashes = append(f.Hashes, types.Hash{Hash: hash, Size: int64(read), Version: types.ChunkVersion})
+		hashes = append(hashes, types.Hash{Hash: hash, Size: int64(len(data)), Version: chunkVersion})
 
-		if check.Exists && int(check.Size) == read {
-			if errRead == io.EOF {
-				// Nothing to read or save
-				break
-			}
+		if check.Exists && int(check.Size) == len(data) {
 			// Skip
 			continue
 		}
 
-		if err = plukio.SaveChunk(hash, types.ChunkVersion, ioutil.NopCloser(bytes.NewBuffer(buf[:read])), true); err != nil {
-			return nil, err
+		if err = plukio.SaveChunk(hash, chunkVersion, ioutil.NopCloser(bytes.NewBuffer(data)), true); err != nil {
+			return nil, 0, err
 		}
+	}
+	return hashes, total, nil
+}
 
-		if errRead == io.EOF {
-			break
+func (api *API) moveDatasetFiles(req *restful.Request, resp *restful.Response) {
+	api.fileOperation(req, resp, false)
+}
+
+func (api *API) copyDatasetFiles(req *restful.Request, resp *restful.Response) {
+	api.fileOperation(req, resp, true)
+}
+
+// fileOperation moves or copies files of the editing version
+// without touching the chunks.
+func (api *API) fileOperation(req *restful.Request, resp *restful.Response, copyFiles bool) {
+	workspace := req.PathParameter("workspace")
+	name := req.PathParameter("name")
+	version := req.PathParameter("version")
+	master := api.masterClient(req)
+
+	op := types.FileOperation{}
+	if err := req.ReadEntity(&op); err != nil {
+		WriteStatusError(resp, http.StatusBadRequest, err)
+		return
+	}
+	policy, err := datasets.CheckConflictPolicy(op.Conflict)
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+
+	dataset, err := api.ds.GetDataset(currentType(req), workspace, name, master)
+	if err != nil {
+		WriteError(resp, EntityNotFoundError(req, name, err))
+		return
+	}
+	if _, err = api.findDatasetVersion(dataset, version, false); err != nil {
+		WriteError(resp, err)
+		return
+	}
+
+	dst := datasets.VersionRef{Type: dataset.Type, Workspace: workspace, Name: name, Version: version}
+	src := dst
+	if op.FromVersion != "" {
+		if !copyFiles {
+			WriteErrorString(resp, http.StatusBadRequest, "Files can be moved only inside the version")
+			return
+		}
+		src.Version = dataset.ResolveVersion(op.FromVersion)
+		if _, err = api.findDatasetVersion(dataset, src.Version, true); err != nil {
+			WriteError(resp, err)
+			return
 		}
 	}
 
-	f.Size = total
-	return f, nil
+	acquireConcurrency()
+	defer releaseConcurrency()
+	api.lockForSave(workspace, name, version)
+	defer api.unlockForSave(workspace, name, version)
+
+	tx := api.mgr.Begin()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	var result types.FileOperationResult
+	if copyFiles {
+		result, err = datasets.CopyFiles(tx, src, op.From, dst, op.To, policy)
+		if err == nil {
+			// Copies share chunks but count in the workspace size.
+			err = api.checkQuota(workspace, result.Size, 0)
+		}
+	} else {
+		result, err = datasets.MoveFiles(tx, dst, op.From, op.To, policy)
+	}
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+	// Invalidate cache
+	api.invalidateVersionCache(dataset, version)
+	resp.WriteEntity(result)
+}
+
+// cherryPick copies paths from a version of any readable dataset
+// into the editing version reusing the chunks.
+func (api *API) cherryPick(req *restful.Request, resp *restful.Response) {
+	workspace := req.PathParameter("workspace")
+	name := req.PathParameter("name")
+	version := req.PathParameter("version")
+	master := api.masterClient(req)
+
+	pick := types.CherryPick{}
+	if err := req.ReadEntity(&pick); err != nil {
+		WriteStatusError(resp, http.StatusBadRequest, err)
+		return
+	}
+	policy, err := datasets.CheckConflictPolicy(pick.Conflict)
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+	if pick.Version == "" {
+		WriteErrorString(resp, http.StatusBadRequest, "Provide source version")
+		return
+	}
+	if pick.Workspace == "" {
+		pick.Workspace = workspace
+	}
+	if pick.Name == "" {
+		pick.Name = name
+	}
+	if pick.DType == "" {
+		pick.DType = currentType(req)
+	}
+	if _, ok := plukclient.AllowedTypes[pick.DType]; !ok {
+		msg := fmt.Sprintf("Wrong entity type: Must be one of %v", plukclient.AllowedTypesList())
+		WriteErrorString(resp, http.StatusBadRequest, msg)
+		return
+	}
+
+	dataset, err := api.ds.GetDataset(currentType(req), workspace, name, master)
+	if err != nil {
+		WriteError(resp, EntityNotFoundError(req, name, err))
+		return
+	}
+	if _, err = api.findDatasetVersion(dataset, version, false); err != nil {
+		WriteError(resp, err)
+		return
+	}
+
+	if pick.Workspace != workspace {
+		// Request is authorized for the target workspace only.
+		if err = api.checkReadAccess(req, pick.DType, pick.Workspace); err != nil {
+			WriteError(resp, err)
+			return
+		}
+	}
+	source, err := api.ds.GetDataset(pick.DType, pick.Workspace, pick.Name, master)
+	if err != nil {
+		WriteErrorString(
+			resp,
+			http.StatusNotFound,
+			fmt.Sprintf("%v %v/%v not found: %v", pick.DType, pick.Workspace, pick.Name, err),
+		)
+		return
+	}
+	srcVersion := source.ResolveVersion(pick.Version)
+	if _, err = api.findDatasetVersion(source, srcVersion, true); err != nil {
+		WriteError(resp, err)
+		return
+	}
+
+	acquireConcurrency()
+	defer releaseConcurrency()
+	api.lockForSave(workspace, name, version)
+	defer api.unlockForSave(workspace, name, version)
+
+	tx := api.mgr.Begin()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	src := datasets.VersionRef{Type: pick.DType, Workspace: pick.Workspace, Name: pick.Name, Version: srcVersion}
+	dst := datasets.VersionRef{Type: dataset.Type, Workspace: workspace, Name: name, Version: version}
+	result, err := datasets.CherryPick(tx, src, pick.Paths, dst, policy)
+	if err == nil {
+		err = api.checkQuota(workspace, result.Size, 0)
+	}
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+	// Invalidate cache
+	api.invalidateVersionCache(dataset, version)
+	resp.WriteEntity(result)
 }
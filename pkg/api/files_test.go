@@ -2,9 +2,17 @@ package api
 
 import (
 	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"os"
 	"testing"
+	"time"
 
+	"github.com/kuberlab/pluk/pkg/db"
 	"github.com/kuberlab/pluk/pkg/io"
 	"github.com/kuberlab/pluk/pkg/types"
 	"github.com/kuberlab/pluk/pkg/utils"
@@ -89,6 +97,200 @@ func TestUploadFileManyChunks(t *testing.T) {
 	utils.Assert(targetLen, len(data), t)
 }
 
+func TestUploadFileCDC(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+
+	fileData := make([]byte, 3072000)
+	rand.New(rand.NewSource(1)).Read(fileData)
+
+	url := buildURL("dataset/workspace/dataset/versions/1.0.0/upload/file.bin?cdc=true")
+	resp, err := client.Post(url, "application/json", bytes.NewBuffer(fileData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+
+	var f types.HashedFile
+	if err := json.NewDecoder(resp.Body).Decode(&f); err != nil {
+		t.Fatal(err)
+	}
+
+	utils.Assert(int64(len(fileData)), f.Size, t)
+	utils.Assert(true, len(f.Hashes) > 1, t)
+	for _, h := range f.Hashes {
+		utils.Assert(byte(types.ChunkVersionCDC), h.Version, t)
+	}
+
+	url = buildURL("dataset/workspace/dataset/versions/1.0.0/raw/file.bin")
+	resp, err = client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(string(fileData), mustRead(resp.Body), t)
+}
+
+func TestUploadFileCompressed(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+
+	fileData := bytes.NewBufferString("")
+	for fileData.Len() < 2048000 {
+		fileData.WriteString(fileData2)
+	}
+	content := fileData.String()
+
+	url := buildURL("dataset/workspace/dataset/versions/1.0.0/upload/file.txt?compress=true")
+	resp, err := client.Post(url, "application/json", fileData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+
+	var f types.HashedFile
+	if err := json.NewDecoder(resp.Body).Decode(&f); err != nil {
+		t.Fatal(err)
+	}
+
+	utils.Assert(int64(len(content)), f.Size, t)
+	var offset int64
+	for _, h := range f.Hashes {
+		utils.Assert(byte(types.ChunkVersionCompressed), h.Version, t)
+		utils.Assert(io.CalcHash([]byte(content[offset:offset+h.Size]), types.ChunkVersion), h.Hash, t)
+		offset += h.Size
+
+		stat, err := os.Stat(utils.GetHashedFilename(h.Hash, h.Version))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Size > 1024 {
+			utils.Assert(true, stat.Size() < h.Size, t)
+		}
+
+		check, err := io.CheckChunk(h.Hash, h.Version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		utils.Assert(h.Size, check.Size, t)
+	}
+
+	url = buildURL("dataset/workspace/dataset/versions/1.0.0/raw/file.txt")
+	resp, err = client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(content, mustRead(resp.Body), t)
+
+	// The same content stored plain doesn't replace compressed chunks.
+	url = buildURL("dataset/workspace/dataset/versions/1.0.0/upload/plain.txt")
+	resp, err = client.Post(url, "application/json", bytes.NewBufferString(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+	for _, h := range f.Hashes {
+		chunk, err := db.DbMgr.GetChunk(h.Hash, h.Version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		utils.Assert(byte(types.ChunkVersionCompressed), chunk.Version, t)
+		_, err = db.DbMgr.GetChunk(h.Hash, types.ChunkVersion)
+		utils.Assert(nil, err, t)
+	}
+	url = buildURL("dataset/workspace/dataset/versions/1.0.0/raw/file.txt")
+	resp, err = client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(content, mustRead(resp.Body), t)
+
+	// Compression is combined with content-defined boundaries and SHA256.
+	url = buildURL("dataset/workspace/dataset/versions/1.0.0/upload/all.txt?compress=true&cdc=true&hash=sha256")
+	resp, err = client.Post(url, "application/json", bytes.NewBufferString(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+	f = types.HashedFile{}
+	if err := json.NewDecoder(resp.Body).Decode(&f); err != nil {
+		t.Fatal(err)
+	}
+	version := byte(types.ChunkVersionFlags | types.ChunkFlagCDC | types.ChunkFlagCompressed | types.ChunkFlagSHA256)
+	offset = 0
+	for _, h := range f.Hashes {
+		utils.Assert(version, h.Version, t)
+		utils.Assert(io.CalcHash([]byte(content[offset:offset+h.Size]), types.ChunkVersionSHA256), h.Hash, t)
+		offset += h.Size
+
+		stat, err := os.Stat(utils.GetHashedFilename(h.Hash, h.Version))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Size > 1024 {
+			utils.Assert(true, stat.Size() < h.Size, t)
+		}
+	}
+	url = buildURL("dataset/workspace/dataset/versions/1.0.0/raw/all.txt")
+	resp, err = client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(content, mustRead(resp.Body), t)
+}
+
+func TestUploadFileSHA256(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+
+	url := buildURL("dataset/workspace/dataset/versions/1.0.0/upload/file.txt?hash=sha256")
+	resp, err := client.Post(url, "application/json", bytes.NewBufferString(fileData1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+
+	var f types.HashedFile
+	if err := json.NewDecoder(resp.Body).Decode(&f); err != nil {
+		t.Fatal(err)
+	}
+
+	utils.Assert(1, len(f.Hashes), t)
+	utils.Assert(byte(types.ChunkVersionSHA256), f.Hashes[0].Version, t)
+	utils.Assert(io.CalcHash([]byte(fileData1), types.ChunkVersionSHA256), f.Hashes[0].Hash, t)
+	utils.Assert(64, len(f.Hashes[0].Hash), t)
+
+	url = buildURL("dataset/workspace/dataset/versions/1.0.0/raw/file.txt")
+	resp, err = client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(fileData1, mustRead(resp.Body), t)
+
+	// Chunk uploaded with SHA512 hash must not be accepted as SHA256 chunk.
+	url = buildURL(fmt.Sprintf("chunks/%v/%v", io.CalcHash([]byte(fileData2), types.ChunkVersion), types.ChunkVersionSHA256))
+	resp, err = client.Post(url, "application/json", bytes.NewBufferString(fileData2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusBadRequest, resp.StatusCode, t)
+
+	url = buildURL("dataset/workspace/dataset/versions/1.0.0/upload/file.txt?hash=md5")
+	resp, err = client.Post(url, "application/json", bytes.NewBufferString(fileData1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusBadRequest, resp.StatusCode, t)
+}
+
 func TestFileManyChunksPartlyRead(t *testing.T) {
 	fname := getFname()
 	setup(fname)
@@ -145,6 +347,88 @@ func TestFileManyChunksPartlyRead(t *testing.T) {
 	utils.Assert(targetLen, len(data), t)
 }
 
+func TestReadFileRange(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+
+	fileData := make([]byte, 2500000)
+	rand.New(rand.NewSource(1)).Read(fileData)
+
+	url := buildURL("dataset/workspace/dataset/versions/1.0.0/upload/file.bin")
+	resp, err := client.Post(url, "application/json", bytes.NewBuffer(fileData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+	var f types.HashedFile
+	if err := json.NewDecoder(resp.Body).Decode(&f); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(url string, headers map[string]string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// Range across the chunk boundary.
+	url = buildURL("dataset/workspace/dataset/versions/1.0.0/raw/file.bin")
+	resp = get(url, map[string]string{"Range": "bytes=1023990-1024009"})
+	utils.Assert(http.StatusPartialContent, resp.StatusCode, t)
+	etag := resp.Header.Get("ETag")
+	utils.Assert(string(fileData[1023990:1024010]), mustRead(resp.Body), t)
+
+	// Suffix range.
+	resp = get(url, map[string]string{"Range": "bytes=-100"})
+	utils.Assert(http.StatusPartialContent, resp.StatusCode, t)
+	utils.Assert(string(fileData[len(fileData)-100:]), mustRead(resp.Body), t)
+
+	// Multiple ranges.
+	resp = get(url, map[string]string{"Range": "bytes=0-9,2048000-2048009"})
+	utils.Assert(http.StatusPartialContent, resp.StatusCode, t)
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := multipart.NewReader(resp.Body, params["boundary"])
+	for _, start := range []int{0, 2048000} {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(part)
+		utils.Assert(string(fileData[start:start+10]), string(data), t)
+	}
+	resp.Body.Close()
+
+	// If-Range with stale ETag returns the whole file.
+	resp = get(url, map[string]string{"Range": "bytes=0-9", "If-Range": etag})
+	utils.Assert(http.StatusPartialContent, resp.StatusCode, t)
+	resp.Body.Close()
+	resp = get(url, map[string]string{"Range": "bytes=0-9", "If-Range": `"stale"`})
+	utils.Assert(http.StatusOK, resp.StatusCode, t)
+	utils.Assert(len(fileData), len(mustRead(resp.Body)), t)
+
+	resp = get(url, map[string]string{"Range": "bytes=3000000-"})
+	utils.Assert(http.StatusRequestedRangeNotSatisfiable, resp.StatusCode, t)
+	resp.Body.Close()
+
+	// Chunk download.
+	url = buildURL(fmt.Sprintf("chunks/%v/download/%v", f.Hashes[1].Hash, f.Hashes[1].Version))
+	resp = get(url, map[string]string{"Range": "bytes=100-199"})
+	utils.Assert(http.StatusPartialContent, resp.StatusCode, t)
+	start := int(f.Hashes[0].Size) + 100
+	utils.Assert(string(fileData[start:start+100]), mustRead(resp.Body), t)
+}
+
 func TestUploadFileNotFound(t *testing.T) {
 	fname := getFname()
 	setup(fname)
@@ -732,3 +1016,181 @@ func TestCommitNoDelete(t *testing.T) {
 
 	utils.Assert(http.StatusForbidden, resp.StatusCode, t)
 }
+
+func TestMoveCopyFiles(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+
+	files := map[string]string{"a.txt": fileData1, "dir/b.txt": fileData2, "dir/c.txt": fileData3}
+	for path, data := range files {
+		url := buildURL("dataset/workspace/dataset/versions/1.0.0/upload/" + path)
+		resp, err := client.Post(url, "application/json", bytes.NewBufferString(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		utils.Assert(http.StatusCreated, resp.StatusCode, t)
+	}
+
+	operation := func(version, op, body string, status int) types.FileOperationResult {
+		url := buildURL(fmt.Sprintf("dataset/workspace/dataset/versions/%v/%v", version, op))
+		resp, err := client.Post(url, "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		utils.Assert(status, resp.StatusCode, t)
+		var res types.FileOperationResult
+		if status == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res
+	}
+	read := func(version, path string) (int, string) {
+		resp, err := client.Get(buildURL(fmt.Sprintf("dataset/workspace/dataset/versions/%v/raw/%v", version, path)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, mustRead(resp.Body)
+	}
+
+	// Rename the dir
+	res := operation("1.0.0", "move", `{"from": "dir", "to": "moved"}`, http.StatusOK)
+	utils.Assert(2, res.Files, t)
+	code, _ := read("1.0.0", "dir/b.txt")
+	utils.Assert(http.StatusNotFound, code, t)
+	code, data := read("1.0.0", "moved/b.txt")
+	utils.Assert(http.StatusOK, code, t)
+	utils.Assert(fileData2, data, t)
+
+	// Destination exists
+	operation("1.0.0", "move", `{"from": "a.txt", "to": "moved/b.txt"}`, http.StatusConflict)
+	operation("1.0.0", "move", `{"from": "a.txt", "to": "moved"}`, http.StatusConflict)
+	operation("1.0.0", "move", `{"from": "moved", "to": "moved/sub"}`, http.StatusBadRequest)
+	operation("1.0.0", "move", `{"from": "missing", "to": "other"}`, http.StatusNotFound)
+	operation("1.0.0", "move", `{"from": "a.txt", "to": "moved/b.txt", "conflict": "overwrite"}`, http.StatusOK)
+	runGC()
+	time.Sleep(100 * time.Millisecond)
+	code, data = read("1.0.0", "moved/b.txt")
+	utils.Assert(http.StatusOK, code, t)
+	utils.Assert(fileData1, data, t)
+
+	res = operation("1.0.0", "copy", `{"from": "moved/c.txt", "to": "c.txt"}`, http.StatusOK)
+	utils.Assert(1, res.Files, t)
+	utils.Assert(int64(len(fileData3)), res.Size, t)
+
+	// Copy from the committed version
+	resp, err := client.Post(buildURL("dataset/workspace/dataset/versions/1.0.0/commit"), "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusOK, resp.StatusCode, t)
+	operation("1.0.0", "copy", `{"from": "c.txt", "to": "d.txt"}`, http.StatusForbidden)
+
+	resp, err = client.Post(buildURL("dataset/workspace/dataset/versions/1.0.0/clone/1.0.1"), "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+	req, _ := http.NewRequest("DELETE", buildURL("dataset/workspace/dataset/versions/1.0.1/upload/moved"), nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusNoContent, resp.StatusCode, t)
+
+	res = operation("1.0.1", "copy", `{"from": "moved", "to": "restored", "from_version": "1.0.0"}`, http.StatusOK)
+	utils.Assert(2, res.Files, t)
+	code, data = read("1.0.1", "restored/c.txt")
+	utils.Assert(http.StatusOK, code, t)
+	utils.Assert(fileData3, data, t)
+
+	resp, err = client.Get(buildURL("dataset/workspace/dataset/versions/1.0.1/get"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v types.Version
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(int64(3), v.FileCount, t)
+	utils.Assert(int64(len(fileData1)+2*len(fileData3)), v.SizeBytes, t)
+}
+
+func TestCherryPick(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+
+	for _, dsv := range []*db.DatasetVersion{
+		{Workspace: "another-ws", Name: "other", Version: "2.0.0", Type: "dataset", Editing: true},
+		{Workspace: "workspace", Name: "dataset", Version: "3.0.0", Type: "dataset", Editing: true},
+	} {
+		if err := db.DbMgr.CreateDatasetVersion(dsv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.DbMgr.CreateDataset(&db.Dataset{Workspace: "another-ws", Name: "other", Type: "dataset"}); err != nil {
+		t.Fatal(err)
+	}
+
+	upload := func(path, data string) {
+		resp, err := client.Post(buildURL(path), "application/json", bytes.NewBufferString(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		utils.Assert(http.StatusCreated, resp.StatusCode, t)
+	}
+	upload("dataset/workspace/dataset/versions/1.0.0/upload/labels/a.txt", fileData1)
+	upload("dataset/workspace/dataset/versions/1.0.0/upload/other.txt", fileData2)
+	upload("dataset/another-ws/other/versions/2.0.0/upload/images/x.txt", fileData3)
+	upload("dataset/another-ws/other/versions/2.0.0/upload/images/y.txt", fileData1)
+	upload("dataset/workspace/dataset/versions/3.0.0/upload/images/x.txt", fileData2)
+
+	pick := func(body string, status int) types.FileOperationResult {
+		url := buildURL("dataset/workspace/dataset/versions/3.0.0/cherry-pick")
+		resp, err := client.Post(url, "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		utils.Assert(status, resp.StatusCode, t)
+		var res types.FileOperationResult
+		if status == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res
+	}
+	read := func(path string) string {
+		resp, err := client.Get(buildURL("dataset/workspace/dataset/versions/3.0.0/raw/" + path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		utils.Assert(http.StatusOK, resp.StatusCode, t)
+		return mustRead(resp.Body)
+	}
+
+	res := pick(`{"version": "1.0.0", "paths": ["labels", "labels/a.txt"]}`, http.StatusOK)
+	utils.Assert(types.FileOperationResult{Files: 1, Size: int64(len(fileData1))}, res, t)
+	utils.Assert(fileData1, read("labels/a.txt"), t)
+
+	other := `"workspace": "another-ws", "name": "other", "version": "2.0.0", "paths": ["images"]`
+	pick("{"+other+"}", http.StatusConflict)
+	pick("{"+other+`, "conflict": "merge"}`, http.StatusBadRequest)
+
+	res = pick("{"+other+`, "conflict": "skip"}`, http.StatusOK)
+	utils.Assert(types.FileOperationResult{Files: 1, Size: int64(len(fileData1)), Skipped: 1}, res, t)
+	utils.Assert(fileData2, read("images/x.txt"), t)
+	utils.Assert(fileData1, read("images/y.txt"), t)
+
+	res = pick("{"+other+`, "conflict": "overwrite"}`, http.StatusOK)
+	utils.Assert(2, res.Files, t)
+	utils.Assert(fileData3, read("images/x.txt"), t)
+
+	pick(`{"version": "1.0.0", "paths": ["missing"]}`, http.StatusNotFound)
+	pick(`{"version": "9.9.9", "paths": ["labels"]}`, http.StatusNotFound)
+}
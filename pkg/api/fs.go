@@ -109,6 +109,11 @@ func (api *API) saveFS(req *restful.Request, resp *restful.Response) {
 		return
 	}
 
+	if err = api.checkStructureQuota(currentType(req), workspace, name, version, structure.Files); err != nil {
+		WriteError(resp, err)
+		return
+	}
+
 	// Wait
 	//gc.WaitGCCompleted()
 
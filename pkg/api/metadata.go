@@ -0,0 +1,100 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/emicklei/go-restful"
+	"github.com/kuberlab/pluk/pkg/types"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+// getMetadata returns metadata of the dataset or of the version
+// if the version is in the path.
+func (api *API) getMetadata(req *restful.Request, resp *restful.Response) {
+	workspace := req.PathParameter("workspace")
+	name := req.PathParameter("name")
+	version := req.PathParameter("version")
+	master := api.masterClient(req)
+
+	dataset, err := api.ds.GetDataset(currentType(req), workspace, name, master)
+	if err != nil {
+		WriteError(resp, EntityNotFoundError(req, name, err))
+		return
+	}
+	meta, err := dataset.Metadata(version)
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+	resp.WriteEntity(meta)
+}
+
+func (api *API) setMetadata(req *restful.Request, resp *restful.Response) {
+	workspace := req.PathParameter("workspace")
+	name := req.PathParameter("name")
+	version := req.PathParameter("version")
+	master := api.masterClient(req)
+
+	meta := types.Metadata{}
+	if err := req.ReadEntity(&meta); err != nil {
+		WriteStatusError(resp, http.StatusBadRequest, err)
+		return
+	}
+
+	dataset, err := api.ds.GetDataset(currentType(req), workspace, name, master)
+	if err != nil {
+		WriteError(resp, EntityNotFoundError(req, name, err))
+		return
+	}
+	if err = dataset.SetMetadata(version, meta); err != nil {
+		WriteError(resp, err)
+		return
+	}
+	res, err := dataset.Metadata(version)
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+	resp.WriteEntity(res)
+}
+
+func (api *API) deleteLabel(req *restful.Request, resp *restful.Response) {
+	workspace := req.PathParameter("workspace")
+	name := req.PathParameter("name")
+	version := req.PathParameter("version")
+	key := req.PathParameter("key")
+	master := api.masterClient(req)
+
+	dataset, err := api.ds.GetDataset(currentType(req), workspace, name, master)
+	if err != nil {
+		WriteError(resp, EntityNotFoundError(req, name, err))
+		return
+	}
+	if err = dataset.DeleteLabel(version, key); err != nil {
+		WriteError(resp, err)
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) searchDatasets(req *restful.Request, resp *restful.Response) {
+	workspace := req.PathParameter("workspace")
+	// Empty type searches all entity types.
+	eType := req.QueryParameter("type")
+
+	selector, err := utils.ParseLabelSelector(req.QueryParameter("labels"))
+	if err != nil {
+		WriteStatusError(resp, http.StatusBadRequest, err)
+		return
+	}
+
+	items, err := api.ds.SearchDatasets(eType, workspace, selector, req.QueryParameter("q"))
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+	ds := types.DataSetList{Items: items}
+	sort.Sort(ds)
+	resp.WriteEntity(ds)
+}
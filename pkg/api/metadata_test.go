@@ -0,0 +1,123 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/kuberlab/pluk/pkg/db"
+	"github.com/kuberlab/pluk/pkg/types"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+func setTestMetadata(t *testing.T, path string, meta types.Metadata) int {
+	data, _ := json.Marshal(meta)
+	req, _ := http.NewRequest(http.MethodPut, buildURL(path+"/metadata"), bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func listTestDatasets(t *testing.T, url string) []types.Dataset {
+	resp, err := client.Get(buildURL(url))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusOK, resp.StatusCode, t)
+	var list types.DataSetList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	return list.Items
+}
+
+func TestMetadataLabels(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+
+	resp, err := client.Post(buildURL("dataset/workspace/other"), "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+
+	code := setTestMetadata(t, "dataset/workspace/dataset", types.Metadata{
+		Description: "Road scenes",
+		Labels:      map[string]string{"task": "segmentation", "source": "camera-3"},
+	})
+	utils.Assert(http.StatusOK, code, t)
+	code = setTestMetadata(t, "dataset/workspace/other", types.Metadata{
+		Labels: map[string]string{"task": "detection"},
+	})
+	utils.Assert(http.StatusOK, code, t)
+	code = setTestMetadata(t, "dataset/workspace/other", types.Metadata{
+		Labels: map[string]string{"bad key": "value"},
+	})
+	utils.Assert(http.StatusBadRequest, code, t)
+
+	// Version metadata is separate.
+	code = setTestMetadata(t, "dataset/workspace/dataset/versions/1.0.0", types.Metadata{
+		Description: "First cut",
+		Labels:      map[string]string{"reviewed": "true"},
+	})
+	utils.Assert(http.StatusOK, code, t)
+	code = setTestMetadata(t, "dataset/workspace/dataset/versions/9.9.9", types.Metadata{})
+	utils.Assert(http.StatusNotFound, code, t)
+
+	resp, err = client.Get(buildURL("dataset/workspace/dataset/versions/1.0.0/metadata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta types.Metadata
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert("First cut", meta.Description, t)
+	utils.Assert(map[string]string{"reviewed": "true"}, meta.Labels, t)
+
+	items := listTestDatasets(t, "dataset/workspace?labels=task=segmentation")
+	utils.Assert(1, len(items), t)
+	utils.Assert("dataset", items[0].Name, t)
+	utils.Assert("Road scenes", items[0].Description, t)
+	utils.Assert("camera-3", items[0].Labels["source"], t)
+
+	utils.Assert(2, len(listTestDatasets(t, "dataset/workspace?labels=task")), t)
+	utils.Assert(1, len(listTestDatasets(t, "workspaces/workspace/search?labels=task,!source")), t)
+	utils.Assert(1, len(listTestDatasets(t, "workspaces/workspace/search?labels=task!=detection")), t)
+	utils.Assert(1, len(listTestDatasets(t, "workspaces/workspace/search?q=road")), t)
+	utils.Assert(0, len(listTestDatasets(t, "workspaces/workspace/search?type=model&labels=task")), t)
+
+	req, _ := http.NewRequest(http.MethodDelete, buildURL("dataset/workspace/dataset/metadata/labels/task"), nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusNoContent, resp.StatusCode, t)
+	items = listTestDatasets(t, "workspaces/workspace/search?labels=task")
+	utils.Assert(1, len(items), t)
+	utils.Assert("other", items[0].Name, t)
+
+	// Labels of a deleted version are deleted with it.
+	req, _ = http.NewRequest(http.MethodDelete, buildURL("dataset/workspace/dataset/versions/1.0.0"), nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusNoContent, resp.StatusCode, t)
+	labels, err := db.DbMgr.ListLabels("dataset", "workspace", "dataset", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(0, len(labels), t)
+	labels, err = db.DbMgr.ListLabels("dataset", "workspace", "dataset", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(1, len(labels), t)
+}
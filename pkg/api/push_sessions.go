@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/kuberlab/lib/pkg/errors"
+	"github.com/kuberlab/pluk/pkg/db"
+	"github.com/kuberlab/pluk/pkg/types"
+	"github.com/pborman/uuid"
+)
+
+// Header with the push session ID, chunks checked or uploaded
+// with it are pinned to the session.
+const pushSessionHeader = "X-Push-Session"
+
+func (api *API) createPushSession(req *restful.Request, resp *restful.Response) {
+	session := &db.PushSession{
+		ID:        uuid.New(),
+		Workspace: req.PathParameter("workspace"),
+		Name:      req.PathParameter("name"),
+		Type:      currentType(req),
+		Version:   req.PathParameter("version"),
+	}
+	if err := api.mgr.CreatePushSession(session); err != nil {
+		WriteError(resp, err)
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusCreated, pushSessionInfo(session))
+}
+
+func (api *API) closePushSession(req *restful.Request, resp *restful.Response) {
+	id := req.PathParameter("session")
+	session, err := api.mgr.GetPushSession(id)
+	if err != nil || session.Type != currentType(req) ||
+		session.Workspace != req.PathParameter("workspace") ||
+		session.Name != req.PathParameter("name") ||
+		session.Version != req.PathParameter("version") {
+		WriteErrorString(resp, http.StatusNotFound, fmt.Sprintf("Push session %v not found", id))
+		return
+	}
+	if err = api.mgr.DeletePushSession(session.ID); err != nil {
+		WriteError(resp, err)
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+func pushSessionInfo(session *db.PushSession) *types.PushSession {
+	return &types.PushSession{
+		ID:        session.ID,
+		Workspace: session.Workspace,
+		Name:      session.Name,
+		Version:   session.Version,
+	}
+}
+
+// pinChunks pins hashes to the push session of the request, if any.
+func (api *API) pinChunks(req *restful.Request, hashes ...string) error {
+	id := req.HeaderParameter(pushSessionHeader)
+	if id == "" {
+		return nil
+	}
+	if _, err := api.mgr.GetPushSession(id); err != nil {
+		return errors.NewStatus(http.StatusNotFound, fmt.Sprintf("Push session %v not found", id))
+	}
+	return api.mgr.PinPushChunks(id, hashes)
+}
@@ -3,11 +3,15 @@ package api
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/kuberlab/pluk/pkg/db"
+	"github.com/kuberlab/pluk/pkg/gc"
 	plukio "github.com/kuberlab/pluk/pkg/io"
 	"github.com/kuberlab/pluk/pkg/types"
 	"github.com/kuberlab/pluk/pkg/utils"
@@ -19,7 +23,7 @@ func TestPushSameChunk(t *testing.T) {
 	dbPrepare(t)
 	defer teardown(fname)
 
-	chunkHash := utils.CalcHash([]byte(fileData1))
+	chunkHash := plukio.CalcHash([]byte(fileData1), types.ChunkVersion)
 
 	// Upload chunk
 	url := buildURL(fmt.Sprintf("chunks/%v", chunkHash))
@@ -95,7 +99,7 @@ func TestPushFewFiles(t *testing.T) {
 	fileNum := 5
 	for i := 0; i < fileNum; i++ {
 		data := fmt.Sprintf("test%v test%v", i, i)
-		hash := utils.CalcHash([]byte(data))
+		hash := plukio.CalcHash([]byte(data), types.ChunkVersion)
 		// Upload chunk
 		url := buildURL(fmt.Sprintf("chunks/%v", hash))
 		resp, err := client.Post(url, "application/json", bytes.NewBufferString(data))
@@ -159,7 +163,7 @@ func TestPushSameChunksOldNewAccessible(t *testing.T) {
 	fileNum := 5
 	for i := 0; i < fileNum; i++ {
 		data := fmt.Sprintf("test%v test%v", i, i)
-		hash := utils.CalcHash([]byte(data))
+		hash := plukio.CalcHash([]byte(data), types.ChunkVersion)
 		// Upload chunk
 		url := buildURL(fmt.Sprintf("chunks/%v", hash))
 		resp, err := client.Post(url, "application/json", bytes.NewBufferString(data))
@@ -191,7 +195,7 @@ func TestPushSameChunksOldNewAccessible(t *testing.T) {
 	structure = &types.FileStructure{Files: make([]*types.HashedFile, 0)}
 	for i := 0; i < fileNum; i++ {
 		data := fmt.Sprintf("test%v test%v", i, i)
-		hash := utils.CalcHash([]byte(data))
+		hash := plukio.CalcHash([]byte(data), types.ChunkVersion)
 		// Upload chunk
 		url := buildURL(fmt.Sprintf("chunks/%v/1", hash))
 		resp, err := client.Post(url, "application/json", bytes.NewBufferString(data))
@@ -255,7 +259,7 @@ func TestPushSameChunksNewOldAccessible(t *testing.T) {
 	fileNum := 5
 	for i := 0; i < fileNum; i++ {
 		data := fmt.Sprintf("test%v test%v", i, i)
-		hash := utils.CalcHash([]byte(data))
+		hash := plukio.CalcHash([]byte(data), types.ChunkVersion)
 		// Upload chunk
 		url := buildURL(fmt.Sprintf("chunks/%v/1", hash))
 		resp, err := client.Post(url, "application/json", bytes.NewBufferString(data))
@@ -287,7 +291,7 @@ func TestPushSameChunksNewOldAccessible(t *testing.T) {
 	structure = &types.FileStructure{Files: make([]*types.HashedFile, 0)}
 	for i := 0; i < fileNum; i++ {
 		data := fmt.Sprintf("test%v test%v", i, i)
-		hash := utils.CalcHash([]byte(data))
+		hash := plukio.CalcHash([]byte(data), types.ChunkVersion)
 		// Upload chunk
 		url := buildURL(fmt.Sprintf("chunks/%v", hash))
 		resp, err := client.Post(url, "application/json", bytes.NewBufferString(data))
@@ -351,7 +355,7 @@ func TestPushManyFilesVersion1(t *testing.T) {
 	fileNum := 100
 	for i := 0; i < fileNum; i++ {
 		data := fmt.Sprintf("test%v test%v", i, i)
-		hash := utils.CalcHash([]byte(data))
+		hash := plukio.CalcHash([]byte(data), types.ChunkVersion)
 		// Upload chunk
 		url := buildURL(fmt.Sprintf("chunks/%v/1", hash))
 		resp, err := client.Post(url, "application/json", bytes.NewBufferString(data))
@@ -415,7 +419,7 @@ func TestPushManyFiles2(t *testing.T) {
 	fileNum := 100
 	for i := 0; i < fileNum; i++ {
 		data := fmt.Sprintf("test%v test%v", i, i)
-		hash := utils.CalcHash([]byte(data))
+		hash := plukio.CalcHash([]byte(data), types.ChunkVersion)
 		// Upload chunk
 		url := buildURL(fmt.Sprintf("chunks/%v", hash))
 		resp, err := client.Post(url, "application/json", bytes.NewBufferString(data))
@@ -543,3 +547,180 @@ func TestPushFileRepeatedChunk(t *testing.T) {
 		utils.Assert(int64(len(data)), resp.ContentLength, t)
 	}
 }
+
+func TestCheckChunksBatch(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+
+	chunkHash := plukio.CalcHash([]byte(fileData1), types.ChunkVersion)
+	url := buildURL(fmt.Sprintf("chunks/%v/%v", chunkHash, types.ChunkVersion))
+	resp, err := client.Post(url, "application/json", bytes.NewBufferString(fileData1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+
+	size := int64(len(fileData1))
+	hashes := []types.Hash{
+		{Hash: chunkHash, Size: size, Version: types.ChunkVersion},
+		{Hash: chunkHash, Size: size + 1, Version: types.ChunkVersion},
+		{Hash: plukio.CalcHash([]byte(fileData2), types.ChunkVersion), Size: int64(len(fileData2)), Version: types.ChunkVersion},
+	}
+	data, _ := json.Marshal(hashes)
+	resp, err = client.Post(buildURL("chunks/check"), "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusOK, resp.StatusCode, t)
+
+	missing := make([]types.Hash, 0)
+	if err := json.NewDecoder(resp.Body).Decode(&missing); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(2, len(missing), t)
+	utils.Assert(hashes[1], missing[0], t)
+	utils.Assert(hashes[2], missing[1], t)
+}
+
+func TestPushSessionPinsChunks(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+	defer os.Unsetenv("GC_GRACE_PERIOD")
+
+	resp, err := client.Post(buildURL("dataset/workspace/new/versions/1.0.0/push-sessions"), "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+	session := types.PushSession{}
+	if err = json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		t.Fatal(err)
+	}
+
+	// Upload chunk which is not referenced by any file yet.
+	chunkHash := plukio.CalcHash([]byte(fileData1), types.ChunkVersion)
+	url := buildURL(fmt.Sprintf("chunks/%v/%v", chunkHash, types.ChunkVersion))
+	req, _ := http.NewRequest(http.MethodPost, url, bytes.NewBufferString(fileData1))
+	req.Header.Set("X-Push-Session", session.ID)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+
+	req, _ = http.NewRequest(http.MethodPost, url, bytes.NewBufferString(fileData1))
+	req.Header.Set("X-Push-Session", "unknown")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusNotFound, resp.StatusCode, t)
+
+	pinned, err := db.DbMgr.ListPushChunks(db.PushChunk{SessionID: session.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(1, len(pinned), t)
+	utils.Assert(chunkHash, pinned[0].Hash, t)
+
+	// Pinned chunk is kept even without grace period.
+	os.Setenv("GC_GRACE_PERIOD", "0s")
+	gc.ClearChunks(db.DbMgr)
+	time.Sleep(time.Millisecond * 200)
+	_, exists := plukio.CheckLocalChunk(chunkHash, types.ChunkVersion)
+	utils.Assert(true, exists, t)
+
+	req, _ = http.NewRequest(
+		http.MethodDelete,
+		buildURL(fmt.Sprintf("dataset/workspace/new/versions/1.0.0/push-sessions/%v", session.ID)),
+		nil,
+	)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusNoContent, resp.StatusCode, t)
+	pinned, err = db.DbMgr.ListPushChunks(db.PushChunk{SessionID: session.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(0, len(pinned), t)
+
+	// Recent chunk is kept within grace period.
+	os.Setenv("GC_GRACE_PERIOD", "1h")
+	gc.ClearChunks(db.DbMgr)
+	time.Sleep(time.Millisecond * 200)
+	_, exists = plukio.CheckLocalChunk(chunkHash, types.ChunkVersion)
+	utils.Assert(true, exists, t)
+
+	os.Setenv("GC_GRACE_PERIOD", "0s")
+	gc.ClearChunks(db.DbMgr)
+	for i := 0; i < 20 && exists; i++ {
+		time.Sleep(time.Millisecond * 100)
+		_, exists = plukio.CheckLocalChunk(chunkHash, types.ChunkVersion)
+	}
+	utils.Assert(false, exists, t)
+}
+
+func TestGCKeepsPinnedChunks(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+	defer os.Unsetenv("GC_GRACE_PERIOD")
+
+	url := buildURL("dataset/workspace/dataset/versions/1.0.0/upload/file.txt")
+	resp, err := client.Post(url, "application/json", bytes.NewBufferString(fileData1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+
+	// The next push of the same content has the chunk already.
+	session := &db.PushSession{ID: "pin", Type: "dataset", Workspace: "workspace", Name: "dataset", Version: "1.0.1"}
+	if err = db.DbMgr.CreatePushSession(session); err != nil {
+		t.Fatal(err)
+	}
+	chunkHash := plukio.CalcHash([]byte(fileData1), types.ChunkVersion)
+	if err = db.DbMgr.PinPushChunks(session.ID, []string{chunkHash}); err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, url, nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusNoContent, resp.StatusCode, t)
+
+	os.Setenv("GC_GRACE_PERIOD", "0s")
+	gc.GoGC()
+	time.Sleep(time.Millisecond * 200)
+	_, err = db.DbMgr.GetChunk(chunkHash, types.ChunkVersion)
+	utils.Assert(nil, err, t)
+	resp, err = client.Get(buildURL(fmt.Sprintf("chunks/%v/download/%v", chunkHash, types.ChunkVersion)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusOK, resp.StatusCode, t)
+	data, _ := ioutil.ReadAll(resp.Body)
+	utils.Assert(fileData1, string(data), t)
+
+	// Released chunk is kept within grace period.
+	if err = db.DbMgr.DeletePushSession(session.ID); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("GC_GRACE_PERIOD", "1h")
+	gc.GoGC()
+	_, err = db.DbMgr.GetChunk(chunkHash, types.ChunkVersion)
+	utils.Assert(nil, err, t)
+
+	os.Setenv("GC_GRACE_PERIOD", "0s")
+	gc.GoGC()
+	_, err = db.DbMgr.GetChunk(chunkHash, types.ChunkVersion)
+	utils.Assert(true, err != nil, t)
+}
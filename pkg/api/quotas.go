@@ -0,0 +1,225 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/emicklei/go-restful"
+	"github.com/kuberlab/lib/pkg/errors"
+	"github.com/kuberlab/pluk/pkg/db"
+	"github.com/kuberlab/pluk/pkg/types"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+type WorkspaceUsage struct {
+	db.Usage
+	Quota *db.Quota `json:"quota,omitempty"`
+}
+
+// isAdminRequest reports whether the request may change instance settings:
+// it came through the internal API, has the internal key or auth is disabled.
+func isAdminRequest(req *restful.Request) bool {
+	if strings.HasPrefix(req.Request.URL.Path, utils.InternalPrefix) {
+		return true
+	}
+	internal := req.HeaderParameter("Internal")
+	if internal != "" && utils.InternalKey() == internal {
+		return true
+	}
+	return utils.AuthValidationURL() == "" && !utils.HasMasters()
+}
+
+func (api *API) listQuotas(req *restful.Request, resp *restful.Response) {
+	if !isAdminRequest(req) {
+		WriteErrorString(resp, http.StatusForbidden, "Only admin can list quotas.")
+		return
+	}
+	quotas, err := api.mgr.ListQuotas()
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+	resp.WriteEntity(quotas)
+}
+
+func (api *API) getQuota(req *restful.Request, resp *restful.Response) {
+	workspace := req.PathParameter("workspace")
+	if !isAdminRequest(req) {
+		if err := api.checkWorkspaceMember(req, workspace); err != nil {
+			WriteError(resp, err)
+			return
+		}
+	}
+	quota, err := api.mgr.GetQuota(workspace)
+	if err != nil {
+		WriteErrorString(resp, http.StatusNotFound, fmt.Sprintf("Quota for workspace %v not found", workspace))
+		return
+	}
+	resp.WriteEntity(quota)
+}
+
+// checkWorkspaceMember checks that the request credentials belong to the workspace.
+// Unlike reads of datasets, public workspaces are not open to others.
+func (api *API) checkWorkspaceMember(req *restful.Request, workspace string) error {
+	if ws := req.HeaderParameter("X-Workspace-Name"); ws != "" && ws != workspace {
+		return errors.NewStatus(http.StatusForbidden, "Forbidden access to another workspace.")
+	}
+	return api.checkReadAccess(req, "dataset", workspace)
+}
+
+func (api *API) setQuota(req *restful.Request, resp *restful.Response) {
+	if !isAdminRequest(req) {
+		WriteErrorString(resp, http.StatusForbidden, "Only admin can set quotas.")
+		return
+	}
+	quota := new(db.Quota)
+	if err := req.ReadEntity(quota); err != nil {
+		WriteStatusError(resp, http.StatusBadRequest, err)
+		return
+	}
+	if quota.MaxSize < 0 || quota.MaxChunkSize < 0 {
+		WriteErrorString(resp, http.StatusBadRequest, "Quota limits must not be negative")
+		return
+	}
+	quota.Workspace = req.PathParameter("workspace")
+	if err := api.mgr.SetQuota(quota); err != nil {
+		WriteError(resp, err)
+		return
+	}
+	resp.WriteEntity(quota)
+}
+
+func (api *API) deleteQuota(req *restful.Request, resp *restful.Response) {
+	if !isAdminRequest(req) {
+		WriteErrorString(resp, http.StatusForbidden, "Only admin can delete quotas.")
+		return
+	}
+	if err := api.mgr.DeleteQuota(req.PathParameter("workspace")); err != nil {
+		WriteError(resp, err)
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) workspaceUsage(req *restful.Request, resp *restful.Response) {
+	workspace := req.PathParameter("workspace")
+	usage, err := api.mgr.GetUsage(workspace)
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+	result := WorkspaceUsage{Usage: *usage}
+	if quota, err := api.mgr.GetQuota(workspace); err == nil {
+		result.Quota = quota
+	}
+	resp.WriteEntity(result)
+}
+
+// checkQuota returns an error if adding size logical bytes and chunkSize
+// unique chunk bytes to the workspace exceeds its quota.
+func (api *API) checkQuota(workspace string, size, chunkSize int64) error {
+	quota, err := api.mgr.GetQuota(workspace)
+	if err != nil {
+		// No quota.
+		return nil
+	}
+	return api.checkUsage(quota, size, chunkSize)
+}
+
+// checkChunkQuota checks the quota for a new chunk of the given length.
+// Chunks of unknown length are rejected if the workspace has a chunk quota.
+func (api *API) checkChunkQuota(workspace string, length int64) error {
+	quota, err := api.mgr.GetQuota(workspace)
+	if err != nil {
+		// No quota.
+		return nil
+	}
+	if length < 0 && quota.MaxChunkSize > 0 {
+		return errors.NewStatus(
+			http.StatusLengthRequired,
+			fmt.Sprintf("Content-Length is required by workspace %v chunk quota", workspace),
+		)
+	}
+	return api.checkUsage(quota, 0, length)
+}
+
+func (api *API) checkUsage(quota *db.Quota, size, chunkSize int64) error {
+	if (quota.MaxSize == 0 || size <= 0) && (quota.MaxChunkSize == 0 || chunkSize <= 0) {
+		return nil
+	}
+	usage, err := api.mgr.GetUsage(quota.Workspace)
+	if err != nil {
+		return err
+	}
+	if quota.MaxSize > 0 && size > 0 && usage.Size+size > quota.MaxSize {
+		return errors.NewStatus(
+			http.StatusRequestEntityTooLarge,
+			fmt.Sprintf(
+				"Workspace %v quota exceeded: %v of %v bytes used, %v more requested",
+				quota.Workspace, usage.Size, quota.MaxSize, size,
+			),
+		)
+	}
+	if quota.MaxChunkSize > 0 && chunkSize > 0 && usage.ChunkSize+chunkSize > quota.MaxChunkSize {
+		return errors.NewStatus(
+			http.StatusRequestEntityTooLarge,
+			fmt.Sprintf(
+				"Workspace %v chunk quota exceeded: %v of %v bytes used, %v more requested",
+				quota.Workspace, usage.ChunkSize, quota.MaxChunkSize, chunkSize,
+			),
+		)
+	}
+	return nil
+}
+
+// checkStructureQuota checks whether the files saved to the version fit into
+// the workspace quota. Files replace existing ones with the same path and
+// chunks already referenced by the workspace don't take extra space.
+func (api *API) checkStructureQuota(dsType, workspace, name, version string, files []*types.HashedFile) error {
+	quota, err := api.mgr.GetQuota(workspace)
+	if err != nil {
+		// No quota.
+		return nil
+	}
+
+	existing, err := api.mgr.ListFiles(
+		db.File{DatasetType: dsType, Workspace: workspace, DatasetName: name, Version: version},
+	)
+	if err != nil {
+		return err
+	}
+	existingSize := make(map[string]int64)
+	for _, f := range existing {
+		existingSize[f.Path] = f.Size
+	}
+
+	var size int64 = 0
+	// Chunks are keyed by hash and version.
+	chunkSizes := make(map[types.Hash]int64)
+	chunks := make([]*db.Chunk, 0)
+	for _, f := range files {
+		size += f.Size - existingSize[f.Path]
+		for _, h := range f.Hashes {
+			key := types.Hash{Hash: h.Hash, Version: h.Version}
+			if _, ok := chunkSizes[key]; !ok {
+				chunks = append(chunks, &db.Chunk{Hash: h.Hash, Version: h.Version})
+			}
+			chunkSizes[key] = h.Size
+		}
+	}
+
+	known, err := api.mgr.ListWorkspaceChunks(workspace, chunks)
+	if err != nil {
+		return err
+	}
+	for _, c := range known {
+		delete(chunkSizes, types.Hash{Hash: c.Hash, Version: c.Version})
+	}
+	var chunkSize int64 = 0
+	for _, s := range chunkSizes {
+		chunkSize += s
+	}
+
+	return api.checkUsage(quota, size, chunkSize)
+}
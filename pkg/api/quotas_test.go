@@ -0,0 +1,246 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kuberlab/pluk/pkg/db"
+	plukio "github.com/kuberlab/pluk/pkg/io"
+	"github.com/kuberlab/pluk/pkg/types"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+func setTestQuota(t *testing.T, quota db.Quota) {
+	data, _ := json.Marshal(quota)
+	req, _ := http.NewRequest(http.MethodPut, buildURL("admin/quotas/workspace"), bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	utils.Assert(http.StatusOK, resp.StatusCode, t)
+}
+
+func getTestUsage(t *testing.T) WorkspaceUsage {
+	resp, err := client.Get(buildURL("workspaces/workspace/usage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusOK, resp.StatusCode, t)
+
+	var usage WorkspaceUsage
+	if err := json.NewDecoder(resp.Body).Decode(&usage); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return usage
+}
+
+func TestWorkspaceQuota(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+
+	setTestQuota(t, db.Quota{MaxSize: 20})
+
+	url := buildURL("dataset/workspace/dataset/versions/1.0.0/upload/file.txt")
+	resp, err := client.Post(url, "application/json", bytes.NewBufferString(fileData1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+
+	usage := getTestUsage(t)
+	utils.Assert(int64(len(fileData1)), usage.Size, t)
+	utils.Assert(int64(len(fileData1)), usage.ChunkSize, t)
+	utils.Assert(int64(20), usage.Quota.MaxSize, t)
+
+	// The same content takes no extra chunk space but logical size is over the quota.
+	url = buildURL("dataset/workspace/dataset/versions/1.0.0/upload/file2.txt")
+	resp, err = client.Post(url, "application/json", bytes.NewBufferString(fileData1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusRequestEntityTooLarge, resp.StatusCode, t)
+
+	// Overwriting the file with smaller one fits.
+	url = buildURL("dataset/workspace/dataset/versions/1.0.0/upload/file.txt")
+	resp, err = client.Post(url, "application/json", bytes.NewBufferString(fileData3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+	utils.Assert(int64(len(fileData3)), getTestUsage(t).Size, t)
+}
+
+func TestWorkspaceChunkQuota(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+
+	setTestQuota(t, db.Quota{MaxChunkSize: 20})
+
+	chunkHash := plukio.CalcHash([]byte(fileData1), types.ChunkVersion)
+	url := buildURL(fmt.Sprintf("chunks/%v?workspace=workspace", chunkHash))
+	resp, err := client.Post(url, "application/json", bytes.NewBufferString(fileData1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+
+	newFile := func(path, hash string, size int64) *types.HashedFile {
+		return &types.HashedFile{
+			Size:     size,
+			Path:     path,
+			Mode:     0644,
+			Hashes:   []types.Hash{{Hash: hash, Size: size}},
+			ModeTime: time.Now(),
+		}
+	}
+	// Deduplicated chunks are counted once.
+	structure := &types.FileStructure{
+		Files: []*types.HashedFile{
+			newFile("file1.txt", chunkHash, int64(len(fileData1))),
+			newFile("file2.txt", chunkHash, int64(len(fileData1))),
+		},
+	}
+	data, _ := json.Marshal(structure)
+	resp, err = client.Post(buildURL("dataset/workspace/new/1.0.0"), "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+
+	usage := getTestUsage(t)
+	utils.Assert(int64(2*len(fileData1)), usage.Size, t)
+	utils.Assert(int64(len(fileData1)), usage.ChunkSize, t)
+
+	// The same content in another version is another chunk.
+	compressed := newFile("file3.txt", chunkHash, int64(len(fileData1)))
+	compressed.Hashes[0].Version = types.ChunkVersionCompressed
+	data, _ = json.Marshal(&types.FileStructure{Files: []*types.HashedFile{compressed}})
+	resp, err = client.Post(buildURL("dataset/workspace/new/1.0.1"), "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusRequestEntityTooLarge, resp.StatusCode, t)
+
+	// New chunk doesn't fit.
+	chunkHash2 := plukio.CalcHash([]byte(fileData2), types.ChunkVersion)
+	url = buildURL(fmt.Sprintf("chunks/%v?workspace=workspace", chunkHash2))
+	resp, err = client.Post(url, "application/json", bytes.NewBufferString(fileData2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusRequestEntityTooLarge, resp.StatusCode, t)
+
+	// Chunk of unknown length can not be checked.
+	body := io.MultiReader(bytes.NewBufferString(fileData2))
+	resp, err = client.Post(url, "application/json", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusLengthRequired, resp.StatusCode, t)
+
+	structure = &types.FileStructure{
+		Files: []*types.HashedFile{newFile("file3.txt", chunkHash2, int64(len(fileData2)))},
+	}
+	data, _ = json.Marshal(structure)
+	resp, err = client.Post(buildURL("dataset/workspace/new/1.0.1"), "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusRequestEntityTooLarge, resp.StatusCode, t)
+}
+
+func TestQuotaKeepsOverwrittenFile(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+
+	url := buildURL("dataset/workspace/dataset/versions/1.0.0/upload/file.txt")
+	resp, err := client.Post(url, "application/json", bytes.NewBufferString(fileData1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+
+	// New content doesn't fit, the original file stays.
+	setTestQuota(t, db.Quota{MaxChunkSize: int64(len(fileData1) + 1)})
+	resp, err = client.Post(url, "application/json", bytes.NewBufferString(fileData2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusRequestEntityTooLarge, resp.StatusCode, t)
+
+	resp, err = client.Get(buildURL("dataset/workspace/dataset/versions/1.0.0/raw/file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusOK, resp.StatusCode, t)
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	utils.Assert(fileData1, string(data), t)
+	utils.Assert(int64(len(fileData1)), getTestUsage(t).Size, t)
+}
+
+func TestQuotaAccess(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+
+	setTestQuota(t, db.Quota{MaxSize: 20})
+
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v0.2/workspace/workspace":
+			w.Write([]byte(`{"name": "workspace", "can": ["read"]}`))
+		case "/api/v0.2/workspace/other":
+			w.Write([]byte(`{"name": "other", "type": "public"}`))
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer auth.Close()
+	utils.AuthURL = auth.URL + "/auth"
+	defer func() { utils.AuthURL = "unset" }()
+	os.Setenv("INTERNAL_KEY", "internal")
+	defer os.Unsetenv("INTERNAL_KEY")
+
+	get := func(url string, headers map[string]string) int {
+		req, _ := http.NewRequest(http.MethodGet, buildURL(url), nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	utils.Assert(http.StatusForbidden, get("admin/quotas", map[string]string{"Cookie": "session"}), t)
+	utils.Assert(http.StatusOK, get("admin/quotas", map[string]string{"Internal": "internal"}), t)
+
+	// Members of the workspace see its quota.
+	member := map[string]string{"X-Workspace-Name": "workspace", "X-Workspace-Secret": "secret"}
+	utils.Assert(http.StatusOK, get("admin/quotas/workspace", member), t)
+	utils.Assert(http.StatusOK, get("admin/quotas/workspace", map[string]string{"Cookie": "session"}), t)
+
+	other := map[string]string{"X-Workspace-Name": "other", "X-Workspace-Secret": "secret"}
+	utils.Assert(http.StatusForbidden, get("admin/quotas/workspace", other), t)
+	utils.Assert(http.StatusForbidden, get("admin/quotas/other", map[string]string{"Cookie": "session"}), t)
+}
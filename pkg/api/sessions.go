@@ -0,0 +1,280 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	"github.com/kuberlab/lib/pkg/errors"
+	"github.com/kuberlab/pluk/pkg/db"
+	"github.com/kuberlab/pluk/pkg/types"
+	"github.com/pborman/uuid"
+)
+
+func (api *API) createUploadSession(req *restful.Request, resp *restful.Response) {
+	workspace := req.PathParameter("workspace")
+	name := req.PathParameter("name")
+	version := req.PathParameter("version")
+	filepath := req.QueryParameter("path")
+	master := api.masterClient(req)
+
+	if filepath == "" {
+		WriteStatusError(resp, http.StatusBadRequest, fmt.Errorf("Provide path"))
+		return
+	}
+
+	dataset, err := api.ds.GetDataset(currentType(req), workspace, name, master)
+	if err != nil {
+		WriteError(resp, EntityNotFoundError(req, name, err))
+		return
+	}
+	if _, err = api.findDatasetVersion(dataset, version, false); err != nil {
+		WriteError(resp, err)
+		return
+	}
+
+	opts, err := getChunkOptions(req)
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+	// Missing trailing parts are found only by the expected size or number of parts.
+	var size int64 = -1
+	var parts uint64 = 0
+	if raw := req.QueryParameter("size"); raw != "" {
+		if size, err = strconv.ParseInt(raw, 10, 64); err != nil || size < 0 {
+			WriteErrorString(resp, http.StatusBadRequest, "Size must be a non-negative integer")
+			return
+		}
+	}
+	if raw := req.QueryParameter("parts"); raw != "" {
+		if parts, err = strconv.ParseUint(raw, 10, 32); err != nil || parts == 0 {
+			WriteErrorString(resp, http.StatusBadRequest, "Number of parts must be a positive integer")
+			return
+		}
+	}
+	if size < 0 && parts == 0 {
+		WriteErrorString(resp, http.StatusBadRequest, "Provide size or parts")
+		return
+	}
+
+	session := &db.UploadSession{
+		ID:          uuid.New(),
+		Workspace:   workspace,
+		Name:        name,
+		Type:        currentType(req),
+		Version:     version,
+		Path:        filepath,
+		Mode:        getFileMode(req),
+		CDC:         opts.CDC,
+		Compress:    opts.Compress,
+		HashVersion: opts.HashVersion,
+		Size:        size,
+		Parts:       uint(parts),
+	}
+	if err = api.mgr.CreateUploadSession(session); err != nil {
+		WriteError(resp, err)
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusCreated, uploadSessionInfo(session, nil))
+}
+
+// uploadSession returns the session from the request path
+// if it belongs to the requested entity version.
+func (api *API) uploadSession(req *restful.Request) (*db.UploadSession, error) {
+	id := req.PathParameter("session")
+	session, err := api.mgr.GetUploadSession(id)
+	if err != nil || session.Type != currentType(req) ||
+		session.Workspace != req.PathParameter("workspace") ||
+		session.Name != req.PathParameter("name") ||
+		session.Version != req.PathParameter("version") {
+		return nil, errors.NewStatus(http.StatusNotFound, fmt.Sprintf("Upload session %v not found", id))
+	}
+	return session, nil
+}
+
+func uploadSessionInfo(session *db.UploadSession, chunks []*db.UploadChunk) *types.UploadSession {
+	info := &types.UploadSession{
+		ID:        session.ID,
+		Workspace: session.Workspace,
+		Name:      session.Name,
+		Version:   session.Version,
+		Path:      session.Path,
+		Size:      session.Size,
+		PartCount: session.Parts,
+		Parts:     make([]types.UploadPart, 0),
+	}
+	// Chunks are ordered by part.
+	for _, c := range chunks {
+		last := len(info.Parts) - 1
+		if last < 0 || info.Parts[last].Number != c.Part {
+			info.Parts = append(info.Parts, types.UploadPart{Number: c.Part})
+			last++
+		}
+		info.Parts[last].Size += c.Size
+	}
+	return info
+}
+
+func (api *API) getUploadSession(req *restful.Request, resp *restful.Response) {
+	session, err := api.uploadSession(req)
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+	chunks, err := api.mgr.ListUploadChunks(db.UploadChunk{SessionID: session.ID})
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+	resp.WriteEntity(uploadSessionInfo(session, chunks))
+}
+
+func (api *API) uploadSessionPart(req *restful.Request, resp *restful.Response) {
+	session, err := api.uploadSession(req)
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+	part, err := strconv.ParseUint(req.PathParameter("part"), 10, 32)
+	if err != nil || part == 0 {
+		WriteErrorString(resp, http.StatusBadRequest, "Part number must be a positive integer")
+		return
+	}
+	if session.Parts > 0 && uint(part) > session.Parts {
+		WriteErrorString(resp, http.StatusBadRequest, fmt.Sprintf("Session has only %v parts", session.Parts))
+		return
+	}
+
+	acquireConcurrency()
+	defer releaseConcurrency()
+
+	defer req.Request.Body.Close()
+	opts := chunkOptions{CDC: session.CDC, Compress: session.Compress, HashVersion: session.HashVersion}
+	hashes, size, err := saveChunks(req.Request.Body, opts)
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+
+	chunks := make([]*db.UploadChunk, len(hashes))
+	for i, h := range hashes {
+		chunks[i] = &db.UploadChunk{
+			SessionID:  session.ID,
+			Part:       uint(part),
+			ChunkIndex: uint(i),
+			Hash:       h.Hash,
+			Size:       h.Size,
+			Version:    h.Version,
+		}
+	}
+	if len(chunks) == 0 {
+		// Received empty part differs from the missing one.
+		chunks = append(chunks, &db.UploadChunk{SessionID: session.ID, Part: uint(part)})
+	}
+
+	tx := api.mgr.Begin()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	if err = tx.SaveUploadPart(session.ID, uint(part), chunks); err != nil {
+		WriteError(resp, err)
+		return
+	}
+	// Keep the session alive.
+	if err = tx.UpdateUploadSession(session); err != nil {
+		WriteError(resp, err)
+		return
+	}
+	resp.WriteEntity(types.UploadPart{Number: uint(part), Size: size})
+}
+
+func (api *API) completeUploadSession(req *restful.Request, resp *restful.Response) {
+	master := api.masterClient(req)
+
+	acquireConcurrency()
+	defer releaseConcurrency()
+
+	session, err := api.uploadSession(req)
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+	dataset, err := api.ds.GetDataset(session.Type, session.Workspace, session.Name, master)
+	if err != nil {
+		WriteError(resp, EntityNotFoundError(req, session.Name, err))
+		return
+	}
+	if _, err = api.findDatasetVersion(dataset, session.Version, false); err != nil {
+		WriteError(resp, err)
+		return
+	}
+
+	chunks, err := api.mgr.ListUploadChunks(db.UploadChunk{SessionID: session.ID})
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+	f := &types.HashedFile{
+		Path:     session.Path,
+		Mode:     os.FileMode(session.Mode),
+		ModeTime: time.Now(),
+		Hashes:   make([]types.Hash, 0, len(chunks)),
+	}
+	var lastPart uint = 0
+	for _, c := range chunks {
+		if c.Part > lastPart+1 {
+			WriteErrorString(resp, http.StatusBadRequest, fmt.Sprintf("Part %v is missing", lastPart+1))
+			return
+		}
+		lastPart = c.Part
+		if c.Hash == "" {
+			// Empty part.
+			continue
+		}
+		f.Hashes = append(f.Hashes, types.Hash{Hash: c.Hash, Size: c.Size, Version: c.Version})
+		f.Size += c.Size
+	}
+	if session.Parts > 0 && lastPart < session.Parts {
+		WriteErrorString(resp, http.StatusBadRequest, fmt.Sprintf("Part %v is missing", lastPart+1))
+		return
+	}
+	if session.Size >= 0 && f.Size != session.Size {
+		WriteErrorString(
+			resp, http.StatusBadRequest,
+			fmt.Sprintf("Received %v bytes of %v, some parts are missing", f.Size, session.Size),
+		)
+		return
+	}
+
+	// The overwritten file is deleted along with saving, after the quota check.
+	if err = api.saveUploadedFile(dataset, session.Version, f); err != nil {
+		WriteError(resp, err)
+		return
+	}
+	if err = api.mgr.DeleteUploadSession(session.ID); err != nil {
+		WriteError(resp, err)
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusCreated, f)
+}
+
+func (api *API) abortUploadSession(req *restful.Request, resp *restful.Response) {
+	session, err := api.uploadSession(req)
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+	if err = api.mgr.DeleteUploadSession(session.ID); err != nil {
+		WriteError(resp, err)
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"testing"
+
+	"github.com/kuberlab/pluk/pkg/db"
+	"github.com/kuberlab/pluk/pkg/types"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+func TestUploadSession(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+
+	parts := make([][]byte, 3)
+	random := rand.New(rand.NewSource(1))
+	for i := range parts {
+		parts[i] = make([]byte, 1500000)
+		random.Read(parts[i])
+	}
+
+	base := "dataset/workspace/dataset/versions/1.0.0/sessions"
+	resp, err := client.Post(buildURL(base+"?path=dir/file.bin&mode=0600&parts=3"), "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+	var session types.UploadSession
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert("dir/file.bin", session.Path, t)
+	sessionURL := buildURL(fmt.Sprintf("%v/%v", base, session.ID))
+
+	putPart := func(number int) {
+		url := fmt.Sprintf("%v/parts/%v", sessionURL, number)
+		req, _ := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(parts[number-1]))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		utils.Assert(http.StatusOK, resp.StatusCode, t)
+	}
+
+	// Parts are sent in any order.
+	putPart(3)
+	putPart(1)
+
+	resp, err = client.Post(sessionURL+"/complete", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	utils.Assert(http.StatusBadRequest, resp.StatusCode, t)
+
+	resp, err = client.Get(sessionURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(2, len(session.Parts), t)
+	utils.Assert(uint(1), session.Parts[0].Number, t)
+	utils.Assert(uint(3), session.Parts[1].Number, t)
+	utils.Assert(int64(len(parts[2])), session.Parts[1].Size, t)
+
+	// Resend part and upload the missing one.
+	putPart(1)
+	putPart(2)
+
+	resp, err = client.Post(sessionURL+"/complete", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+	var f types.HashedFile
+	if err := json.NewDecoder(resp.Body).Decode(&f); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(int64(3*1500000), f.Size, t)
+	utils.Assert(uint32(0600), uint32(f.Mode), t)
+
+	resp, err = client.Get(buildURL("dataset/workspace/dataset/versions/1.0.0/raw/dir/file.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(string(bytes.Join(parts, nil)), mustRead(resp.Body), t)
+
+	// Session is closed.
+	resp, err = client.Get(sessionURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	utils.Assert(http.StatusNotFound, resp.StatusCode, t)
+}
+
+func TestUploadSessionQuotaKeepsFile(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+
+	fileURL := buildURL("dataset/workspace/dataset/versions/1.0.0/raw/file.txt")
+	resp, err := client.Post(
+		buildURL("dataset/workspace/dataset/versions/1.0.0/upload/file.txt"),
+		"application/json", bytes.NewBufferString(fileData1),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+
+	base := "dataset/workspace/dataset/versions/1.0.0/sessions"
+	resp, err = client.Post(buildURL(base+fmt.Sprintf("?path=file.txt&size=%v", len(fileData2))), "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+	var session types.UploadSession
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		t.Fatal(err)
+	}
+	sessionURL := buildURL(fmt.Sprintf("%v/%v", base, session.ID))
+	req, _ := http.NewRequest(http.MethodPut, sessionURL+"/parts/1", bytes.NewBufferString(fileData2))
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	utils.Assert(http.StatusOK, resp.StatusCode, t)
+
+	// New content doesn't fit, the original file stays.
+	setTestQuota(t, db.Quota{MaxChunkSize: int64(len(fileData1) + 1)})
+	resp, err = client.Post(sessionURL+"/complete", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	utils.Assert(http.StatusRequestEntityTooLarge, resp.StatusCode, t)
+
+	resp, err = client.Get(fileURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(fileData1, mustRead(resp.Body), t)
+}
+
+func TestUploadSessionIncomplete(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+
+	base := "dataset/workspace/dataset/versions/1.0.0/sessions"
+	create := func(query string) (int, string) {
+		resp, err := client.Post(buildURL(base+query), "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var session types.UploadSession
+		json.NewDecoder(resp.Body).Decode(&session)
+		return resp.StatusCode, buildURL(fmt.Sprintf("%v/%v", base, session.ID))
+	}
+	putPart := func(sessionURL string, number int, data string) int {
+		req, _ := http.NewRequest(http.MethodPut, fmt.Sprintf("%v/parts/%v", sessionURL, number), bytes.NewBufferString(data))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	complete := func(sessionURL string) int {
+		resp, err := client.Post(sessionURL+"/complete", "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	status, _ := create("?path=file.txt")
+	utils.Assert(http.StatusBadRequest, status, t)
+
+	// Missing trailing part is found by size.
+	status, sessionURL := create(fmt.Sprintf("?path=a.txt&size=%v", len(fileData1)+len(fileData2)))
+	utils.Assert(http.StatusCreated, status, t)
+	utils.Assert(http.StatusOK, putPart(sessionURL, 1, fileData1), t)
+	utils.Assert(http.StatusBadRequest, complete(sessionURL), t)
+	utils.Assert(http.StatusOK, putPart(sessionURL, 2, fileData2), t)
+	utils.Assert(http.StatusCreated, complete(sessionURL), t)
+
+	// Received empty part is not missing.
+	status, sessionURL = create("?path=b.txt&parts=3")
+	utils.Assert(http.StatusCreated, status, t)
+	utils.Assert(http.StatusBadRequest, putPart(sessionURL, 4, fileData1), t)
+	utils.Assert(http.StatusOK, putPart(sessionURL, 1, fileData1), t)
+	utils.Assert(http.StatusOK, putPart(sessionURL, 2, ""), t)
+	utils.Assert(http.StatusBadRequest, complete(sessionURL), t)
+	utils.Assert(http.StatusOK, putPart(sessionURL, 3, fileData2), t)
+
+	resp, err := client.Get(sessionURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var session types.UploadSession
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(uint(3), session.PartCount, t)
+	utils.Assert(3, len(session.Parts), t)
+	utils.Assert(int64(0), session.Parts[1].Size, t)
+
+	utils.Assert(http.StatusCreated, complete(sessionURL), t)
+	resp, err = client.Get(buildURL("dataset/workspace/dataset/versions/1.0.0/raw/b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(fileData1+fileData2, mustRead(resp.Body), t)
+}
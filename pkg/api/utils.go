@@ -372,6 +372,26 @@ func (api *API) AuthHook(req *restful.Request, resp *restful.Response, filter *r
 	filter.ProcessFilter(req, resp)
 }
 
+// checkReadAccess checks that the request credentials allow reading
+// the workspace other than the one in the request path.
+func (api *API) checkReadAccess(req *restful.Request, entityType, workspace string) error {
+	internal := req.HeaderParameter("Internal")
+	if internal != "" && utils.InternalKey() == internal {
+		return nil
+	}
+	_, err := api.CheckAuth(
+		http.MethodGet,
+		entityType,
+		req.HeaderParameter("Authorization"),
+		workspace,
+		req.HeaderParameter("Cookie"),
+		req.HeaderParameter("X-Workspace-Name"),
+		req.HeaderParameter("X-Workspace-Secret"),
+		api.masterClient(req),
+	)
+	return err
+}
+
 func setCurrentType(req *restful.Request, resp *restful.Response, filter *restful.FilterChain) {
 	resp.PrettyPrint(false)
 
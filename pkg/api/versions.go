@@ -56,7 +56,7 @@ func (api *API) getVersion(req *restful.Request, resp *restful.Response) {
 		return
 	}
 
-	ver, err := api.findDatasetVersion(dataset, version, true)
+	ver, err := api.findDatasetVersion(dataset, dataset.ResolveVersion(version), true)
 	if err != nil {
 		WriteError(resp, err)
 		return
@@ -159,6 +159,14 @@ func (api *API) deleteVersion(req *restful.Request, resp *restful.Response) {
 		WriteStatusError(resp, http.StatusInternalServerError, err)
 		return
 	}
+	aliases, err := dataset.DeleteVersionAliases(version)
+	if err != nil {
+		WriteStatusError(resp, http.StatusInternalServerError, err)
+		return
+	}
+	for i := range aliases {
+		api.ds.PushMessageAlias(&aliases[i])
+	}
 
 	api.ds.PushMessageVersion(
 		&types.Version{Workspace: workspace, Name: name, DType: currentType(req), Version: version},
@@ -244,3 +252,51 @@ func (api *API) commitVersion(req *restful.Request, resp *restful.Response) {
 
 	resp.WriteEntity(dsv)
 }
+
+func (api *API) diffVersions(req *restful.Request, resp *restful.Response) {
+	workspace := req.PathParameter("workspace")
+	name := req.PathParameter("name")
+	version := req.PathParameter("version")
+	targetVersion := req.PathParameter("targetVersion")
+	format := req.QueryParameter("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "text" {
+		WriteErrorString(resp, http.StatusBadRequest, "Wrong format, allowed json/text")
+		return
+	}
+	master := api.masterClient(req)
+
+	dataset, err := api.ds.GetDataset(currentType(req), workspace, name, master)
+	if err != nil {
+		WriteError(resp, EntityNotFoundError(req, name, err))
+		return
+	}
+	// Versions missing locally are fetched from the master.
+	from, err := api.getFS(dataset, version)
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+	to, err := api.getFS(dataset, targetVersion)
+	if err != nil {
+		WriteError(resp, err)
+		return
+	}
+
+	diff, err := datasets.Diff(from, to)
+	if err != nil {
+		WriteStatusError(resp, http.StatusInternalServerError, err)
+		return
+	}
+	diff.From = version
+	diff.To = targetVersion
+
+	if format == "text" {
+		resp.Header().Set("Content-Type", "text/plain")
+		datasets.WriteDiff(diff, resp)
+		return
+	}
+	resp.WriteEntity(diff)
+}
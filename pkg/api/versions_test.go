@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"fmt"
 	"net/http"
+	"strings"
 	"testing"
 
+	"github.com/kuberlab/pluk/pkg/datasets"
 	"github.com/kuberlab/pluk/pkg/io"
+	"github.com/kuberlab/pluk/pkg/types"
 	"github.com/kuberlab/pluk/pkg/utils"
 )
 
@@ -82,3 +85,116 @@ func TestCloneManyFiles(t *testing.T) {
 		utils.Assert(fmt.Sprintf("test%v test%v", i, i), data, t)
 	}
 }
+
+func TestDiffVersions(t *testing.T) {
+	fname := getFname()
+	setup(fname)
+	dbPrepare(t)
+	defer teardown(fname)
+
+	upload := func(version, path, data string) {
+		url := buildURL(fmt.Sprintf("dataset/workspace/dataset/versions/%v/upload/%v", version, path))
+		resp, err := client.Post(url, "application/json", bytes.NewBufferString(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		utils.Assert(http.StatusCreated, resp.StatusCode, t)
+	}
+	upload("1.0.0", "removed.txt", fileData1)
+	upload("1.0.0", "modified.txt", fileData2)
+	upload("1.0.0", "moved.txt", fileData3)
+	upload("1.0.0", "same.txt", "same")
+
+	url := buildURL("dataset/workspace/dataset/versions/1.0.0/clone/1.0.1")
+	resp, err := client.Post(url, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusCreated, resp.StatusCode, t)
+
+	for _, path := range []string{"removed.txt", "moved.txt"} {
+		req, _ := http.NewRequest(http.MethodDelete, buildURL("dataset/workspace/dataset/versions/1.0.1/upload/"+path), nil)
+		resp, err = client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		utils.Assert(http.StatusNoContent, resp.StatusCode, t)
+	}
+	upload("1.0.1", "modified.txt", fileData1)
+	upload("1.0.1", "dir/moved.txt", fileData3)
+	upload("1.0.1", "added.txt", "new file")
+
+	resp, err = client.Get(buildURL("dataset/workspace/dataset/versions/1.0.0/diff/1.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusOK, resp.StatusCode, t)
+	var diff types.VersionDiff
+	if err := json.NewDecoder(resp.Body).Decode(&diff); err != nil {
+		t.Fatal(err)
+	}
+
+	utils.Assert([]types.FileDiff{{Path: "added.txt", Size: 8}}, diff.Added, t)
+	utils.Assert([]types.FileDiff{{Path: "removed.txt", Size: int64(len(fileData1))}}, diff.Removed, t)
+	utils.Assert(
+		[]types.FileDiff{{Path: "modified.txt", Size: int64(len(fileData1)), OldSize: int64(len(fileData2))}},
+		diff.Modified,
+		t,
+	)
+	utils.Assert(
+		[]types.FileDiff{{Path: "dir/moved.txt", OldPath: "moved.txt", Size: int64(len(fileData3))}},
+		diff.Moved,
+		t,
+	)
+	utils.Assert(1, diff.Stats.Unchanged, t)
+	utils.Assert(int64(8), diff.Stats.AddedBytes, t)
+	utils.Assert(int64(8+len(fileData1)-len(fileData1)-len(fileData2)), diff.Stats.SizeDelta, t)
+
+	resp, err = client.Get(buildURL("dataset/workspace/dataset/versions/1.0.0/diff/1.0.1?format=text"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusOK, resp.StatusCode, t)
+	text := mustRead(resp.Body)
+	utils.Assert(
+		fmt.Sprintf(
+			"A\tadded.txt\t8\nD\tremoved.txt\t%v\nM\tmodified.txt\t%v -> %v\nR\tmoved.txt -> dir/moved.txt\t%v\n",
+			len(fileData1), len(fileData2), len(fileData1), len(fileData3),
+		),
+		text[:strings.LastIndex(strings.TrimSuffix(text, "\n"), "\n")+1],
+		t,
+	)
+
+	resp, err = client.Get(buildURL("dataset/workspace/dataset/versions/1.0.0/diff/9.9.9"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(http.StatusNotFound, resp.StatusCode, t)
+}
+
+func TestDiffChunkPaths(t *testing.T) {
+	newFS := func(files map[string]io.Chunk) *io.ChunkedFileFS {
+		fs := &io.ChunkedFileFS{Root: "/", Dirs: map[string]*io.ChunkedFileFS{}, Files: map[string]*io.ChunkedFile{}}
+		for name, c := range files {
+			fs.Files[name] = &io.ChunkedFile{Name: name, Size: 1, Chunks: []io.Chunk{c}}
+		}
+		return fs
+	}
+	// Structures may come from different data dirs.
+	from := newFS(map[string]io.Chunk{
+		"v0.txt": {Path: "/data/abc/def", Version: 0},
+		"v1.txt": {Path: "/data/ab/cd/ef/gh", Version: 1},
+		"v3.txt": {Path: "/data/v3/ab/cd/ef", Version: 3},
+	})
+	to := newFS(map[string]io.Chunk{
+		"v0.txt": {Path: "/master/abc/def", Version: 0},
+		"v1.txt": {Path: "/master/xy/cd/ef/gh", Version: 1},
+		"v3.txt": {Path: "/master/v3/ab/cd/ef", Version: 3},
+	})
+	diff, err := datasets.Diff(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(1, len(diff.Modified), t)
+	utils.Assert("v1.txt", diff.Modified[0].Path, t)
+}
@@ -247,7 +247,22 @@ func (w *Watcher) processQueue() {
 					logrus.Errorf("[Watcher] %v", err)
 					return
 				}
+				if _, err = dataset.DeleteVersionAliases(dsv.Version); err != nil {
+					logrus.Errorf("[Watcher] %v", err)
+				}
 				releaseConcurrency()
+			case "version_alias":
+				alias := &types.VersionAlias{}
+				err := utils.LoadAsJson(m.Content.(map[string]interface{}), alias)
+				if err != nil {
+					logrus.Error(err)
+					break
+				}
+
+				logrus.Infof("[Watcher] Set %v alias %v/%v:%v", alias.DType, alias.Workspace, alias.Name, alias.Alias)
+				if err = w.api.ds.ApplyAliasMessage(alias); err != nil {
+					logrus.Errorf("[Watcher] %v", err)
+				}
 			default:
 				logrus.Errorf("Unrecognized message type: %v", m.Type)
 			}
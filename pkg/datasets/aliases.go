@@ -0,0 +1,156 @@
+package datasets
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kuberlab/lib/pkg/errors"
+	"github.com/kuberlab/pluk/pkg/db"
+	"github.com/kuberlab/pluk/pkg/types"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+// ResolveVersion returns the version the alias points to.
+// Anything which is not a known alias is returned as is.
+func (d *Dataset) ResolveVersion(version string) string {
+	if utils.CheckAlias(version) != nil {
+		return version
+	}
+	alias, err := d.mgr.GetVersionAlias(d.Type, d.Workspace, d.Name, version)
+	if err == nil {
+		return alias.Version
+	}
+	if !utils.HasMasters() || d.MasterClient == nil {
+		return version
+	}
+
+	remote, err := d.MasterClient.GetVersionAlias(d.Type, d.Workspace, d.Name, version)
+	if err != nil {
+		return version
+	}
+	// Further changes come through the master websocket.
+	if err = d.mgr.SetVersionAlias(aliasFromType(remote)); err != nil {
+		logrus.Errorf("Unable to save alias %v: %v", version, err)
+	}
+	return remote.Version
+}
+
+func (d *Dataset) Aliases() ([]types.VersionAlias, error) {
+	if utils.HasMasters() && d.MasterClient != nil {
+		list, err := d.MasterClient.ListVersionAliases(d.Type, d.Workspace, d.Name)
+		if err != nil {
+			return nil, err
+		}
+		return list.Aliases, nil
+	}
+
+	aliases, err := d.mgr.ListVersionAliases(
+		db.VersionAlias{Type: d.Type, Workspace: d.Workspace, Name: d.Name},
+	)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]types.VersionAlias, 0)
+	for _, a := range aliases {
+		result = append(result, aliasToType(a))
+	}
+	return result, nil
+}
+
+func (d *Dataset) SetAlias(alias, version string) (*types.VersionAlias, error) {
+	if err := utils.CheckAlias(alias); err != nil {
+		return nil, errors.NewStatus(http.StatusBadRequest, err.Error())
+	}
+	exists, err := d.CheckVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewStatus(http.StatusNotFound, fmt.Sprintf("Version %v not found", version))
+	}
+
+	if utils.HasMasters() && d.MasterClient != nil {
+		if _, err = d.MasterClient.SetVersionAlias(d.Type, d.Workspace, d.Name, alias, version); err != nil {
+			return nil, err
+		}
+	}
+	dba := &db.VersionAlias{Type: d.Type, Workspace: d.Workspace, Name: d.Name, Alias: alias, Version: version}
+	if err = d.mgr.SetVersionAlias(dba); err != nil {
+		return nil, err
+	}
+	res := aliasToType(dba)
+	return &res, nil
+}
+
+func (d *Dataset) DeleteAlias(alias string) error {
+	if _, err := d.mgr.GetVersionAlias(d.Type, d.Workspace, d.Name, alias); err != nil {
+		if !utils.HasMasters() || d.MasterClient == nil {
+			return errors.NewStatus(http.StatusNotFound, fmt.Sprintf("Alias %v not found", alias))
+		}
+	}
+	if utils.HasMasters() && d.MasterClient != nil {
+		if err := d.MasterClient.DeleteVersionAlias(d.Type, d.Workspace, d.Name, alias); err != nil {
+			return err
+		}
+	}
+	return d.mgr.DeleteVersionAlias(d.Type, d.Workspace, d.Name, alias)
+}
+
+// DeleteVersionAliases deletes the aliases pointing to the version
+// and returns them so that the deletion can be pushed to the slaves.
+func (d *Dataset) DeleteVersionAliases(version string) ([]types.VersionAlias, error) {
+	aliases, err := d.mgr.ListVersionAliases(
+		db.VersionAlias{Type: d.Type, Workspace: d.Workspace, Name: d.Name, Version: version},
+	)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]types.VersionAlias, 0)
+	for _, a := range aliases {
+		if err = d.mgr.DeleteVersionAlias(d.Type, d.Workspace, d.Name, a.Alias); err != nil {
+			return nil, err
+		}
+		deleted := aliasToType(a)
+		deleted.Deleted = true
+		result = append(result, deleted)
+	}
+	return result, nil
+}
+
+func aliasToType(a *db.VersionAlias) types.VersionAlias {
+	return types.VersionAlias{
+		Alias:     a.Alias,
+		Version:   a.Version,
+		Workspace: a.Workspace,
+		Name:      a.Name,
+		DType:     a.Type,
+	}
+}
+
+func aliasFromType(a *types.VersionAlias) *db.VersionAlias {
+	return &db.VersionAlias{
+		Type:      a.DType,
+		Workspace: a.Workspace,
+		Name:      a.Name,
+		Alias:     a.Alias,
+		Version:   a.Version,
+	}
+}
+
+// ApplyAliasMessage updates the local alias copy from the master message.
+func (m *Manager) ApplyAliasMessage(alias *types.VersionAlias) error {
+	if alias.Deleted {
+		return m.mgr.DeleteVersionAlias(alias.DType, alias.Workspace, alias.Name, alias.Alias)
+	}
+	return m.mgr.SetVersionAlias(aliasFromType(alias))
+}
+
+func (m *Manager) PushMessageAlias(alias *types.VersionAlias) {
+	if m.hub == nil || alias == nil {
+		return
+	}
+
+	msg := *alias
+	m.hub.Push(&msg)
+}
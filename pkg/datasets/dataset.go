@@ -2,6 +2,7 @@ package datasets
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"sort"
@@ -9,7 +10,6 @@ import (
 	"sync"
 
 	"github.com/Sirupsen/logrus"
-	"github.com/emicklei/go-restful"
 	"github.com/kuberlab/lib/pkg/errors"
 	"github.com/kuberlab/pluk/pkg/db"
 	plukio "github.com/kuberlab/pluk/pkg/io"
@@ -46,6 +46,35 @@ func (d *Dataset) Save(structure types.FileStructure,
 	return nil
 }
 
+// ReplaceFiles saves the files to the version. Existing files with the same paths
+// are deleted in the same transaction, so they are kept if saving fails.
+func (d *Dataset) ReplaceFiles(files []*types.HashedFile, version string) (err error) {
+	structure := types.FileStructure{Files: files}
+	tx := db.DbMgr.Begin()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	for _, f := range files {
+		if err = DeleteFiles(tx, d.Type, d.Workspace, d.Name, version, f.Path, true, false); err != nil {
+			return err
+		}
+	}
+	if err = d.saveFSToTx(tx, structure, version); err != nil {
+		return err
+	}
+
+	if utils.HasMasters() {
+		_ = d.MasterClient.SaveFileStructure(
+			structure, d.Type, d.Workspace, d.Name, version, types.SaveOpts{Editing: true},
+		)
+	}
+	return nil
+}
+
 func (d *Dataset) SaveFSToDB(structure types.FileStructure, version string) (err error) {
 	tx := db.DbMgr.Begin()
 	defer func() {
@@ -55,7 +84,10 @@ func (d *Dataset) SaveFSToDB(structure types.FileStructure, version string) (err
 			tx.Commit()
 		}
 	}()
+	return d.saveFSToTx(tx, structure, version)
+}
 
+func (d *Dataset) saveFSToTx(tx db.DataMgr, structure types.FileStructure, version string) (err error) {
 	var totalSize int64 = 0
 	var fileSizeMap = make(map[string]int64)
 	for _, f := range structure.Files {
@@ -121,7 +153,6 @@ func receiveFileToSave(tx db.DataMgr, dsv *db.DatasetVersion, fileChannel chan *
 		}
 		if len(buffer) >= chunkLimit || force {
 			lock.Lock()
-			TriggerDeleteChunks(tx)
 			err := createConnections(tx, buffer)
 			lock.Unlock()
 			buffer = nil
@@ -302,73 +333,32 @@ func ClearExtraChunks(tx db.DataMgr, dsv *db.DatasetVersion, path string, replac
 	}
 	candidates := make(map[string]db.RawFile)
 	for _, exChunk := range exChunks {
-		candidates[exChunk.Hash] = exChunk
+		candidates[utils.GetHashedKey(exChunk.Hash, exChunk.Version)] = exChunk
 	}
 	// Delete all chunks from map which are in replacement file
 	for _, newChunk := range replacement.Hashes {
-		if _, ok := candidates[newChunk.Hash]; ok {
-			delete(candidates, newChunk.Hash)
+		key := utils.GetHashedKey(newChunk.Hash, newChunk.Version)
+		if _, ok := candidates[key]; ok {
+			delete(candidates, key)
 		}
 	}
 
-	// Delete candidates
-	//deleted := 0
+	// Delete candidates, chunks left without references are deleted by GC.
 	for _, candidate := range candidates {
 		err := tx.DeleteFileChunk(candidate.FileID, candidate.ChunkID)
 		if err != nil {
 			return err
 		}
-		chunk := &db.Chunk{
-			Hash: candidate.Hash,
-			Size: candidate.ChunkSize,
-			ID:   candidate.ChunkID,
-		}
-		CheckAndDeleteChunk(tx, chunk)
 	}
-	//deleted := TriggerDeleteChunks(tx)
-	//if deleted != 0 {
-	//	logrus.Infof("Deleted %v chunks.", deleted)
-	//}
 	return nil
 }
 
-func (d *Dataset) Download(resp *restful.Response) error {
-	return WriteTar(d.FS.Clone(), resp)
+func (d *Dataset) Download(w io.Writer, format string, filter *FileFilter) error {
+	return WriteArchive(d.FS.Clone(), format, filter, w)
 }
 
-func (d *Dataset) TarSize() (int64, error) {
-	var size int64 = 0
-	err := d.FS.Walk("/", func(path string, f *plukio.ChunkedFile, err error) error {
-		name := path
-		// Inline strings.TrimPrefix(): more performance
-		if len(path) >= len("/") && path[:1] == "/" {
-			name = path[1:]
-		}
-
-		// Inlining function: more performance
-		//if strings.HasPrefix(name, ".") || path == "/" {
-		if (len(name) >= len(".") && name[:1] == ".") || path == "/" {
-			return nil
-		}
-
-		if f.Dir {
-			// Directory size
-			// size += 4096
-			return nil
-		}
-		// Header size
-		size += 512
-
-		// File size padded to 512
-		size += f.Size
-		if f.Size%512 != 0 {
-			size += 512 - f.Size%512
-		}
-		return nil
-	})
-	// 2 end blocks
-	size += 512 * 2
-	return size, err
+func (d *Dataset) TarSize(filter *FileFilter) (int64, error) {
+	return TarSize(d.FS, filter)
 }
 
 func (d *Dataset) GetFSStructure(version string) (fs *plukio.ChunkedFileFS, err error) {
@@ -475,6 +465,8 @@ func (d *Dataset) Versions() ([]types.Version, error) {
 			FileCount: dsv.FileCount,
 			Name:      dsv.Name,
 			Workspace: dsv.Workspace,
+
+			Description: dsv.Description,
 		}
 	}
 	if utils.HasMasters() && d.MasterClient != nil {
@@ -525,6 +517,9 @@ func (d *Dataset) DeleteVersion(version string, force bool) error {
 			return err
 		}
 	}
+	if err = d.mgr.DeleteVersionLabels(d.Type, d.Workspace, d.Name, version); err != nil {
+		return err
+	}
 
 	if utils.HasMasters() && d.MasterClient != nil {
 		_ = d.MasterClient.DeleteVersion(d.Type, d.Workspace, d.Name, version)
@@ -0,0 +1,151 @@
+package datasets
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	plukio "github.com/kuberlab/pluk/pkg/io"
+	"github.com/kuberlab/pluk/pkg/types"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+// Diff compares files of two versions by their chunk hashes.
+// A removed file which content appears under a new path is reported as moved.
+func Diff(from, to *plukio.ChunkedFileFS) (*types.VersionDiff, error) {
+	fromFiles, err := contentKeys(from)
+	if err != nil {
+		return nil, err
+	}
+	toFiles, err := contentKeys(to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &types.VersionDiff{
+		Added:    make([]types.FileDiff, 0),
+		Removed:  make([]types.FileDiff, 0),
+		Modified: make([]types.FileDiff, 0),
+		Moved:    make([]types.FileDiff, 0),
+	}
+
+	// Removed files by content, candidates for moves.
+	removed := make(map[string][]string)
+	for _, path := range sortedPaths(fromFiles) {
+		f := fromFiles[path]
+		diff.Stats.SizeDelta -= f.size
+		if _, ok := toFiles[path]; ok {
+			continue
+		}
+		removed[f.key] = append(removed[f.key], path)
+	}
+
+	for _, path := range sortedPaths(toFiles) {
+		f := toFiles[path]
+		diff.Stats.SizeDelta += f.size
+		old, ok := fromFiles[path]
+		switch {
+		case ok && old.key == f.key && old.mode == f.mode:
+			diff.Stats.Unchanged++
+		case ok:
+			diff.Modified = append(diff.Modified, types.FileDiff{Path: path, Size: f.size, OldSize: old.size})
+			diff.Stats.ModifiedBytes += f.size
+		case f.size > 0 && len(removed[f.key]) > 0:
+			// Empty files have no content to match.
+			oldPath := removed[f.key][0]
+			removed[f.key] = removed[f.key][1:]
+			diff.Moved = append(diff.Moved, types.FileDiff{Path: path, OldPath: oldPath, Size: f.size})
+			diff.Stats.MovedBytes += f.size
+		default:
+			diff.Added = append(diff.Added, types.FileDiff{Path: path, Size: f.size})
+			diff.Stats.AddedBytes += f.size
+		}
+	}
+
+	for _, path := range sortedPaths(fromFiles) {
+		f := fromFiles[path]
+		if _, ok := toFiles[path]; ok || !containsPath(removed[f.key], path) {
+			continue
+		}
+		diff.Removed = append(diff.Removed, types.FileDiff{Path: path, Size: f.size})
+		diff.Stats.RemovedBytes += f.size
+	}
+
+	diff.Stats.Added = len(diff.Added)
+	diff.Stats.Removed = len(diff.Removed)
+	diff.Stats.Modified = len(diff.Modified)
+	diff.Stats.Moved = len(diff.Moved)
+	return diff, nil
+}
+
+// WriteDiff writes the diff in compact text form, one file per line:
+// "A", "D", "M" or "R" (moved), the path and the size.
+func WriteDiff(diff *types.VersionDiff, w io.Writer) error {
+	lines := make([]string, 0)
+	for _, f := range diff.Added {
+		lines = append(lines, fmt.Sprintf("A\t%v\t%v", f.Path, f.Size))
+	}
+	for _, f := range diff.Removed {
+		lines = append(lines, fmt.Sprintf("D\t%v\t%v", f.Path, f.Size))
+	}
+	for _, f := range diff.Modified {
+		lines = append(lines, fmt.Sprintf("M\t%v\t%v -> %v", f.Path, f.OldSize, f.Size))
+	}
+	for _, f := range diff.Moved {
+		lines = append(lines, fmt.Sprintf("R\t%v -> %v\t%v", f.OldPath, f.Path, f.Size))
+	}
+	s := diff.Stats
+	lines = append(lines, fmt.Sprintf(
+		"%v added (+%v bytes), %v removed (-%v bytes), %v modified, %v moved, %v unchanged, size delta %v bytes",
+		s.Added, s.AddedBytes, s.Removed, s.RemovedBytes, s.Modified, s.Moved, s.Unchanged, s.SizeDelta,
+	))
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+type diffFile struct {
+	key  string
+	size int64
+	mode uint32
+}
+
+func contentKeys(fs *plukio.ChunkedFileFS) (map[string]diffFile, error) {
+	files := make(map[string]diffFile)
+	err := fs.Walk("/", func(path string, f *plukio.ChunkedFile, err error) error {
+		if f.Dir {
+			return nil
+		}
+		keys := make([]string, len(f.Chunks))
+		for i, c := range f.Chunks {
+			keys[i] = chunkKey(c)
+		}
+		files[strings.TrimPrefix(path, "/")] = diffFile{key: strings.Join(keys, ","), size: f.Size, mode: f.Mode}
+		return nil
+	})
+	return files, err
+}
+
+// chunkKey identifies the chunk regardless of the data dir
+// the structure comes from, it may be built by the master.
+func chunkKey(c plukio.Chunk) string {
+	return fmt.Sprintf("%v:%v", c.Version, utils.GetHashFromChunkPath(c.Path, c.Version))
+}
+
+func sortedPaths(files map[string]diffFile) []string {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func containsPath(paths []string, path string) bool {
+	for _, p := range paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,335 @@
+package datasets
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/kuberlab/lib/pkg/errors"
+	"github.com/kuberlab/pluk/pkg/db"
+	"github.com/kuberlab/pluk/pkg/types"
+)
+
+// Policies for files existing at the destination of copy or move.
+const (
+	ConflictFail      = "fail"
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+)
+
+// CheckConflictPolicy validates the policy, empty policy means ConflictFail.
+func CheckConflictPolicy(policy string) (string, error) {
+	switch policy {
+	case "":
+		return ConflictFail, nil
+	case ConflictFail, ConflictSkip, ConflictOverwrite:
+		return policy, nil
+	}
+	return "", errors.NewStatus(
+		http.StatusBadRequest,
+		fmt.Sprintf("Invalid conflict policy %v: must be one of %v, %v, %v", policy, ConflictFail, ConflictSkip, ConflictOverwrite),
+	)
+}
+
+// VersionRef identifies a version of a dataset.
+type VersionRef struct {
+	Type      string
+	Workspace string
+	Name      string
+	Version   string
+}
+
+func (v VersionRef) String() string {
+	return fmt.Sprintf("%v %v/%v:%v", v.Type, v.Workspace, v.Name, v.Version)
+}
+
+func (v VersionRef) listFiles(tx db.DataMgr) ([]*db.File, error) {
+	return tx.ListFiles(db.File{Workspace: v.Workspace, DatasetName: v.Name, DatasetType: v.Type, Version: v.Version})
+}
+
+// MoveFiles moves the file or all files of the dir to the new path inside the version.
+// Only files rows are changed, chunks and their links stay the same.
+func MoveFiles(tx db.DataMgr, v VersionRef, from, to, policy string) (res types.FileOperationResult, err error) {
+	from = cleanPath(from)
+	to = cleanPath(to)
+	if from == "" || to == "" {
+		return res, errors.NewStatus(http.StatusBadRequest, "Provide both source and destination paths")
+	}
+	if pathsOverlap(from, to) {
+		return res, errors.NewStatus(
+			http.StatusBadRequest,
+			fmt.Sprintf("Can't move %v to %v: paths overlap", from, to),
+		)
+	}
+
+	files, err := v.listFiles(tx)
+	if err != nil {
+		return res, err
+	}
+	moved, isDir := selectFiles(files, from)
+	if len(moved) == 0 {
+		return res, errors.NewStatus(http.StatusNotFound, fmt.Sprintf("Path %v not found in %v", from, v))
+	}
+
+	targets := make([]string, len(moved))
+	for i, f := range moved {
+		targets[i] = targetPath(f.Path, from, to, isDir)
+	}
+	skip, err := prepareTargets(tx, v, files, moved, targets, policy)
+	if err != nil {
+		return res, err
+	}
+
+	for i, f := range moved {
+		if skip[i] {
+			res.Skipped++
+			continue
+		}
+		f.Path = targets[i]
+		if _, err = tx.UpdateFile(f); err != nil {
+			return res, err
+		}
+		res.Files++
+		res.Size += f.Size
+	}
+	return res, tx.UpdateDatasetVersionSize(v.Type, v.Workspace, v.Name, v.Version)
+}
+
+// CopyFiles copies the file or all files of the dir from the source version
+// to the path in the target version. Copies are linked to the same chunks.
+// Empty path means the root dir.
+func CopyFiles(tx db.DataMgr, src VersionRef, from string, dst VersionRef, to, policy string) (res types.FileOperationResult, err error) {
+	from = cleanPath(from)
+	to = cleanPath(to)
+	if src == dst && pathsOverlap(from, to) {
+		return res, errors.NewStatus(
+			http.StatusBadRequest,
+			fmt.Sprintf("Can't copy %v to %v: paths overlap", from, to),
+		)
+	}
+
+	srcFiles, err := src.listFiles(tx)
+	if err != nil {
+		return res, err
+	}
+	copied, isDir := selectFiles(srcFiles, from)
+	if len(copied) == 0 {
+		return res, errors.NewStatus(http.StatusNotFound, fmt.Sprintf("Path %v not found in %v", from, src))
+	}
+	if !isDir && to == "" {
+		return res, errors.NewStatus(http.StatusBadRequest, "Provide destination path")
+	}
+
+	files := srcFiles
+	if src != dst {
+		if files, err = dst.listFiles(tx); err != nil {
+			return res, err
+		}
+	}
+	targets := make([]string, len(copied))
+	for i, f := range copied {
+		targets[i] = targetPath(f.Path, from, to, isDir)
+	}
+	skip, err := prepareTargets(tx, dst, files, nil, targets, policy)
+	if err != nil {
+		return res, err
+	}
+
+	fileChunks, err := tx.ListRelatedChunksForFiles(src.Type, src.Workspace, src.Name, src.Version, from, !isDir)
+	if err != nil {
+		return res, err
+	}
+	fileChunksMap := make(map[uint][]*db.FileChunk)
+	for _, fc := range fileChunks {
+		fileChunksMap[fc.FileID] = append(fileChunksMap[fc.FileID], fc)
+	}
+
+	var fileBuf []*db.File
+	var oldIDs []uint
+	flush := func() error {
+		if len(fileBuf) == 0 {
+			return nil
+		}
+		if err := tx.CreateFiles(fileBuf); err != nil {
+			return err
+		}
+		fcBuf := make([]*db.FileChunk, 0)
+		for i, newF := range fileBuf {
+			for _, fc := range fileChunksMap[oldIDs[i]] {
+				fcBuf = append(fcBuf, &db.FileChunk{FileID: newF.ID, ChunkID: fc.ChunkID, ChunkIndex: fc.ChunkIndex})
+			}
+		}
+		for len(fcBuf) > 0 {
+			n := chunkLimit
+			if n > len(fcBuf) {
+				n = len(fcBuf)
+			}
+			if err := tx.CreateFileChunks(fcBuf[:n]); err != nil {
+				return err
+			}
+			fcBuf = fcBuf[n:]
+		}
+		fileBuf, oldIDs = nil, nil
+		return nil
+	}
+
+	for i, f := range copied {
+		if skip[i] {
+			res.Skipped++
+			continue
+		}
+		fileBuf = append(fileBuf, &db.File{
+			Workspace:   dst.Workspace,
+			DatasetName: dst.Name,
+			DatasetType: dst.Type,
+			Version:     dst.Version,
+			Path:        targets[i],
+			Size:        f.Size,
+			Mode:        f.Mode,
+		})
+		oldIDs = append(oldIDs, f.ID)
+		res.Files++
+		res.Size += f.Size
+		if len(fileBuf) >= limit {
+			if err = flush(); err != nil {
+				return res, err
+			}
+		}
+	}
+	if err = flush(); err != nil {
+		return res, err
+	}
+	return res, tx.UpdateDatasetVersionSize(dst.Type, dst.Workspace, dst.Name, dst.Version)
+}
+
+// CherryPick copies files and dirs at the paths of the source version
+// to the same paths of the target version.
+func CherryPick(tx db.DataMgr, src VersionRef, paths []string, dst VersionRef, policy string) (res types.FileOperationResult, err error) {
+	if len(paths) == 0 {
+		return res, errors.NewStatus(http.StatusBadRequest, "Provide paths to cherry-pick")
+	}
+	cleaned := make([]string, len(paths))
+	for i, p := range paths {
+		cleaned[i] = cleanPath(p)
+	}
+	// Parent dirs go first and nested paths are skipped as already copied.
+	sort.Strings(cleaned)
+	picked := make([]string, 0)
+	for _, p := range cleaned {
+		nested := false
+		for _, parent := range picked {
+			if pathsOverlap(parent, p) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			picked = append(picked, p)
+		}
+	}
+
+	for _, p := range picked {
+		copied, err := CopyFiles(tx, src, p, dst, p, policy)
+		if err != nil {
+			return res, err
+		}
+		res.Files += copied.Files
+		res.Size += copied.Size
+		res.Skipped += copied.Skipped
+	}
+	return res, nil
+}
+
+// prepareTargets checks that target paths don't clash with existing files of the version
+// except the ones being moved. Depending on the policy existing files at target paths
+// are deleted or marked to skip.
+func prepareTargets(tx db.DataMgr, v VersionRef, files, moved []*db.File, targets []string, policy string) ([]bool, error) {
+	ignore := make(map[uint]bool)
+	for _, f := range moved {
+		ignore[f.ID] = true
+	}
+	existing := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, f := range files {
+		if ignore[f.ID] {
+			continue
+		}
+		existing[f.Path] = true
+		for dir := path.Dir(f.Path); dir != "."; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+
+	skip := make([]bool, len(targets))
+	toDelete := make([]string, 0)
+	for i, target := range targets {
+		if dirs[target] {
+			return nil, errors.NewStatus(http.StatusConflict, fmt.Sprintf("%v is a directory", target))
+		}
+		for dir := path.Dir(target); dir != "."; dir = path.Dir(dir) {
+			if existing[dir] {
+				return nil, errors.NewStatus(http.StatusConflict, fmt.Sprintf("%v is a file", dir))
+			}
+		}
+		if !existing[target] {
+			continue
+		}
+		switch policy {
+		case ConflictSkip:
+			skip[i] = true
+		case ConflictOverwrite:
+			toDelete = append(toDelete, target)
+		default:
+			return nil, errors.NewStatus(http.StatusConflict, fmt.Sprintf("File %v already exists", target))
+		}
+	}
+	for _, p := range toDelete {
+		if err := DeleteFiles(tx, v.Type, v.Workspace, v.Name, v.Version, p, true, false); err != nil {
+			return nil, err
+		}
+	}
+	return skip, nil
+}
+
+// selectFiles returns the file at the path or all files inside the dir at the path.
+func selectFiles(files []*db.File, p string) ([]*db.File, bool) {
+	if p != "" {
+		for _, f := range files {
+			if f.Path == p {
+				return []*db.File{f}, false
+			}
+		}
+	}
+	selected := make([]*db.File, 0)
+	prefix := dirPrefix(p)
+	for _, f := range files {
+		if strings.HasPrefix(f.Path, prefix) {
+			selected = append(selected, f)
+		}
+	}
+	return selected, true
+}
+
+func targetPath(p, from, to string, isDir bool) string {
+	if !isDir {
+		return to
+	}
+	return strings.TrimPrefix(path.Join(to, strings.TrimPrefix(p, dirPrefix(from))), "/")
+}
+
+func pathsOverlap(a, b string) bool {
+	return strings.HasPrefix(dirPrefix(a), dirPrefix(b)) || strings.HasPrefix(dirPrefix(b), dirPrefix(a))
+}
+
+func dirPrefix(p string) string {
+	if p == "" {
+		return ""
+	}
+	return p + "/"
+}
+
+func cleanPath(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
@@ -0,0 +1,158 @@
+package datasets
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// FileFilter selects a subset of files by path prefixes and glob patterns.
+//
+// A prefix is a dir or a file path, "data" selects "data/a.txt" but not "data_old/a.txt".
+// A pattern without "/" is matched against the base name in any dir,
+// otherwise against the whole path, so "/tmp" is only the top-level dir.
+// "*" and "?" don't match "/", "**" matches any number of dirs.
+// Excluding a dir excludes everything inside it.
+// Nil filter selects all files.
+type FileFilter struct {
+	prefixes []string
+	include  []glob
+	exclude  []glob
+}
+
+type glob struct {
+	re *regexp.Regexp
+	// Pattern without "/" matches the base name.
+	baseName bool
+}
+
+// NewFileFilter returns nil if there is nothing to filter.
+func NewFileFilter(prefixes, include, exclude []string) (*FileFilter, error) {
+	if len(prefixes) == 0 && len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	f := &FileFilter{}
+	for _, p := range prefixes {
+		f.prefixes = append(f.prefixes, strings.Trim(p, "/"))
+	}
+	var err error
+	if f.include, err = compileGlobs(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = compileGlobs(exclude); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Match reports whether the file is selected.
+func (f *FileFilter) Match(name string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.prefixes) > 0 && !f.hasPrefix(name) {
+		return false
+	}
+	if len(f.include) > 0 && !matchAny(f.include, name) {
+		return false
+	}
+	return !matchAny(f.exclude, name)
+}
+
+// SkipDir reports whether no file inside the dir can be selected.
+func (f *FileFilter) SkipDir(dir string) bool {
+	if f == nil || dir == "" {
+		return false
+	}
+	if matchAny(f.exclude, dir) {
+		return true
+	}
+	if len(f.prefixes) == 0 {
+		return false
+	}
+	for _, p := range f.prefixes {
+		// The dir is either inside the prefix or on the way to it.
+		if underPrefix(dir, p) || strings.HasPrefix(p, dir+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *FileFilter) hasPrefix(name string) bool {
+	for _, p := range f.prefixes {
+		if underPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// underPrefix reports whether the path is the prefix itself or inside it.
+func underPrefix(path, prefix string) bool {
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+func matchAny(patterns []glob, name string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+	base := name[strings.LastIndex(name, "/")+1:]
+	for _, g := range patterns {
+		if g.re.MatchString(name) || g.baseName && g.re.MatchString(base) {
+			return true
+		}
+	}
+	return false
+}
+
+func compileGlobs(globs []string) ([]glob, error) {
+	res := make([]glob, 0, len(globs))
+	for _, g := range globs {
+		re, err := compileGlob(g)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pattern %v: %v", g, err)
+		}
+		res = append(res, glob{re: re, baseName: !strings.Contains(g, "/")})
+	}
+	return res, nil
+}
+
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	glob = strings.TrimPrefix(glob, "/")
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '*' && i+1 < len(glob) && glob[i+1] == '*':
+			i++
+			if i+1 < len(glob) && glob[i+1] == '/' {
+				// "**/" matches zero or more dirs.
+				i++
+				expr.WriteString("(?:.*/)?")
+			} else {
+				expr.WriteString(".*")
+			}
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("missing ']'")
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
@@ -148,6 +148,12 @@ func (m *Manager) DeleteDataset(eType, workspace, name string, master io.PlukCli
 	if _, err = m.mgr.UpdateDataset(ds); err != nil {
 		return err
 	}
+	if err = m.mgr.DeleteVersionAliases(eType, workspace, name); err != nil {
+		return err
+	}
+	if err = m.mgr.DeleteLabels(eType, workspace, name); err != nil {
+		return err
+	}
 
 	if utils.HasMasters() && master != nil {
 		_ = master.DeleteEntity(ds.Type, workspace, name, force)
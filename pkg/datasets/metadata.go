@@ -0,0 +1,125 @@
+package datasets
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/kuberlab/lib/pkg/errors"
+	"github.com/kuberlab/pluk/pkg/db"
+	"github.com/kuberlab/pluk/pkg/types"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+// Metadata returns the description and labels of the dataset,
+// or of the version if it is not empty.
+func (d *Dataset) Metadata(version string) (*types.Metadata, error) {
+	meta := &types.Metadata{Description: d.Description}
+	if version != "" {
+		dsv, err := d.mgr.GetDatasetVersion(d.Type, d.Workspace, d.Name, version)
+		if err != nil {
+			return nil, errors.NewStatus(http.StatusNotFound, fmt.Sprintf("Version %v not found", version))
+		}
+		meta.Description = dsv.Description
+	}
+
+	labels, err := d.mgr.ListLabels(d.Type, d.Workspace, d.Name, version)
+	if err != nil {
+		return nil, err
+	}
+	meta.Labels = labelMap(labels)
+	return meta, nil
+}
+
+// SetMetadata replaces the description and labels of the dataset,
+// or of the version if it is not empty.
+func (d *Dataset) SetMetadata(version string, meta types.Metadata) (err error) {
+	if err = utils.CheckLabels(meta.Labels); err != nil {
+		return errors.NewStatus(http.StatusBadRequest, err.Error())
+	}
+
+	tx := d.mgr.Begin()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	if version == "" {
+		d.Description = meta.Description
+		if _, err = tx.UpdateDataset(d.Dataset); err != nil {
+			return err
+		}
+	} else {
+		dsv, errGet := tx.GetDatasetVersion(d.Type, d.Workspace, d.Name, version)
+		if errGet != nil {
+			err = errors.NewStatus(http.StatusNotFound, fmt.Sprintf("Version %v not found", version))
+			return err
+		}
+		dsv.Description = meta.Description
+		if _, err = tx.UpdateDatasetVersion(dsv); err != nil {
+			return err
+		}
+	}
+	return tx.SetLabels(d.Type, d.Workspace, d.Name, version, meta.Labels)
+}
+
+func (d *Dataset) DeleteLabel(version, key string) error {
+	meta, err := d.Metadata(version)
+	if err != nil {
+		return err
+	}
+	if _, ok := meta.Labels[key]; !ok {
+		return errors.NewStatus(http.StatusNotFound, fmt.Sprintf("Label %v not found", key))
+	}
+	return d.mgr.DeleteLabel(d.Type, d.Workspace, d.Name, version, key)
+}
+
+// SearchDatasets returns datasets of the workspace matching the label selector
+// and containing the query in the name or description. Empty type means any type.
+func (m *Manager) SearchDatasets(eType, workspace string, selector utils.LabelSelector, query string) ([]types.Dataset, error) {
+	sets, err := m.mgr.ListDatasets(db.Dataset{Type: eType, Workspace: workspace})
+	if err != nil {
+		return nil, err
+	}
+	labels, err := m.mgr.ListWorkspaceLabels(eType, workspace)
+	if err != nil {
+		return nil, err
+	}
+	byDataset := make(map[string][]*db.Label)
+	for _, l := range labels {
+		key := l.Type + "/" + l.Name
+		byDataset[key] = append(byDataset[key], l)
+	}
+
+	query = strings.ToLower(query)
+	result := make([]types.Dataset, 0)
+	for _, d := range sets {
+		dsLabels := labelMap(byDataset[d.Type+"/"+d.Name])
+		if !selector.Matches(dsLabels) {
+			continue
+		}
+		if query != "" &&
+			!strings.Contains(strings.ToLower(d.Name), query) &&
+			!strings.Contains(strings.ToLower(d.Description), query) {
+			continue
+		}
+		result = append(result, types.Dataset{
+			Workspace:   d.Workspace,
+			Name:        d.Name,
+			DType:       d.Type,
+			Description: d.Description,
+			Labels:      dsLabels,
+		})
+	}
+	return result, nil
+}
+
+func labelMap(labels []*db.Label) map[string]string {
+	res := make(map[string]string)
+	for _, l := range labels {
+		res[l.Key] = l.Value
+	}
+	return res
+}
@@ -2,23 +2,71 @@ package datasets
 
 import (
 	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 
 	"github.com/Sirupsen/logrus"
-	"github.com/emicklei/go-restful"
+	"github.com/klauspost/compress/zstd"
 	plukio "github.com/kuberlab/pluk/pkg/io"
 )
 
-func WriteTar(fs *plukio.ChunkedFileFS, resp *restful.Response) error {
-	// Wrap in tar writer
-	twriter := tar.NewWriter(resp.ResponseWriter)
-	defer func() {
-		twriter.Close()
-	}()
+// Archive formats for the version download.
+const (
+	ArchiveTar    = "tar"
+	ArchiveTarGz  = "tar.gz"
+	ArchiveTarZst = "tar.zst"
+	ArchiveZip    = "zip"
+)
 
-	prevName := ""
-	err := fs.Walk("/", func(path string, f *plukio.ChunkedFile, err error) error {
+var archiveContentTypes = map[string]string{
+	ArchiveTar:    "application/tar",
+	ArchiveTarGz:  "application/gzip",
+	ArchiveTarZst: "application/zstd",
+	ArchiveZip:    "application/zip",
+}
+
+// ArchiveContentType returns the content type of the archive format
+// or an empty string if the format is unknown.
+func ArchiveContentType(format string) string {
+	return archiveContentTypes[format]
+}
+
+// WriteArchive streams files of the FS selected by the filter to w in the given format.
+func WriteArchive(fs *plukio.ChunkedFileFS, format string, filter *FileFilter, w io.Writer) error {
+	switch format {
+	case ArchiveTar:
+		return WriteTar(fs, filter, w)
+	case ArchiveTarGz:
+		gz := gzip.NewWriter(w)
+		if err := WriteTar(fs, filter, gz); err != nil {
+			gz.Close()
+			return err
+		}
+		return gz.Close()
+	case ArchiveTarZst:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		if err = WriteTar(fs, filter, zw); err != nil {
+			zw.Close()
+			return err
+		}
+		return zw.Close()
+	case ArchiveZip:
+		return WriteZip(fs, filter, w)
+	}
+	return fmt.Errorf("Unknown archive format %v", format)
+}
+
+// walkArchiveFiles calls walkFunc for regular files to put in the archive.
+// Dirs which can't contain selected files are not walked.
+func walkArchiveFiles(fs *plukio.ChunkedFileFS, filter *FileFilter, walkFunc func(name string, f *plukio.ChunkedFile) error) error {
+	return fs.Walk("/", func(path string, f *plukio.ChunkedFile, err error) error {
 		name := path
 		// Inline strings.TrimPrefix(): more performance
 		if len(path) >= len("/") && path[:1] == "/" {
@@ -31,21 +79,28 @@ func WriteTar(fs *plukio.ChunkedFileFS, resp *restful.Response) error {
 			return nil
 		}
 		if f.Dir {
-			//h := &tar.Header{
-			//	Name:     name,
-			//	Mode:     0644,
-			//	Typeflag: tar.TypeDir,
-			//	ModTime:  f.Fstat.ModTime(),
-			//}
-			//if err := twriter.WriteHeader(h); err != nil {
-			//	return fmt.Errorf("Failed write file %v: %v", prevName, err)
-			//}
-			//hd++
-			//sz += 512
+			if filter.SkipDir(name) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !filter.Match(name) {
 			return nil
 		}
 		logrus.Debugf("Processing file %v, size=%v", name, f.Size)
+		return walkFunc(name, f)
+	})
+}
+
+func WriteTar(fs *plukio.ChunkedFileFS, filter *FileFilter, w io.Writer) error {
+	// Wrap in tar writer
+	twriter := tar.NewWriter(w)
+	defer func() {
+		twriter.Close()
+	}()
 
+	prevName := ""
+	return walkArchiveFiles(fs, filter, func(name string, f *plukio.ChunkedFile) error {
 		h := &tar.Header{
 			Name:    name,
 			Mode:    int64(f.Mode),
@@ -55,14 +110,60 @@ func WriteTar(fs *plukio.ChunkedFileFS, resp *restful.Response) error {
 		if err := twriter.WriteHeader(h); err != nil {
 			return fmt.Errorf("Failed write file %v: %v", prevName, err)
 		}
-		_, err = io.Copy(twriter, f)
+		_, err := io.Copy(twriter, f)
 		if err != nil {
 			return fmt.Errorf("Failed write file %v: %v", name, err)
 		}
 		prevName = name
-		//resp.Flush()
 		f.Close()
 		return nil
 	})
-	return err
+}
+
+// WriteZip streams the zip archive. Sizes are written after the file data,
+// so ZIP64 records are added automatically for big files and archives.
+func WriteZip(fs *plukio.ChunkedFileFS, filter *FileFilter, w io.Writer) error {
+	zwriter := zip.NewWriter(w)
+
+	err := walkArchiveFiles(fs, filter, func(name string, f *plukio.ChunkedFile) error {
+		h := &zip.FileHeader{
+			Name:   name,
+			Method: zip.Deflate,
+		}
+		h.SetModTime(f.ModTime)
+		h.SetMode(os.FileMode(f.Mode))
+		fw, err := zwriter.CreateHeader(h)
+		if err != nil {
+			return fmt.Errorf("Failed write file %v: %v", name, err)
+		}
+		if _, err = io.Copy(fw, f); err != nil {
+			return fmt.Errorf("Failed write file %v: %v", name, err)
+		}
+		f.Close()
+		return nil
+	})
+	if err != nil {
+		zwriter.Close()
+		return err
+	}
+	return zwriter.Close()
+}
+
+// TarSize returns the exact size of the tar archive with files selected by the filter.
+func TarSize(fs *plukio.ChunkedFileFS, filter *FileFilter) (int64, error) {
+	var size int64 = 0
+	err := walkArchiveFiles(fs, filter, func(name string, f *plukio.ChunkedFile) error {
+		// Header size
+		size += 512
+
+		// File size padded to 512
+		size += f.Size
+		if f.Size%512 != 0 {
+			size += 512 - f.Size%512
+		}
+		return nil
+	})
+	// 2 end blocks
+	size += 512 * 2
+	return size, err
 }
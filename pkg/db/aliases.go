@@ -0,0 +1,45 @@
+package db
+
+type VersionAliasMgr interface {
+	GetVersionAlias(dsType, workspace, name, alias string) (*VersionAlias, error)
+	SetVersionAlias(alias *VersionAlias) error
+	ListVersionAliases(filter VersionAlias) ([]*VersionAlias, error)
+	DeleteVersionAlias(dsType, workspace, name, alias string) error
+	DeleteVersionAliases(dsType, workspace, name string) error
+}
+
+// VersionAlias is a mutable name, such as "latest", pointing to a version.
+type VersionAlias struct {
+	BaseModel
+	Type      string `json:"type" gorm:"primary_key"`
+	Workspace string `json:"workspace" gorm:"primary_key"`
+	Name      string `json:"name" gorm:"primary_key"`
+	Alias     string `json:"alias" gorm:"primary_key"`
+	Version   string `json:"version"`
+}
+
+func (mgr *DatabaseMgr) GetVersionAlias(dsType, workspace, name, alias string) (*VersionAlias, error) {
+	var res = VersionAlias{}
+	filter := VersionAlias{Type: dsType, Workspace: workspace, Name: name, Alias: alias}
+	err := mgr.db.First(&res, filter).Error
+	return &res, err
+}
+
+func (mgr *DatabaseMgr) SetVersionAlias(alias *VersionAlias) error {
+	return mgr.db.Save(alias).Error
+}
+
+func (mgr *DatabaseMgr) ListVersionAliases(filter VersionAlias) ([]*VersionAlias, error) {
+	var aliases = make([]*VersionAlias, 0)
+	err := mgr.db.Order("alias").Find(&aliases, filter).Error
+	return aliases, err
+}
+
+func (mgr *DatabaseMgr) DeleteVersionAlias(dsType, workspace, name, alias string) error {
+	filter := VersionAlias{Type: dsType, Workspace: workspace, Name: name, Alias: alias}
+	return mgr.db.Delete(VersionAlias{}, filter).Error
+}
+
+func (mgr *DatabaseMgr) DeleteVersionAliases(dsType, workspace, name string) error {
+	return mgr.db.Delete(VersionAlias{}, VersionAlias{Type: dsType, Workspace: workspace, Name: name}).Error
+}
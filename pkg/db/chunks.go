@@ -4,34 +4,47 @@ import (
 	"bytes"
 	"fmt"
 	"strings"
+	"time"
+
+	"github.com/kuberlab/lib/pkg/types"
+	"github.com/kuberlab/pluk/pkg/utils"
 )
 
 type ChunkMgr interface {
 	CreateChunk(chunk *Chunk) error
 	CreateChunks(raws []*RawFile) error
 	UpdateChunk(chunk *Chunk) (*Chunk, error)
-	GetChunk(hash string) (*Chunk, error)
+	GetChunk(hash string, version byte) (*Chunk, error)
 	GetChunkByID(chunkID uint) (*Chunk, error)
 	ListChunks(filter Chunk) ([]*Chunk, error)
 	ListChunksByHash(hashes []*RawFile) ([]*Chunk, error)
 	ListChunksByUniqueHash(hashes []*RawFile) ([]*Chunk, error)
 	DeleteChunk(id uint) error
 	DeleteChunks(chunks []Chunk) error
+	ListUnreferencedChunks(limit int) ([]Chunk, error)
+	ListReleasedChunks(since time.Time, limit int) ([]Chunk, error)
+	ReleasedChunksStat(since time.Time) (count int64, size int64, err error)
+	DeleteUnreferencedChunks(chunks []Chunk) (int64, error)
+	RecountChunkRefs() error
 }
 
+// Chunk is identified by the hash and the version: the same content
+// may be stored in several versions, e.g. plain and compressed.
 type Chunk struct {
 	BaseModel
 	ID      uint   `sql:"AUTO_INCREMENT"`
 	Hash    string `json:"hash" gorm:"primary_key"`
 	Size    int64  `json:"size"`
-	Version byte   `json:"version"`
+	Version byte   `json:"version" gorm:"primary_key"`
+	// Number of file_chunks rows which refer to the chunk.
+	RefCount int64 `json:"ref_count" gorm:"index"`
 	//Pos     uint   `json:"pos"`
 }
 
 func (mgr *DatabaseMgr) CreateChunk(chunk *Chunk) error {
 	if mgr.DBType() == "postgres" {
 		tpl := "INSERT INTO chunks " +
-			"(hash, size, version) VALUES (?, ?, ?) ON CONFLICT (hash) DO UPDATE SET size=? RETURNING id"
+			"(hash, size, version) VALUES (?, ?, ?) ON CONFLICT (hash, version) DO UPDATE SET size=? RETURNING id"
 		var newC = &Chunk{}
 		err := mgr.db.Raw(tpl, chunk.Hash, chunk.Size, chunk.Version, chunk.Size).Scan(newC).Error
 		if err != nil {
@@ -42,12 +55,12 @@ func (mgr *DatabaseMgr) CreateChunk(chunk *Chunk) error {
 
 	} else if mgr.DBType() == "sqlite3" {
 		tpl := "INSERT INTO chunks " +
-			"(hash, size, version) VALUES (?, ?, ?) ON CONFLICT (hash) DO UPDATE SET size=?"
+			"(hash, size, version) VALUES (?, ?, ?) ON CONFLICT (hash, version) DO UPDATE SET size=?"
 		err := mgr.db.Exec(tpl, chunk.Hash, chunk.Size, chunk.Version, chunk.Size).Error
 		if err != nil {
 			return err
 		}
-		updated, err := mgr.GetChunk(chunk.Hash)
+		updated, err := mgr.GetChunk(chunk.Hash, chunk.Version)
 		if err != nil {
 			return err
 		}
@@ -76,8 +89,22 @@ func (mgr *DatabaseMgr) ListChunksByUniqueHash(hashes []*RawFile) ([]*Chunk, err
 	err := mgr.db.
 		Where(where.String(), values...).
 		Find(&chunks).Error
+	if err != nil {
+		return nil, err
+	}
 
-	return chunks, err
+	// Other versions of the same content are different chunks.
+	requested := make(map[string]bool)
+	for _, h := range hashes {
+		requested[chunkKey(h.Hash, h.Version)] = true
+	}
+	found := make([]*Chunk, 0, len(chunks))
+	for _, c := range chunks {
+		if requested[chunkKey(c.Hash, c.Version)] {
+			found = append(found, c)
+		}
+	}
+	return found, nil
 }
 
 func (mgr *DatabaseMgr) ListChunksByHash(hashes []*RawFile) ([]*Chunk, error) {
@@ -101,18 +128,26 @@ func (mgr *DatabaseMgr) ListChunksByHash(hashes []*RawFile) ([]*Chunk, error) {
 
 	hashMap := make(map[string]int)
 	for i, hash := range hashes {
-		hashMap[hash.Hash] = i
+		hashMap[chunkKey(hash.Hash, hash.Version)] = i
 	}
 
-	newChunks := make([]*Chunk, len(chunks))
+	newChunks := make([]*Chunk, len(hashes))
 	for _, c := range chunks {
-		place := hashMap[c.Hash]
+		place, ok := hashMap[chunkKey(c.Hash, c.Version)]
+		if !ok {
+			continue
+		}
 		newChunks[place] = c
 	}
 
 	return newChunks, err
 }
 
+// chunkKey identifies the chunk among chunks of all versions.
+func chunkKey(hash string, version byte) string {
+	return fmt.Sprintf("%v:%v", hash, version)
+}
+
 // Inserts Chunk IDs in place
 func (mgr *DatabaseMgr) CreateChunks(raws []*RawFile) error {
 	sql := bytes.NewBufferString("")
@@ -126,11 +161,12 @@ func (mgr *DatabaseMgr) CreateChunks(raws []*RawFile) error {
 	exclusivesMap := make(map[string]*RawFile)
 	chunkMap := make(map[string][]*RawFile)
 	for _, raw := range raws {
-		exclusivesMap[raw.Hash] = raw
-		if _, ok := chunkMap[raw.Hash]; ok {
-			chunkMap[raw.Hash] = append(chunkMap[raw.Hash], raw)
+		key := chunkKey(raw.Hash, raw.Version)
+		exclusivesMap[key] = raw
+		if _, ok := chunkMap[key]; ok {
+			chunkMap[key] = append(chunkMap[key], raw)
 		} else {
-			chunkMap[raw.Hash] = []*RawFile{raw}
+			chunkMap[key] = []*RawFile{raw}
 		}
 	}
 	exclusives := make([]*RawFile, len(exclusivesMap))
@@ -147,7 +183,7 @@ func (mgr *DatabaseMgr) CreateChunks(raws []*RawFile) error {
 			values = append(values, fmt.Sprintf(`('%v', %v, %v)`, raw.Hash, raw.ChunkSize, raw.Version))
 		}
 		sql.WriteString(strings.Join(values, ","))
-		sql.WriteString(" ON CONFLICT (hash) DO UPDATE SET size=excluded.size, version=excluded.version")
+		sql.WriteString(" ON CONFLICT (hash, version) DO UPDATE SET size=excluded.size")
 		err := mgr.db.Exec(sql.String()).Error
 		if err != nil {
 			return err
@@ -163,7 +199,7 @@ func (mgr *DatabaseMgr) CreateChunks(raws []*RawFile) error {
 			values = append(values, fmt.Sprintf(`('%v', %v, %v)`, raw.Hash, raw.ChunkSize, raw.Version))
 		}
 		sql.WriteString(strings.Join(values, ","))
-		sql.WriteString(" ON CONFLICT (hash) DO UPDATE SET size=excluded.size, version=excluded.version")
+		sql.WriteString(" ON CONFLICT (hash, version) DO UPDATE SET size=excluded.size")
 
 		err := mgr.db.Exec(sql.String()).Error
 		if err != nil {
@@ -176,7 +212,7 @@ func (mgr *DatabaseMgr) CreateChunks(raws []*RawFile) error {
 		}
 	} else {
 		for _, raw := range exclusives {
-			chunk := &Chunk{Hash: raw.Hash, Size: raw.ChunkSize}
+			chunk := &Chunk{Hash: raw.Hash, Size: raw.ChunkSize, Version: raw.Version}
 			err := mgr.CreateChunk(chunk)
 			if err != nil {
 				return err
@@ -188,7 +224,10 @@ func (mgr *DatabaseMgr) CreateChunks(raws []*RawFile) error {
 	// Got exclusive chunks.
 	// Need to distribute them to appropriate files.
 	for _, chunk := range chunks {
-		raws := chunkMap[chunk.Hash]
+		if chunk == nil {
+			continue
+		}
+		raws := chunkMap[chunkKey(chunk.Hash, chunk.Version)]
 		for _, raw := range raws {
 			raw.ChunkID = chunk.ID
 		}
@@ -201,9 +240,9 @@ func (mgr *DatabaseMgr) UpdateChunk(chunk *Chunk) (*Chunk, error) {
 	return chunk, err
 }
 
-func (mgr *DatabaseMgr) GetChunk(hash string) (*Chunk, error) {
+func (mgr *DatabaseMgr) GetChunk(hash string, version byte) (*Chunk, error) {
 	var chunk = Chunk{}
-	err := mgr.db.First(&chunk, Chunk{Hash: hash}).Error
+	err := mgr.db.First(&chunk, "hash = ? AND version = ?", hash, version).Error
 	return &chunk, err
 }
 
@@ -234,3 +273,98 @@ func (mgr *DatabaseMgr) DeleteChunks(chunks []Chunk) error {
 	err := mgr.db.Where(where.String()).Delete(&chunks).Error
 	return err
 }
+
+// notUsedChunks selects chunks which are not used by any file
+// and are not pinned by push or upload sessions.
+const notUsedChunks = "chunks.ref_count <= 0 " +
+	"AND NOT EXISTS (SELECT 1 FROM push_chunks WHERE push_chunks.hash = chunks.hash) " +
+	"AND NOT EXISTS (SELECT 1 FROM upload_chunks WHERE upload_chunks.hash = chunks.hash)"
+
+// unreferencedChunks selects not used chunks which were released
+// before the GC grace period.
+const unreferencedChunks = notUsedChunks +
+	" AND (chunks.updated_at IS NULL OR chunks.updated_at < ?)"
+
+// releasedChunks also selects not used chunks released since the given time.
+const releasedChunks = notUsedChunks +
+	" AND (chunks.updated_at IS NULL OR chunks.updated_at < ? OR chunks.updated_at >= ?)"
+
+func gcGraceTime() types.Time {
+	return types.NewTime(time.Now().Add(-utils.GCGracePeriod()))
+}
+
+// ListUnreferencedChunks returns chunks which can be deleted by GC.
+func (mgr *DatabaseMgr) ListUnreferencedChunks(limit int) ([]Chunk, error) {
+	var chunks = make([]Chunk, 0)
+	err := mgr.db.Where(unreferencedChunks, gcGraceTime()).Limit(limit).Find(&chunks).Error
+	return chunks, err
+}
+
+// ListReleasedChunks returns chunks which can be deleted by GC and chunks
+// released since the time, which GC deletes after the grace period.
+func (mgr *DatabaseMgr) ListReleasedChunks(since time.Time, limit int) ([]Chunk, error) {
+	var chunks = make([]Chunk, 0)
+	err := mgr.db.Where(releasedChunks, gcGraceTime(), types.NewTime(since)).Limit(limit).Find(&chunks).Error
+	return chunks, err
+}
+
+// ReleasedChunksStat returns the number and the total size of chunks
+// which ListReleasedChunks returns.
+func (mgr *DatabaseMgr) ReleasedChunksStat(since time.Time) (count int64, size int64, err error) {
+	var stat = struct {
+		Count int64
+		Size  int64
+	}{}
+	err = mgr.db.Raw(
+		"SELECT COUNT(*) as count, COALESCE(SUM(size), 0) as size FROM chunks WHERE "+releasedChunks,
+		gcGraceTime(), types.NewTime(since),
+	).Scan(&stat).Error
+	return stat.Count, stat.Size, err
+}
+
+// DeleteUnreferencedChunks deletes the chunks unless they got referenced
+// or pinned again.
+func (mgr *DatabaseMgr) DeleteUnreferencedChunks(chunks []Chunk) (int64, error) {
+	ids := make([]uint, len(chunks))
+	for i, c := range chunks {
+		ids[i] = c.ID
+	}
+	res := mgr.db.Exec("DELETE FROM chunks WHERE id IN (?) AND "+unreferencedChunks, ids, gcGraceTime())
+	return res.RowsAffected, res.Error
+}
+
+// RecountChunkRefs sets reference counts of all chunks from file_chunks.
+func (mgr *DatabaseMgr) RecountChunkRefs() error {
+	return mgr.db.Exec(
+		"UPDATE chunks SET ref_count = (SELECT COUNT(*) FROM file_chunks WHERE file_chunks.chunk_id = chunks.id)",
+	).Error
+}
+
+// refreshChunkRefs recounts references of the given chunks.
+func (mgr *DatabaseMgr) refreshChunkRefs(chunkIDs []uint) error {
+	unique := make(map[uint]bool)
+	ids := make([]uint, 0)
+	for _, id := range chunkIDs {
+		if !unique[id] {
+			unique[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for len(ids) > 0 {
+		n := 500
+		if n > len(ids) {
+			n = len(ids)
+		}
+		err := mgr.db.Exec(
+			"UPDATE chunks SET ref_count = "+
+				"(SELECT COUNT(*) FROM file_chunks WHERE file_chunks.chunk_id = chunks.id), updated_at = ? "+
+				"WHERE id IN (?)",
+			types.NewTime(time.Now()), ids[:n],
+		).Error
+		if err != nil {
+			return err
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
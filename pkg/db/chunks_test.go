@@ -0,0 +1,174 @@
+package db
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+func refCounts(t *testing.T) map[string]int64 {
+	chunks, err := DbMgr.ListChunks(Chunk{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int64)
+	for _, c := range chunks {
+		counts[c.Hash] = c.RefCount
+	}
+	return counts
+}
+
+func TestChunkRefCount(t *testing.T) {
+	setup()
+	defer teardown()
+	defer os.Unsetenv("GC_GRACE_PERIOD")
+	os.Setenv("GC_GRACE_PERIOD", "0s")
+
+	raws := []*RawFile{
+		{Hash: "a", ChunkSize: 1, Version: 2},
+		{Hash: "b", ChunkSize: 1, Version: 2},
+	}
+	if err := DbMgr.CreateChunks(raws); err != nil {
+		t.Fatal(err)
+	}
+	files := []*File{
+		{Path: "f1", DatasetName: "ds", DatasetType: "dataset", Workspace: "ws", Version: "1.0.0"},
+		{Path: "dir/f2", DatasetName: "ds", DatasetType: "dataset", Workspace: "ws", Version: "1.0.0"},
+	}
+	if err := DbMgr.CreateFiles(files); err != nil {
+		t.Fatal(err)
+	}
+	fileChunks := []*FileChunk{
+		{FileID: files[0].ID, ChunkID: raws[0].ChunkID, ChunkIndex: 0},
+		{FileID: files[0].ID, ChunkID: raws[0].ChunkID, ChunkIndex: 1},
+		{FileID: files[1].ID, ChunkID: raws[0].ChunkID, ChunkIndex: 0},
+		{FileID: files[1].ID, ChunkID: raws[1].ChunkID, ChunkIndex: 1},
+	}
+	if err := DbMgr.CreateFileChunks(fileChunks); err != nil {
+		t.Fatal(err)
+	}
+	// Duplicates are not counted twice.
+	if err := DbMgr.CreateFileChunks(fileChunks[:1]); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(map[string]int64{"a": 3, "b": 1}, refCounts(t), t)
+
+	started := time.Now()
+	if err := DbMgr.DeleteFileChunk(files[1].ID, raws[1].ChunkID); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(map[string]int64{"a": 3, "b": 0}, refCounts(t), t)
+
+	if _, err := DbMgr.DeleteFiles("dataset", "ws", "ds", "1.0.0", "f1", true); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(map[string]int64{"a": 1, "b": 0}, refCounts(t), t)
+
+	unused, err := DbMgr.ListUnreferencedChunks(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(1, len(unused), t)
+	utils.Assert("b", unused[0].Hash, t)
+
+	// Recently released and pinned chunks are kept.
+	os.Setenv("GC_GRACE_PERIOD", "1h")
+	recent, err := DbMgr.ListUnreferencedChunks(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(0, len(recent), t)
+	released, err := DbMgr.ListReleasedChunks(started, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(1, len(released), t)
+	utils.Assert("b", released[0].Hash, t)
+	released, err = DbMgr.ListReleasedChunks(time.Now(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(0, len(released), t)
+	os.Setenv("GC_GRACE_PERIOD", "0s")
+	if err = DbMgr.PinPushChunks("session", []string{"b"}); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := DbMgr.DeleteUnreferencedChunks(unused)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(int64(0), deleted, t)
+	if err = DbMgr.DeletePushSession("session"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Chunk referenced again is not deleted.
+	if err = DbMgr.CreateFileChunk(&FileChunk{FileID: files[1].ID, ChunkID: raws[1].ChunkID, ChunkIndex: 1}); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err = DbMgr.DeleteUnreferencedChunks(unused)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(int64(0), deleted, t)
+
+	if _, err = DbMgr.DeleteFiles("dataset", "ws", "ds", "1.0.0", "", false); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(map[string]int64{"a": 0, "b": 0}, refCounts(t), t)
+
+	// Recount gives the same result as incremental updates.
+	if err = DbMgr.RecountChunkRefs(); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(map[string]int64{"a": 0, "b": 0}, refCounts(t), t)
+
+	unused, err = DbMgr.ListUnreferencedChunks(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err = DbMgr.DeleteUnreferencedChunks(unused)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(int64(2), deleted, t)
+}
+
+func TestChunkVersions(t *testing.T) {
+	setup()
+	defer teardown()
+
+	// The same content stored plain and compressed.
+	raws := []*RawFile{
+		{Hash: "a", ChunkSize: 1, Version: 2},
+		{Hash: "a", ChunkSize: 1, Version: 4},
+	}
+	if err := DbMgr.CreateChunks(raws); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(true, raws[0].ChunkID != raws[1].ChunkID, t)
+
+	// Saving one version again doesn't change the other.
+	again := []*RawFile{{Hash: "a", ChunkSize: 1, Version: 2}}
+	if err := DbMgr.CreateChunks(again); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(raws[0].ChunkID, again[0].ChunkID, t)
+	for _, raw := range raws {
+		chunk, err := DbMgr.GetChunk(raw.Hash, raw.Version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		utils.Assert(raw.ChunkID, chunk.ID, t)
+		utils.Assert(raw.Version, chunk.Version, t)
+	}
+
+	chunks, err := DbMgr.ListChunksByUniqueHash(raws[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(1, len(chunks), t)
+	utils.Assert(byte(4), chunks[0].Version, t)
+}
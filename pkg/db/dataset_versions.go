@@ -28,6 +28,8 @@ type DatasetVersion struct {
 	FileCount int64  `json:"file_count"`
 	Deleted   bool   `json:"deleted"`
 	Editing   bool   `json:"editing"`
+
+	Description string `json:"description"`
 }
 
 func (mgr *DatabaseMgr) CreateDatasetVersion(datasetVersion *DatasetVersion) error {
@@ -101,7 +103,7 @@ func (mgr *DatabaseMgr) UpdateDatasetVersionSize(dsType, workspace, name, versio
 	sql := `UPDATE dataset_versions
 	SET
 	size = (
-		SELECT sum(size) as size FROM files WHERE files.workspace = dataset_versions.workspace
+		SELECT COALESCE(sum(size), 0) as size FROM files WHERE files.workspace = dataset_versions.workspace
 		AND files.dataset_name = dataset_versions.name
 		AND files.version = dataset_versions.version
 		AND files.dataset_type = dataset_versions.type
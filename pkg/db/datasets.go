@@ -17,6 +17,8 @@ type Dataset struct {
 	Name      string `json:"name"`
 	Type      string `json:"type" gorm:"index:idx_workspace_type"`
 	Deleted   bool   `json:"deleted"`
+
+	Description string `json:"description"`
 }
 
 func (mgr *DatabaseMgr) CreateDataset(dataset *Dataset) error {
@@ -20,6 +20,12 @@ type DataMgr interface {
 	FileChunkMgr
 	DatasetMgr
 	DatasetVersionMgr
+	QuotaMgr
+	UploadSessionMgr
+	PushSessionMgr
+	VersionAliasMgr
+	LabelMgr
+	ConsistencyMgr
 	DB() *gorm.DB
 	DBType() string
 	Begin() *DatabaseMgr
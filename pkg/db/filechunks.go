@@ -37,14 +37,23 @@ type FileChunkHash struct {
 	Chunk
 }
 
+// CreateFileChunk and CreateFileChunks update reference counts of the chunks,
+// so they must be called in the same transaction with other changes.
 func (mgr *DatabaseMgr) CreateFileChunk(file *FileChunk) error {
+	if err := mgr.createFileChunk(file); err != nil {
+		return err
+	}
+	return mgr.refreshChunkRefs([]uint{file.ChunkID})
+}
+
+func (mgr *DatabaseMgr) createFileChunk(file *FileChunk) error {
 	if mgr.DBType() == "sqlite3" {
 		tpl := "INSERT INTO file_chunks " +
-			"(file_id, chunk_id, chunk_index) VALUES (?, ?, ?) ON CONFLICT (file_id,chunk_id) DO NOTHING"
+			"(file_id, chunk_id, chunk_index) VALUES (?, ?, ?) ON CONFLICT (file_id, chunk_id, chunk_index) DO NOTHING"
 		return mgr.db.Exec(tpl, file.FileID, file.ChunkID, file.ChunkIndex).Error
 	} else if mgr.DBType() == "postgres" {
 		tpl := "INSERT INTO file_chunks " +
-			"(file_id, chunk_id, chunk_index) VALUES (?, ?, ?) ON CONFLICT (file_id,chunk_id) DO NOTHING"
+			"(file_id, chunk_id, chunk_index) VALUES (?, ?, ?) ON CONFLICT (file_id, chunk_id, chunk_index) DO NOTHING"
 		return mgr.db.Exec(tpl, file.FileID, file.ChunkID, file.ChunkIndex).Error
 	} else {
 		return mgr.db.Create(file).Error
@@ -52,6 +61,20 @@ func (mgr *DatabaseMgr) CreateFileChunk(file *FileChunk) error {
 }
 
 func (mgr *DatabaseMgr) CreateFileChunks(fileChunks []*FileChunk) error {
+	if len(fileChunks) == 0 {
+		return nil
+	}
+	if err := mgr.createFileChunks(fileChunks); err != nil {
+		return err
+	}
+	ids := make([]uint, len(fileChunks))
+	for i, fc := range fileChunks {
+		ids[i] = fc.ChunkID
+	}
+	return mgr.refreshChunkRefs(ids)
+}
+
+func (mgr *DatabaseMgr) createFileChunks(fileChunks []*FileChunk) error {
 	sql := strings.Builder{}
 	if mgr.DBType() == "postgres" {
 		sql.WriteString("INSERT INTO file_chunks (file_id, chunk_id, chunk_index) VALUES ")
@@ -75,7 +98,7 @@ func (mgr *DatabaseMgr) CreateFileChunks(fileChunks []*FileChunk) error {
 		return mgr.db.Exec(sql.String()).Error
 	} else {
 		for _, raw := range fileChunks {
-			fileChunk := &FileChunk{FileID: raw.FileID, ChunkID: raw.ChunkID}
+			fileChunk := &FileChunk{FileID: raw.FileID, ChunkID: raw.ChunkID, ChunkIndex: raw.ChunkIndex}
 			err := mgr.db.Create(fileChunk).Error
 			if err != nil {
 				return err
@@ -117,7 +140,11 @@ func (mgr *DatabaseMgr) ListFileChunksByChunks(chunks []Chunk) ([]*FileChunk, er
 }
 
 func (mgr *DatabaseMgr) DeleteFileChunk(fileID, chunkID uint) error {
-	return mgr.db.Delete(FileChunk{}, FileChunk{FileID: fileID, ChunkID: chunkID}).Error
+	err := mgr.db.Delete(FileChunk{}, FileChunk{FileID: fileID, ChunkID: chunkID}).Error
+	if err != nil {
+		return err
+	}
+	return mgr.refreshChunkRefs([]uint{chunkID})
 }
 
 func (mgr *DatabaseMgr) ListRelatedChunksForFiles(
@@ -190,12 +217,29 @@ func (mgr *DatabaseMgr) DeleteFiles(dsType, workspace, dataset, version, prefix
 		conditions = append(conditions, cond)
 	}
 	condition := strings.Join(conditions, " AND ")
+
+	// Release references of the chunks before the relations are gone,
+	// chunks with zero count are swept by GC.
+	// The grace period of released chunks counts from now.
+	sqlReleaseChunks := fmt.Sprintf(
+		"UPDATE chunks SET ref_count = ref_count - ("+
+			"SELECT COUNT(*) FROM file_chunks WHERE file_chunks.chunk_id = chunks.id AND file_chunks.file_id in ("+
+			"SELECT id from files where %v)), updated_at = ? "+
+			"WHERE id in (SELECT chunk_id FROM file_chunks WHERE file_id in ("+
+			"SELECT id from files where %v))", condition, condition,
+	)
+	releaseValues := append(append(append([]interface{}{}, values...), libtypes.NewTime(time.Now())), values...)
+	err := mgr.db.Exec(sqlReleaseChunks, releaseValues...).Error
+	if err != nil {
+		return 0, err
+	}
+
 	sqlDeleteRelation := fmt.Sprintf(
 		"DELETE FROM file_chunks where file_id in ("+
 			"SELECT id from files where %v)", condition,
 	)
 
-	err := mgr.db.Exec(sqlDeleteRelation, values...).Error
+	err = mgr.db.Exec(sqlDeleteRelation, values...).Error
 	if err != nil {
 		return 0, err
 	}
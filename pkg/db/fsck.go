@@ -0,0 +1,93 @@
+package db
+
+// ConsistencyMgr finds rows which disagree with each other.
+type ConsistencyMgr interface {
+	ListChunksAfter(id uint, limit int) ([]*Chunk, error)
+	CountWrongChunkRefs() (int64, error)
+	CountOrphanFileChunks() (int64, error)
+	DeleteOrphanFileChunks() (int64, error)
+	ListBrokenFiles() ([]*File, error)
+	ListVersionStatMismatches() ([]*VersionStat, error)
+}
+
+// VersionStat is the size and the file count of the version
+// as they are stored and as they are counted by files.
+type VersionStat struct {
+	Type            string
+	Workspace       string
+	Name            string
+	Version         string
+	Size            int64
+	FileCount       int64
+	ActualSize      int64
+	ActualFileCount int64
+}
+
+const orphanFileChunksWhere = "NOT EXISTS (SELECT 1 FROM files WHERE files.id = file_chunks.file_id) " +
+	"OR NOT EXISTS (SELECT 1 FROM chunks WHERE chunks.id = file_chunks.chunk_id)"
+
+// ListChunksAfter returns chunks ordered by ID starting after the given one.
+func (mgr *DatabaseMgr) ListChunksAfter(id uint, limit int) ([]*Chunk, error) {
+	var chunks = make([]*Chunk, 0)
+	err := mgr.db.Where("id > ?", id).Order("id").Limit(limit).Find(&chunks).Error
+	return chunks, err
+}
+
+// CountWrongChunkRefs returns the number of chunks which reference count
+// differs from the number of file_chunks rows.
+func (mgr *DatabaseMgr) CountWrongChunkRefs() (int64, error) {
+	var count int64
+	err := mgr.db.Raw(
+		"SELECT COUNT(*) FROM chunks WHERE ref_count <> " +
+			"(SELECT COUNT(*) FROM file_chunks WHERE file_chunks.chunk_id = chunks.id)",
+	).Row().Scan(&count)
+	return count, err
+}
+
+// CountOrphanFileChunks returns the number of file_chunks rows
+// which file or chunk does not exist.
+func (mgr *DatabaseMgr) CountOrphanFileChunks() (int64, error) {
+	var count int64
+	err := mgr.db.Raw("SELECT COUNT(*) FROM file_chunks WHERE " + orphanFileChunksWhere).Row().Scan(&count)
+	return count, err
+}
+
+// DeleteOrphanFileChunks deletes file_chunks rows which file or chunk does not exist.
+// Reference counts are to be recounted afterwards.
+func (mgr *DatabaseMgr) DeleteOrphanFileChunks() (int64, error) {
+	res := mgr.db.Exec("DELETE FROM file_chunks WHERE " + orphanFileChunksWhere)
+	return res.RowsAffected, res.Error
+}
+
+// ListBrokenFiles returns files which chunk indexes do not go from 0 without
+// gaps and duplicates, including non-empty files without chunks.
+func (mgr *DatabaseMgr) ListBrokenFiles() ([]*File, error) {
+	var files = make([]*File, 0)
+	err := mgr.db.Raw(`SELECT files.* FROM files LEFT JOIN (
+		SELECT file_id, COUNT(*) AS total, COUNT(DISTINCT chunk_index) AS uniq,
+		MIN(chunk_index) AS lo, MAX(chunk_index) AS hi
+		FROM file_chunks GROUP BY file_id
+	) fc ON fc.file_id = files.id
+	WHERE (fc.file_id IS NULL AND files.size > 0)
+	OR fc.lo <> 0 OR fc.hi + 1 <> fc.uniq OR fc.total <> fc.uniq
+	ORDER BY files.id`).Scan(&files).Error
+	return files, err
+}
+
+// ListVersionStatMismatches returns not deleted versions which size
+// or file count disagree with their files.
+func (mgr *DatabaseMgr) ListVersionStatMismatches() ([]*VersionStat, error) {
+	var stats = make([]*VersionStat, 0)
+	err := mgr.db.Raw(`SELECT dv.type, dv.workspace, dv.name, dv.version,
+		COALESCE(dv.size, 0) AS size, COALESCE(dv.file_count, 0) AS file_count,
+		COALESCE(f.size, 0) AS actual_size, COALESCE(f.file_count, 0) AS actual_file_count
+	FROM dataset_versions dv LEFT JOIN (
+		SELECT dataset_type, workspace, dataset_name, version, SUM(size) AS size, COUNT(*) AS file_count
+		FROM files GROUP BY dataset_type, workspace, dataset_name, version
+	) f ON f.dataset_type = dv.type AND f.workspace = dv.workspace
+		AND f.dataset_name = dv.name AND f.version = dv.version
+	WHERE dv.deleted = ? AND (dv.size IS NULL OR dv.file_count IS NULL
+		OR dv.size <> COALESCE(f.size, 0) OR dv.file_count <> COALESCE(f.file_count, 0))
+	ORDER BY dv.id`, false).Scan(&stats).Error
+	return stats, err
+}
@@ -0,0 +1,80 @@
+package db
+
+type LabelMgr interface {
+	ListLabels(dsType, workspace, name, version string) ([]*Label, error)
+	ListWorkspaceLabels(dsType, workspace string) ([]*Label, error)
+	SetLabels(dsType, workspace, name, version string, labels map[string]string) error
+	DeleteLabel(dsType, workspace, name, version, key string) error
+	DeleteLabels(dsType, workspace, name string) error
+	DeleteVersionLabels(dsType, workspace, name, version string) error
+}
+
+// Label is a key/value pair attached to a dataset or, if Version is set, to its version.
+type Label struct {
+	ID        uint   `json:"-" sql:"AUTO_INCREMENT" gorm:"primary_key"`
+	Type      string `json:"type" gorm:"index:idx_label_entity"`
+	Workspace string `json:"workspace" gorm:"index:idx_label_entity"`
+	Name      string `json:"name" gorm:"index:idx_label_entity"`
+	Version   string `json:"version"`
+	// "key" is reserved in MySQL.
+	Key   string `json:"key" gorm:"column:label_key"`
+	Value string `json:"value" gorm:"column:label_value"`
+}
+
+func (mgr *DatabaseMgr) ListLabels(dsType, workspace, name, version string) ([]*Label, error) {
+	var labels = make([]*Label, 0)
+	// Empty version is meaningful here, so the struct filter can't be used.
+	err := mgr.db.Where(
+		"type = ? AND workspace = ? AND name = ? AND version = ?", dsType, workspace, name, version,
+	).Order("label_key").Find(&labels).Error
+	return labels, err
+}
+
+// ListWorkspaceLabels returns labels of all datasets of the type in the workspace.
+// Empty type means any type.
+func (mgr *DatabaseMgr) ListWorkspaceLabels(dsType, workspace string) ([]*Label, error) {
+	var labels = make([]*Label, 0)
+	db := mgr.db.Where("workspace = ? AND version = ?", workspace, "")
+	if dsType != "" {
+		db = db.Where("type = ?", dsType)
+	}
+	err := db.Find(&labels).Error
+	return labels, err
+}
+
+// SetLabels replaces all labels of the dataset or version.
+func (mgr *DatabaseMgr) SetLabels(dsType, workspace, name, version string, labels map[string]string) error {
+	err := mgr.db.Delete(
+		Label{}, "type = ? AND workspace = ? AND name = ? AND version = ?", dsType, workspace, name, version,
+	).Error
+	if err != nil {
+		return err
+	}
+	for k, v := range labels {
+		label := &Label{Type: dsType, Workspace: workspace, Name: name, Version: version, Key: k, Value: v}
+		if err = mgr.db.Create(label).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mgr *DatabaseMgr) DeleteLabel(dsType, workspace, name, version, key string) error {
+	return mgr.db.Delete(
+		Label{},
+		"type = ? AND workspace = ? AND name = ? AND version = ? AND label_key = ?",
+		dsType, workspace, name, version, key,
+	).Error
+}
+
+// DeleteLabels deletes labels of the dataset and all its versions.
+func (mgr *DatabaseMgr) DeleteLabels(dsType, workspace, name string) error {
+	return mgr.db.Delete(Label{}, Label{Type: dsType, Workspace: workspace, Name: name}).Error
+}
+
+// DeleteVersionLabels deletes labels of the version only.
+func (mgr *DatabaseMgr) DeleteVersionLabels(dsType, workspace, name, version string) error {
+	return mgr.db.Delete(
+		Label{}, "type = ? AND workspace = ? AND name = ? AND version = ?", dsType, workspace, name, version,
+	).Error
+}
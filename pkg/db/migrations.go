@@ -0,0 +1,489 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+	"github.com/kuberlab/lib/pkg/types"
+	"github.com/pborman/uuid"
+)
+
+const migrationLockName = "migrations"
+
+var (
+	// Lock which is not refreshed for this time is of a crashed instance
+	// and is taken over.
+	migrationLockTTL = time.Minute * 5
+	// The holder refreshes the lock while migrations run.
+	migrationLockRefresh = time.Minute
+)
+
+// Migration is a numbered change of the DB schema or data. Migrations are
+// applied in order of IDs and recorded in schema_migrations, so each runs once.
+// Applied migrations must not be changed, new changes go to new migrations.
+// Down reverts Up, nil Down means there is nothing to revert.
+// Irreversible migrations are never reverted.
+type Migration struct {
+	ID           uint
+	Name         string
+	Up           func(db *gorm.DB) error
+	Down         func(db *gorm.DB) error
+	Irreversible bool
+}
+
+// SchemaMigration is a record of the applied migration.
+type SchemaMigration struct {
+	ID        uint       `json:"id" gorm:"primary_key"`
+	Name      string     `json:"name"`
+	AppliedAt types.Time `json:"applied_at"`
+}
+
+// SchemaMigrationLock is held by the instance which applies migrations.
+type SchemaMigrationLock struct {
+	Name     string     `json:"name" gorm:"primary_key"`
+	Owner    string     `json:"owner"`
+	LockedAt types.Time `json:"locked_at"`
+}
+
+var migrations = []Migration{
+	// It adopts existing tables, reverting would drop all data.
+	{ID: 1, Name: "initial schema", Up: initialSchemaUp, Irreversible: true},
+	{ID: 2, Name: "count chunk references", Up: countChunkRefsUp},
+	{ID: 3, Name: "count version sizes", Up: countVersionSizesUp},
+	{ID: 4, Name: "chunk identity by hash and version", Up: chunkVersionKeyUp, Down: chunkVersionKeyDown},
+	// Extra columns are harmless for older versions, so they are kept on revert.
+	{ID: 5, Name: "expected size of upload sessions", Up: uploadSessionSizeUp},
+}
+
+// Migrations returns all known migrations in order.
+func Migrations() []Migration {
+	res := append([]Migration{}, migrations...)
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+func createMigrationTables(db *gorm.DB) error {
+	return db.AutoMigrate(&SchemaMigration{}, &SchemaMigrationLock{}).Error
+}
+
+// AppliedMigrations returns records of applied migrations in order.
+func AppliedMigrations(db *gorm.DB) ([]*SchemaMigration, error) {
+	if err := createMigrationTables(db); err != nil {
+		return nil, err
+	}
+	var applied = make([]*SchemaMigration, 0)
+	err := db.Order("id").Find(&applied).Error
+	return applied, err
+}
+
+// PendingMigrations returns migrations which are not applied yet.
+func PendingMigrations(db *gorm.DB) ([]Migration, error) {
+	applied, err := AppliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[uint]bool)
+	for _, m := range applied {
+		done[m.ID] = true
+	}
+	pending := make([]Migration, 0)
+	for _, m := range Migrations() {
+		if !done[m.ID] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// MigrateUp applies pending migrations and returns them. It is safe to run
+// on several instances at once: one applies, the others wait for it.
+func MigrateUp(db *gorm.DB) ([]Migration, error) {
+	if err := createMigrationTables(db); err != nil {
+		return nil, err
+	}
+	unlock, err := lockMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// Pending migrations are listed under the lock, others may have applied them.
+	pending, err := PendingMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	applied := make([]Migration, 0)
+	for _, m := range pending {
+		logrus.Infof("Applying migration %v: %v...", m.ID, m.Name)
+		err = inTransaction(db, func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{ID: m.ID, Name: m.Name, AppliedAt: types.NewTime(time.Now())}).Error
+		})
+		if err != nil {
+			return applied, fmt.Errorf("Failed to apply migration %v: %v", m.ID, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// MigrateDown reverts applied migrations with IDs greater than to
+// in reverse order and returns them.
+func MigrateDown(db *gorm.DB, to uint) ([]Migration, error) {
+	if err := createMigrationTables(db); err != nil {
+		return nil, err
+	}
+	unlock, err := lockMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := AppliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[uint]Migration)
+	for _, m := range Migrations() {
+		known[m.ID] = m
+	}
+	reverted := make([]Migration, 0)
+	for i := len(applied) - 1; i >= 0 && applied[i].ID > to; i-- {
+		m, ok := known[applied[i].ID]
+		if !ok {
+			return reverted, fmt.Errorf("Migration %v is unknown to this version", applied[i].ID)
+		}
+		if m.Irreversible {
+			return reverted, fmt.Errorf("Migration %v (%v) can not be reverted", m.ID, m.Name)
+		}
+		logrus.Infof("Reverting migration %v: %v...", m.ID, m.Name)
+		err = inTransaction(db, func(tx *gorm.DB) error {
+			if m.Down != nil {
+				if err := m.Down(tx); err != nil {
+					return err
+				}
+			}
+			return tx.Delete(SchemaMigration{}, "id = ?", m.ID).Error
+		})
+		if err != nil {
+			return reverted, fmt.Errorf("Failed to revert migration %v: %v", m.ID, err)
+		}
+		reverted = append(reverted, m)
+	}
+	return reverted, nil
+}
+
+func inTransaction(db *gorm.DB, f func(tx *gorm.DB) error) error {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// lockMigrations waits until the lock row is inserted by this instance.
+// Waiting lasts as long as the holder refreshes the lock.
+func lockMigrations(db *gorm.DB) (unlock func(), err error) {
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%v/%v", host, uuid.New())
+	for {
+		err = db.Delete(
+			SchemaMigrationLock{}, "name = ? AND locked_at < ?",
+			migrationLockName, types.NewTime(time.Now().Add(-migrationLockTTL)),
+		).Error
+		if err != nil {
+			return nil, err
+		}
+		lock := &SchemaMigrationLock{Name: migrationLockName, Owner: owner, LockedAt: types.NewTime(time.Now())}
+		if err = db.Create(lock).Error; err == nil {
+			break
+		}
+		held := SchemaMigrationLock{}
+		if db.First(&held, "name = ?", migrationLockName).RecordNotFound() {
+			// Failed not because of the lock held.
+			return nil, err
+		}
+		logrus.Infof("Waiting for migrations applied by %v...", held.Owner)
+		time.Sleep(time.Second * 2)
+	}
+
+	// A single migration may run longer than the TTL.
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(migrationLockRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				refreshMigrationLock(db, owner)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		err := db.Delete(SchemaMigrationLock{}, "name = ? AND owner = ?", migrationLockName, owner).Error
+		if err != nil {
+			logrus.Errorf("Failed to release migrations lock: %v", err)
+		}
+	}, nil
+}
+
+func refreshMigrationLock(db *gorm.DB, owner string) {
+	err := db.Model(&SchemaMigrationLock{}).Where("name = ? AND owner = ?", migrationLockName, owner).
+		Update("locked_at", types.NewTime(time.Now())).Error
+	if err != nil {
+		logrus.Errorf("Failed to refresh migrations lock: %v", err)
+	}
+}
+
+func addIndex(db *gorm.DB, model interface{}, unique bool, name string, columns ...string) error {
+	scope := db.NewScope(model)
+	if scope.Dialect().HasIndex(scope.TableName(), name) {
+		return nil
+	}
+	if unique {
+		return db.Model(model).AddUniqueIndex(name, columns...).Error
+	}
+	return db.Model(model).AddIndex(name, columns...).Error
+}
+
+// initialSchemaUp creates tables as they were before migrations were introduced,
+// existing tables only get missing columns and indexes.
+func initialSchemaUp(db *gorm.DB) error {
+	// Models are copied as of this migration.
+	type BaseModel struct {
+		CreatedAt types.Time
+		UpdatedAt types.Time
+	}
+	type File struct {
+		BaseModel
+		ID          uint   `sql:"AUTO_INCREMENT" gorm:"primary_key"`
+		Path        string `gorm:"unique_index:idx_ws_name_version_path_type"`
+		Size        int64
+		Mode        uint32
+		DatasetName string `gorm:"unique_index:idx_ws_name_version_path_type"`
+		DatasetType string `gorm:"unique_index:idx_ws_name_version_path_type"`
+		Workspace   string `gorm:"unique_index:idx_ws_name_version_path_type"`
+		Version     string `gorm:"unique_index:idx_ws_name_version_path_type"`
+	}
+	type Chunk struct {
+		BaseModel
+		ID       uint   `sql:"AUTO_INCREMENT"`
+		Hash     string `gorm:"primary_key"`
+		Size     int64
+		Version  byte
+		RefCount int64 `gorm:"index"`
+	}
+	type FileChunk struct {
+		FileID     uint `gorm:"unique_index:file_chunk_id_index"`
+		ChunkID    uint `gorm:"unique_index:file_chunk_id_index"`
+		ChunkIndex uint `gorm:"unique_index:file_chunk_id_index"`
+	}
+	type Dataset struct {
+		BaseModel
+		ID          uint   `sql:"AUTO_INCREMENT" gorm:"primary_key"`
+		Workspace   string `gorm:"index:idx_workspace_type"`
+		Name        string
+		Type        string `gorm:"index:idx_workspace_type"`
+		Deleted     bool
+		Description string
+	}
+	type DatasetVersion struct {
+		BaseModel
+		ID          uint   `sql:"AUTO_INCREMENT" gorm:"primary_key"`
+		Workspace   string `gorm:"index:idx_workspace_name_type"`
+		Name        string `gorm:"index:idx_workspace_name_type"`
+		Version     string
+		Message     string
+		Type        string `gorm:"index:idx_workspace_name_type"`
+		Size        int64
+		FileCount   int64
+		Deleted     bool
+		Editing     bool
+		Description string
+	}
+	type Quota struct {
+		BaseModel
+		Workspace    string `gorm:"primary_key"`
+		MaxSize      int64
+		MaxChunkSize int64
+	}
+	type UploadSession struct {
+		BaseModel
+		ID          string `gorm:"primary_key"`
+		Workspace   string
+		Name        string
+		Type        string
+		Version     string
+		Path        string
+		Mode        uint32
+		CDC         bool
+		Compress    bool
+		HashVersion byte
+	}
+	type UploadChunk struct {
+		SessionID  string `gorm:"unique_index:idx_session_part_chunk"`
+		Part       uint   `gorm:"unique_index:idx_session_part_chunk"`
+		ChunkIndex uint   `gorm:"unique_index:idx_session_part_chunk"`
+		Hash       string
+		Size       int64
+		Version    byte
+	}
+	type PushSession struct {
+		BaseModel
+		ID        string `gorm:"primary_key"`
+		Workspace string
+		Name      string
+		Type      string
+		Version   string
+	}
+	type PushChunk struct {
+		SessionID string `gorm:"unique_index:idx_push_session_hash"`
+		Hash      string `gorm:"unique_index:idx_push_session_hash"`
+	}
+	type VersionAlias struct {
+		BaseModel
+		Type      string `gorm:"primary_key"`
+		Workspace string `gorm:"primary_key"`
+		Name      string `gorm:"primary_key"`
+		Alias     string `gorm:"primary_key"`
+		Version   string
+	}
+	type Label struct {
+		ID        uint   `sql:"AUTO_INCREMENT" gorm:"primary_key"`
+		Type      string `gorm:"index:idx_label_entity"`
+		Workspace string `gorm:"index:idx_label_entity"`
+		Name      string `gorm:"index:idx_label_entity"`
+		Version   string
+		Key       string `gorm:"column:label_key"`
+		Value     string `gorm:"column:label_value"`
+	}
+
+	err := db.AutoMigrate(
+		&File{},
+		&Chunk{},
+		&FileChunk{},
+		&Dataset{},
+		&DatasetVersion{},
+		&Quota{},
+		&UploadSession{},
+		&UploadChunk{},
+		&PushSession{},
+		&PushChunk{},
+		&VersionAlias{},
+		&Label{},
+	).Error
+	if err != nil {
+		return err
+	}
+
+	if err = addIndex(db, &FileChunk{}, false, "idx_chunk_id", "chunk_id"); err != nil {
+		return err
+	}
+	err = addIndex(
+		db, &File{}, false, "idx_ws_name_version_type",
+		"dataset_name", "dataset_type", "workspace", "version",
+	)
+	if err != nil {
+		return err
+	}
+	switch db.Dialect().GetName() {
+	case "sqlite3":
+		return addIndex(db, &Chunk{}, true, "idx_hash", "hash")
+	case "postgres":
+		return addIndex(db, &Chunk{}, false, "idx_chunks_id", "id")
+	}
+	return nil
+}
+
+// countChunkRefsUp fills reference counts of chunks created before they were counted.
+func countChunkRefsUp(db *gorm.DB) error {
+	return NewDatabaseMgr(db).RecountChunkRefs()
+}
+
+// countVersionSizesUp fixes sizes and file counts of versions
+// which were not updated by older versions.
+func countVersionSizesUp(db *gorm.DB) error {
+	return db.Exec(`UPDATE dataset_versions
+	SET
+	size = (
+		SELECT COALESCE(sum(size), 0) FROM files WHERE files.workspace = dataset_versions.workspace
+		AND files.dataset_name = dataset_versions.name
+		AND files.version = dataset_versions.version
+		AND files.dataset_type = dataset_versions.type
+	),
+	file_count = (
+		SELECT count(*) FROM files WHERE files.workspace = dataset_versions.workspace
+		AND files.dataset_name = dataset_versions.name
+		AND files.version = dataset_versions.version
+		AND files.dataset_type = dataset_versions.type
+	)`).Error
+}
+
+// chunkVersionKeyUp makes the version a part of the chunk identity,
+// so the same content stored in several versions gets separate rows.
+func chunkVersionKeyUp(db *gorm.DB) error {
+	switch db.Dialect().GetName() {
+	case "sqlite3":
+		if err := db.Model(&Chunk{}).RemoveIndex("idx_hash").Error; err != nil {
+			return err
+		}
+		return addIndex(db, &Chunk{}, true, "idx_hash_version", "hash", "version")
+	case "postgres":
+		return db.Exec("ALTER TABLE chunks DROP CONSTRAINT chunks_pkey, ADD PRIMARY KEY (hash, version)").Error
+	case "mysql":
+		return db.Exec("ALTER TABLE chunks DROP PRIMARY KEY, ADD PRIMARY KEY (hash, version)").Error
+	}
+	return fmt.Errorf("Unsupported database dialect %v", db.Dialect().GetName())
+}
+
+// chunkVersionKeyDown fails if some content is already stored in several versions.
+func chunkVersionKeyDown(db *gorm.DB) error {
+	switch db.Dialect().GetName() {
+	case "sqlite3":
+		if err := db.Model(&Chunk{}).RemoveIndex("idx_hash_version").Error; err != nil {
+			return err
+		}
+		return addIndex(db, &Chunk{}, true, "idx_hash", "hash")
+	case "postgres":
+		return db.Exec("ALTER TABLE chunks DROP CONSTRAINT chunks_pkey, ADD PRIMARY KEY (hash)").Error
+	case "mysql":
+		return db.Exec("ALTER TABLE chunks DROP PRIMARY KEY, ADD PRIMARY KEY (hash)").Error
+	}
+	return fmt.Errorf("Unsupported database dialect %v", db.Dialect().GetName())
+}
+
+// uploadSessionSizeUp adds the expected size and number of parts of upload sessions.
+// Size of sessions created before is unknown.
+func uploadSessionSizeUp(db *gorm.DB) error {
+	columns := []struct{ name, definition string }{
+		{"size", "bigint NOT NULL DEFAULT -1"},
+		{"parts", "integer NOT NULL DEFAULT 0"},
+	}
+	for _, c := range columns {
+		// The initial schema of new databases has them already.
+		if db.Dialect().HasColumn("upload_sessions", c.name) {
+			continue
+		}
+		err := db.Exec(fmt.Sprintf("ALTER TABLE upload_sessions ADD COLUMN %v %v", c.name, c.definition)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
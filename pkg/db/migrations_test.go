@@ -0,0 +1,194 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/kuberlab/lib/pkg/types"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+func TestMigrationsCoverModels(t *testing.T) {
+	setup()
+	defer teardown()
+
+	pending, err := PendingMigrations(DbMgr.DB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(0, len(pending), t)
+
+	// Changes of models must come with migrations.
+	models := []interface{}{
+		&File{}, &Chunk{}, &FileChunk{}, &Dataset{}, &DatasetVersion{}, &Quota{},
+		&UploadSession{}, &UploadChunk{}, &PushSession{}, &PushChunk{}, &VersionAlias{}, &Label{},
+	}
+	for _, model := range models {
+		scope := DbMgr.DB().NewScope(model)
+		for _, field := range scope.GetModelStruct().StructFields {
+			if !field.IsNormal || field.IsIgnored {
+				continue
+			}
+			if !scope.Dialect().HasColumn(scope.TableName(), field.DBName) {
+				t.Fatalf("Column %v.%v is not created by migrations", scope.TableName(), field.DBName)
+			}
+		}
+	}
+}
+
+func TestMigrateDownUp(t *testing.T) {
+	setup()
+	defer teardown()
+	db := DbMgr.DB()
+
+	reverted, err := MigrateDown(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(len(Migrations())-1, len(reverted), t)
+	applied, err := AppliedMigrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(1, len(applied), t)
+
+	// The initial schema is never dropped.
+	_, err = MigrateDown(db, 0)
+	utils.Assert(true, err != nil, t)
+	utils.Assert(true, db.HasTable(&File{}), t)
+
+	done, err := MigrateUp(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(len(Migrations())-1, len(done), t)
+
+	// Nothing left to apply.
+	done, err = MigrateUp(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(0, len(done), t)
+}
+
+func TestMigrationsLock(t *testing.T) {
+	f, err := ioutil.TempFile("", "pluk-migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	db, err := gorm.Open("sqlite3", f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err = createMigrationTables(db); err != nil {
+		t.Fatal(err)
+	}
+	// Lock of a crashed instance is taken over.
+	stale := &SchemaMigrationLock{
+		Name: migrationLockName, Owner: "crashed", LockedAt: types.NewTime(time.Now().Add(-migrationLockTTL * 2)),
+	}
+	if err = db.Create(stale).Error; err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := lockMigrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another instance waits for the lock.
+	start := time.Now()
+	go func() {
+		time.Sleep(time.Millisecond * 500)
+		unlock()
+	}()
+	applied, err := MigrateUp(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(true, time.Since(start) > time.Millisecond*500, t)
+	utils.Assert(len(Migrations()), len(applied), t)
+
+	held := SchemaMigrationLock{}
+	utils.Assert(true, db.First(&held).RecordNotFound(), t)
+}
+
+func TestMigrationsLockRefresh(t *testing.T) {
+	f, err := ioutil.TempFile("", "pluk-migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	db, err := gorm.Open("sqlite3", f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err = createMigrationTables(db); err != nil {
+		t.Fatal(err)
+	}
+	ttl, refresh := migrationLockTTL, migrationLockRefresh
+	migrationLockTTL, migrationLockRefresh = time.Millisecond*500, time.Millisecond*100
+	defer func() { migrationLockTTL, migrationLockRefresh = ttl, refresh }()
+
+	unlock, err := lockMigrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	held := SchemaMigrationLock{}
+	if err = db.First(&held).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// A step running longer than the TTL keeps the lock.
+	time.Sleep(migrationLockTTL * 3)
+	current := SchemaMigrationLock{}
+	if err = db.First(&current).Error; err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(held.Owner, current.Owner, t)
+	utils.Assert(true, time.Since(current.LockedAt.Time) < migrationLockTTL, t)
+
+	unlock()
+	utils.Assert(true, db.First(&current).RecordNotFound(), t)
+}
+
+func TestChunkVersionKeyDownUp(t *testing.T) {
+	setup()
+	defer teardown()
+	db := DbMgr.DB()
+
+	if _, err := MigrateDown(db, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&Chunk{Hash: "hash", Size: 4, Version: 2}).Error; err != nil {
+		t.Fatal(err)
+	}
+	// Chunks are identified by hash only.
+	err := db.Create(&Chunk{Hash: "hash", Size: 4, Version: 4}).Error
+	utils.Assert(true, err != nil, t)
+
+	if _, err = MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if err = DbMgr.CreateChunk(&Chunk{Hash: "hash", Size: 4, Version: 4}); err != nil {
+		t.Fatal(err)
+	}
+	chunk, err := DbMgr.GetChunk("hash", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(byte(4), chunk.Version, t)
+
+	// The same content in several versions can not be keyed by hash again.
+	_, err = MigrateDown(db, 3)
+	utils.Assert(true, err != nil, t)
+}
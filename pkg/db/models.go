@@ -5,9 +5,12 @@ to migrate database state.
 package db
 
 import (
+	"fmt"
+
 	"github.com/Sirupsen/logrus"
 	"github.com/jinzhu/gorm"
 	"github.com/kuberlab/lib/pkg/types"
+	"github.com/kuberlab/pluk/pkg/utils"
 )
 
 // BaseModel is the basic type for all other models
@@ -20,56 +23,22 @@ func NoOp(db *gorm.DB) error {
 	return nil
 }
 
-func CreateTables(db *gorm.DB) error {
-	return db.AutoMigrate(
-		&File{},
-		&Chunk{},
-		&FileChunk{},
-		&Dataset{},
-		&DatasetVersion{},
-	).Error
-}
-
-// CreateAll is to be used for initial database setup on new deployments or
-// adding new tables and columns in existing environments. As for now deletions
-// or type conversions of any type should be handled manually.
+// CreateAll applies pending migrations on start. With DB_MIGRATE=false
+// migrations are applied by plukmigrate and the start fails until they are.
 func CreateAll(db *gorm.DB) error {
-	if err := CreateTables(db); err != nil {
+	if utils.DBMigrate() {
+		applied, err := MigrateUp(db)
+		if len(applied) != 0 {
+			logrus.Infof("Applied %v migrations.", len(applied))
+		}
 		return err
 	}
-
-	if err := db.Debug().Model(&FileChunk{}).AddIndex(
-		"idx_chunk_id",
-		"chunk_id",
-	).Error; err != nil {
-		logrus.Error(err)
-	}
-
-	// CREATE INDEX idx_ws_name_version_type ON "files"(dataset_name, dataset_type, "workspace", "version");
-	if err := db.Debug().Model(&File{}).AddIndex(
-		"idx_ws_name_version_type",
-		"dataset_name", "dataset_type", "workspace", "version",
-	).Error; err != nil {
-		logrus.Error(err)
-	}
-
-	if db.Dialect().GetName() == "sqlite3" {
-		if err := db.Debug().Model(&Chunk{}).AddUniqueIndex(
-			"idx_hash",
-			"hash",
-		).Error; err != nil {
-			logrus.Error(err)
-		}
+	pending, err := PendingMigrations(db)
+	if err != nil {
+		return err
 	}
-
-	if db.Dialect().GetName() == "postgres" {
-		if err := db.Debug().Model(&Chunk{}).AddIndex(
-			"idx_chunks_id",
-			"id",
-		).Error; err != nil {
-			logrus.Error(err)
-		}
+	if len(pending) != 0 {
+		return fmt.Errorf("DB has %v pending migrations, apply them with 'plukmigrate up'", len(pending))
 	}
-
 	return nil
 }
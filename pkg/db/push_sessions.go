@@ -0,0 +1,107 @@
+package db
+
+import (
+	"strings"
+	"time"
+
+	"github.com/kuberlab/lib/pkg/types"
+)
+
+type PushSessionMgr interface {
+	CreatePushSession(session *PushSession) error
+	GetPushSession(id string) (*PushSession, error)
+	DeletePushSession(id string) error
+	DeleteStalePushSessions(before time.Time) (int64, error)
+	PinPushChunks(sessionID string, hashes []string) error
+	ListPushChunks(filter PushChunk) ([]*PushChunk, error)
+}
+
+// PushSession is a push of many files of the version. Chunks uploaded
+// during the session are pinned until the session is closed or expired,
+// so they are not collected before the file structure is saved.
+type PushSession struct {
+	BaseModel
+	ID        string `json:"id" gorm:"primary_key"`
+	Workspace string `json:"workspace"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Version   string `json:"version"`
+}
+
+// PushChunk is a chunk pinned by the push session.
+type PushChunk struct {
+	SessionID string `json:"session_id" gorm:"unique_index:idx_push_session_hash"`
+	Hash      string `json:"hash" gorm:"unique_index:idx_push_session_hash"`
+}
+
+func (mgr *DatabaseMgr) CreatePushSession(session *PushSession) error {
+	session.CreatedAt = types.NewTime(time.Now())
+	session.UpdatedAt = types.NewTime(time.Now())
+	return mgr.db.Create(session).Error
+}
+
+func (mgr *DatabaseMgr) GetPushSession(id string) (*PushSession, error) {
+	var session = PushSession{}
+	err := mgr.db.First(&session, PushSession{ID: id}).Error
+	return &session, err
+}
+
+func (mgr *DatabaseMgr) DeletePushSession(id string) error {
+	if err := mgr.db.Delete(PushChunk{}, PushChunk{SessionID: id}).Error; err != nil {
+		return err
+	}
+	return mgr.db.Delete(PushSession{}, PushSession{ID: id}).Error
+}
+
+// DeleteStalePushSessions deletes sessions which were not updated since before.
+func (mgr *DatabaseMgr) DeleteStalePushSessions(before time.Time) (int64, error) {
+	err := mgr.db.Exec(
+		"DELETE FROM push_chunks WHERE session_id IN (SELECT id FROM push_sessions WHERE updated_at < ?)",
+		types.NewTime(before),
+	).Error
+	if err != nil {
+		return 0, err
+	}
+	res := mgr.db.Exec("DELETE FROM push_sessions WHERE updated_at < ?", types.NewTime(before))
+	return res.RowsAffected, res.Error
+}
+
+// PinPushChunks pins hashes to the session and prolongs the session.
+func (mgr *DatabaseMgr) PinPushChunks(sessionID string, hashes []string) error {
+	err := mgr.db.Model(&PushSession{}).Where("id = ?", sessionID).
+		Update("updated_at", types.NewTime(time.Now())).Error
+	if err != nil {
+		return err
+	}
+
+	for len(hashes) > 0 {
+		n := 500
+		if n > len(hashes) {
+			n = len(hashes)
+		}
+		batch := hashes[:n]
+		hashes = hashes[n:]
+
+		// Concurrent uploads of the same session pin the same hashes.
+		values := make([]interface{}, 0, len(batch)*2)
+		for _, hash := range batch {
+			values = append(values, sessionID, hash)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("(?, ?),", len(batch)), ",")
+		err = mgr.db.Exec(
+			"INSERT INTO push_chunks (session_id, hash) VALUES "+placeholders+
+				" ON CONFLICT (session_id, hash) DO NOTHING",
+			values...,
+		).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mgr *DatabaseMgr) ListPushChunks(filter PushChunk) ([]*PushChunk, error) {
+	var chunks = make([]*PushChunk, 0)
+	err := mgr.db.Find(&chunks, filter).Error
+	return chunks, err
+}
@@ -0,0 +1,28 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+func TestPinPushChunks(t *testing.T) {
+	setup()
+	defer teardown()
+
+	if err := DbMgr.CreatePushSession(&PushSession{ID: "session"}); err != nil {
+		t.Fatal(err)
+	}
+	// Hashes pinned twice, e.g. by concurrent uploads, are kept once.
+	if err := DbMgr.PinPushChunks("session", []string{"a", "b", "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := DbMgr.PinPushChunks("session", []string{"b", "c"}); err != nil {
+		t.Fatal(err)
+	}
+	pinned, err := DbMgr.ListPushChunks(PushChunk{SessionID: "session"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(3, len(pinned), t)
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"strings"
+)
+
+// Chunk hashes are requested in batches to fit in the SQL variables limit.
+const hashBatchSize = 500
+
+type QuotaMgr interface {
+	GetQuota(workspace string) (*Quota, error)
+	SetQuota(quota *Quota) error
+	ListQuotas() ([]*Quota, error)
+	DeleteQuota(workspace string) error
+	GetUsage(workspace string) (*Usage, error)
+	ListWorkspaceChunks(workspace string, chunks []*Chunk) ([]*Chunk, error)
+}
+
+// Quota limits the storage used by a workspace. Zero value means no limit.
+type Quota struct {
+	BaseModel
+	Workspace string `json:"workspace" gorm:"primary_key"`
+	// MaxSize limits logical bytes, i.e. sum of sizes of all files.
+	MaxSize int64 `json:"max_size"`
+	// MaxChunkSize limits bytes of unique chunks referenced by the workspace.
+	MaxChunkSize int64 `json:"max_chunk_size"`
+}
+
+type Usage struct {
+	Workspace string `json:"workspace"`
+	Size      int64  `json:"size"`
+	ChunkSize int64  `json:"chunk_size"`
+}
+
+func (mgr *DatabaseMgr) GetQuota(workspace string) (*Quota, error) {
+	var quota = Quota{}
+	err := mgr.db.First(&quota, Quota{Workspace: workspace}).Error
+	return &quota, err
+}
+
+func (mgr *DatabaseMgr) SetQuota(quota *Quota) error {
+	return mgr.db.Save(quota).Error
+}
+
+func (mgr *DatabaseMgr) ListQuotas() ([]*Quota, error) {
+	var quotas = make([]*Quota, 0)
+	err := mgr.db.Find(&quotas).Error
+	return quotas, err
+}
+
+func (mgr *DatabaseMgr) DeleteQuota(workspace string) error {
+	return mgr.db.Delete(Quota{}, Quota{Workspace: workspace}).Error
+}
+
+// Files of deleted versions are kept until GC, so they are excluded.
+const workspaceFilesSQL = `SELECT files.id FROM files
+	JOIN dataset_versions ON files.workspace = dataset_versions.workspace
+	AND files.dataset_name = dataset_versions.name
+	AND files.version = dataset_versions.version
+	AND files.dataset_type = dataset_versions.type
+	WHERE files.workspace = ? AND dataset_versions.deleted = ?`
+
+func (mgr *DatabaseMgr) GetUsage(workspace string) (*Usage, error) {
+	usage := &Usage{Workspace: workspace}
+
+	var result = struct{ Size int64 }{}
+	sql := "SELECT coalesce(sum(size), 0) as size FROM files WHERE id IN (" + workspaceFilesSQL + ")"
+	if err := mgr.db.Raw(sql, workspace, false).Scan(&result).Error; err != nil {
+		return nil, err
+	}
+	usage.Size = result.Size
+
+	result.Size = 0
+	sql = `SELECT coalesce(sum(size), 0) as size FROM chunks WHERE id IN (
+		SELECT chunk_id FROM file_chunks WHERE file_id IN (` + workspaceFilesSQL + `))`
+	if err := mgr.db.Raw(sql, workspace, false).Scan(&result).Error; err != nil {
+		return nil, err
+	}
+	usage.ChunkSize = result.Size
+	return usage, nil
+}
+
+// ListWorkspaceChunks returns chunks among the given hashes and versions
+// which are already referenced by the workspace files.
+func (mgr *DatabaseMgr) ListWorkspaceChunks(workspace string, chunks []*Chunk) ([]*Chunk, error) {
+	requested := make(map[string]bool)
+	for _, c := range chunks {
+		requested[chunkKey(c.Hash, c.Version)] = true
+	}
+
+	result := make([]*Chunk, 0)
+	for start := 0; start < len(chunks); start += hashBatchSize {
+		end := start + hashBatchSize
+		if end > len(chunks) {
+			end = len(chunks)
+		}
+		batch := chunks[start:end]
+
+		values := make([]interface{}, len(batch))
+		for i, c := range batch {
+			values[i] = c.Hash
+		}
+		placeholders := strings.Repeat("?,", len(batch))[:len(batch)*2-1]
+
+		found := make([]*Chunk, 0)
+		err := mgr.db.
+			Where("id IN (SELECT chunk_id FROM file_chunks WHERE file_id IN ("+workspaceFilesSQL+"))", workspace, false).
+			Where("hash IN ("+placeholders+")", values...).
+			Find(&found).Error
+		if err != nil {
+			return nil, err
+		}
+		// Other versions of the same content are different chunks.
+		for _, c := range found {
+			if requested[chunkKey(c.Hash, c.Version)] {
+				result = append(result, c)
+			}
+		}
+	}
+	return result, nil
+}
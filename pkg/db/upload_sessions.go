@@ -0,0 +1,111 @@
+package db
+
+import (
+	"time"
+
+	"github.com/kuberlab/lib/pkg/types"
+)
+
+type UploadSessionMgr interface {
+	CreateUploadSession(session *UploadSession) error
+	UpdateUploadSession(session *UploadSession) error
+	GetUploadSession(id string) (*UploadSession, error)
+	ListUploadSessions(filter UploadSession) ([]*UploadSession, error)
+	DeleteUploadSession(id string) error
+	DeleteStaleUploadSessions(before time.Time) (int64, error)
+	SaveUploadPart(sessionID string, part uint, chunks []*UploadChunk) error
+	ListUploadChunks(filter UploadChunk) ([]*UploadChunk, error)
+}
+
+// UploadSession is a file upload which is sent in parts.
+type UploadSession struct {
+	BaseModel
+	ID          string `json:"id" gorm:"primary_key"`
+	Workspace   string `json:"workspace"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Version     string `json:"version"`
+	Path        string `json:"path"`
+	Mode        uint32 `json:"mode"`
+	CDC         bool   `json:"cdc"`
+	Compress    bool   `json:"compress"`
+	HashVersion byte   `json:"hash_version"`
+	// Size is the expected file size, -1 if unknown.
+	Size int64 `json:"size"`
+	// Parts is the expected number of parts, 0 if unknown.
+	Parts uint `json:"parts"`
+}
+
+// UploadChunk is a chunk of the upload session part.
+// Empty parts are marked with a single chunk without hash.
+type UploadChunk struct {
+	SessionID  string `json:"session_id" gorm:"unique_index:idx_session_part_chunk"`
+	Part       uint   `json:"part" gorm:"unique_index:idx_session_part_chunk"`
+	ChunkIndex uint   `json:"chunk_index" gorm:"unique_index:idx_session_part_chunk"`
+	Hash       string `json:"hash"`
+	Size       int64  `json:"size"`
+	Version    byte   `json:"version"`
+}
+
+func (mgr *DatabaseMgr) CreateUploadSession(session *UploadSession) error {
+	session.CreatedAt = types.NewTime(time.Now())
+	session.UpdatedAt = types.NewTime(time.Now())
+	return mgr.db.Create(session).Error
+}
+
+func (mgr *DatabaseMgr) UpdateUploadSession(session *UploadSession) error {
+	session.UpdatedAt = types.NewTime(time.Now())
+	return mgr.db.Save(session).Error
+}
+
+func (mgr *DatabaseMgr) GetUploadSession(id string) (*UploadSession, error) {
+	var session = UploadSession{}
+	err := mgr.db.First(&session, UploadSession{ID: id}).Error
+	return &session, err
+}
+
+func (mgr *DatabaseMgr) ListUploadSessions(filter UploadSession) ([]*UploadSession, error) {
+	var sessions = make([]*UploadSession, 0)
+	err := mgr.db.Find(&sessions, filter).Error
+	return sessions, err
+}
+
+func (mgr *DatabaseMgr) DeleteUploadSession(id string) error {
+	if err := mgr.db.Delete(UploadChunk{}, UploadChunk{SessionID: id}).Error; err != nil {
+		return err
+	}
+	return mgr.db.Delete(UploadSession{}, UploadSession{ID: id}).Error
+}
+
+// DeleteStaleUploadSessions deletes sessions which were not updated since before.
+func (mgr *DatabaseMgr) DeleteStaleUploadSessions(before time.Time) (int64, error) {
+	err := mgr.db.Exec(
+		"DELETE FROM upload_chunks WHERE session_id IN (SELECT id FROM upload_sessions WHERE updated_at < ?)",
+		types.NewTime(before),
+	).Error
+	if err != nil {
+		return 0, err
+	}
+	res := mgr.db.Exec("DELETE FROM upload_sessions WHERE updated_at < ?", types.NewTime(before))
+	return res.RowsAffected, res.Error
+}
+
+// SaveUploadPart replaces chunks of the part.
+func (mgr *DatabaseMgr) SaveUploadPart(sessionID string, part uint, chunks []*UploadChunk) error {
+	err := mgr.db.Delete(UploadChunk{}, "session_id = ? AND part = ?", sessionID, part).Error
+	if err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		if err = mgr.db.Create(c).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mgr *DatabaseMgr) ListUploadChunks(filter UploadChunk) ([]*UploadChunk, error) {
+	var chunks = make([]*UploadChunk, 0)
+	err := mgr.db.Order("part, chunk_index").Find(&chunks, filter).Error
+	return chunks, err
+}
@@ -57,7 +57,8 @@ func NewPlukeFS(dsType, workspace, dataset, version, server, secret, secretWorks
 	}
 
 	fs.client = client
-	innerFS, err := client.GetFSStructure(dsType, workspace, dataset, version)
+	fs.version = fs.resolveVersion(dataset, version)
+	innerFS, err := client.GetFSStructure(dsType, workspace, dataset, fs.version)
 	if err != nil {
 		return nil, err
 	}
@@ -81,6 +82,21 @@ func NewPlukeFS(dsType, workspace, dataset, version, server, secret, secretWorks
 	return fs, nil
 }
 
+// resolveVersion pins the alias to the version it points to at the moment,
+// so the mounted FS doesn't change underneath.
+func (fs *PlukeFS) resolveVersion(dataset, version string) string {
+	if utils.CheckAlias(version) != nil {
+		return version
+	}
+	alias, err := fs.client.GetVersionAlias(fs.dsType, fs.workspace, dataset, version)
+	if err != nil {
+		logrus.Warnf("Unable to resolve %v as alias: %v", version, err)
+		return version
+	}
+	logrus.Infof("Using version %v for %v", alias.Version, version)
+	return alias.Version
+}
+
 func (fs *PlukeFS) String() string {
 	return "plukefs"
 }
@@ -249,7 +265,7 @@ func (fs *PlukeFS) tryChangeDataset(filename string) (file nodefs.File, code fus
 		return nil, fuse.ENOENT
 	}
 	dataset := groups[1]
-	version := groups[2]
+	version := fs.resolveVersion(dataset, groups[2])
 
 	// Change dataset only if current dataset/version differs from target.
 	if dataset != fs.dataset || version != fs.version {
@@ -0,0 +1,276 @@
+package gc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kuberlab/pluk/pkg/db"
+	"github.com/kuberlab/pluk/pkg/io"
+	"github.com/kuberlab/pluk/pkg/types"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+type fsck struct {
+	mgr    db.DataMgr
+	report *types.FsckReport
+}
+
+// Fsck checks that the DB tables agree with each other and with the chunk store.
+// With repair it fixes what can be fixed: deletes orphan file chunks and files
+// with broken chunk indexes, recounts references and version sizes, fixes chunk
+// sizes and deletes corrupt chunk data. Chunks absent in the store are only reported.
+// With verify hashes of all chunks are checked, otherwise only their sizes.
+func Fsck(mgr db.DataMgr, repair, verify bool) *types.FsckReport {
+	// Do not race with GC which deletes the same rows.
+	lock.Lock()
+	defer lock.Unlock()
+
+	f := &fsck{
+		mgr: mgr,
+		report: &types.FsckReport{
+			Repair:          repair,
+			Verify:          verify,
+			StartedAt:       time.Now(),
+			MissingChunks:   make([]types.FsckChunk, 0),
+			WrongSizeChunks: make([]types.FsckChunk, 0),
+			CorruptChunks:   make([]types.FsckChunk, 0),
+			BrokenFiles:     make([]types.FsckFile, 0),
+			Versions:        make([]types.FsckVersion, 0),
+			Errors:          make([]string, 0),
+		},
+	}
+	logrus.Infof("[Fsck] Starting, repair=%v, verify=%v...", repair, verify)
+
+	// Orphans and broken files go first since removing them changes
+	// reference counts and version sizes.
+	f.checkOrphanFileChunks()
+	f.checkBrokenFiles()
+	f.checkRefCounts()
+	f.checkVersions()
+	f.checkChunks()
+
+	now := time.Now()
+	f.report.FinishedAt = &now
+	logrus.Infof(
+		"[Fsck] Done, checked %v chunks, found %v issues, repaired %v.",
+		f.report.Chunks, f.report.Issues, f.report.Repaired,
+	)
+	return f.report
+}
+
+func (f *fsck) errorf(format string, args ...interface{}) {
+	err := fmt.Sprintf(format, args...)
+	logrus.Errorf("[Fsck] %v", err)
+	f.report.Errors = append(f.report.Errors, err)
+}
+
+func (f *fsck) checkOrphanFileChunks() {
+	count, err := f.mgr.CountOrphanFileChunks()
+	if err != nil {
+		f.errorf("Failed to count orphan file chunks: %v", err)
+		return
+	}
+	f.report.OrphanFileChunks = count
+	f.report.Issues += count
+	if count == 0 || !f.report.Repair {
+		return
+	}
+	rows, err := f.mgr.DeleteOrphanFileChunks()
+	if err != nil {
+		f.errorf("Failed to delete orphan file chunks: %v", err)
+		return
+	}
+	logrus.Infof("[Fsck] Deleted %v orphan file chunks.", rows)
+	f.report.Repaired += rows
+}
+
+func (f *fsck) checkBrokenFiles() {
+	files, err := f.mgr.ListBrokenFiles()
+	if err != nil {
+		f.errorf("Failed to list broken files: %v", err)
+		return
+	}
+	f.report.Issues += int64(len(files))
+	for _, file := range files {
+		if len(f.report.BrokenFiles) < types.FsckReportItems {
+			f.report.BrokenFiles = append(f.report.BrokenFiles, types.FsckFile{
+				Type:      file.DatasetType,
+				Workspace: file.Workspace,
+				Name:      file.DatasetName,
+				Version:   file.Version,
+				Path:      file.Path,
+				Size:      file.Size,
+			})
+		}
+		if !f.report.Repair {
+			continue
+		}
+		// Content of such files can not be read correctly, they have to be pushed again.
+		_, err = f.mgr.DeleteFiles(file.DatasetType, file.Workspace, file.DatasetName, file.Version, file.Path, true)
+		if err != nil {
+			f.errorf(
+				"Failed to delete broken file %v %v/%v:%v/%v: %v",
+				file.DatasetType, file.Workspace, file.DatasetName, file.Version, file.Path, err,
+			)
+			continue
+		}
+		logrus.Infof(
+			"[Fsck] Deleted broken file %v %v/%v:%v/%v.",
+			file.DatasetType, file.Workspace, file.DatasetName, file.Version, file.Path,
+		)
+		f.report.Repaired++
+	}
+}
+
+func (f *fsck) checkRefCounts() {
+	count, err := f.mgr.CountWrongChunkRefs()
+	if err != nil {
+		f.errorf("Failed to count chunk references: %v", err)
+		return
+	}
+	f.report.WrongRefCounts = count
+	f.report.Issues += count
+	if count == 0 || !f.report.Repair {
+		return
+	}
+	if err = f.mgr.RecountChunkRefs(); err != nil {
+		f.errorf("Failed to recount chunk references: %v", err)
+		return
+	}
+	f.report.Repaired += count
+}
+
+func (f *fsck) checkVersions() {
+	stats, err := f.mgr.ListVersionStatMismatches()
+	if err != nil {
+		f.errorf("Failed to check version sizes: %v", err)
+		return
+	}
+	f.report.Issues += int64(len(stats))
+	for _, s := range stats {
+		if len(f.report.Versions) < types.FsckReportItems {
+			f.report.Versions = append(f.report.Versions, types.FsckVersion{
+				Type:            s.Type,
+				Workspace:       s.Workspace,
+				Name:            s.Name,
+				Version:         s.Version,
+				Size:            s.Size,
+				FileCount:       s.FileCount,
+				ActualSize:      s.ActualSize,
+				ActualFileCount: s.ActualFileCount,
+			})
+		}
+		if !f.report.Repair {
+			continue
+		}
+		if err = f.mgr.UpdateDatasetVersionSize(s.Type, s.Workspace, s.Name, s.Version); err != nil {
+			f.errorf("Failed to update size of %v %v/%v:%v: %v", s.Type, s.Workspace, s.Name, s.Version, err)
+			continue
+		}
+		f.report.Repaired++
+	}
+}
+
+func (f *fsck) checkChunks() {
+	var lastID uint
+	for {
+		chunks, err := f.mgr.ListChunksAfter(lastID, 500)
+		if err != nil {
+			f.errorf("Failed to list chunks: %v", err)
+			return
+		}
+		if len(chunks) == 0 {
+			return
+		}
+		for _, c := range chunks {
+			f.checkChunk(c)
+		}
+		lastID = chunks[len(chunks)-1].ID
+	}
+}
+
+func (f *fsck) checkChunk(chunk *db.Chunk) {
+	f.report.Chunks++
+	item := types.FsckChunk{Hash: chunk.Hash, Version: chunk.Version, Size: chunk.Size}
+
+	storeSize, err := io.ChunkContentSize(chunk.Hash, chunk.Version)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			f.errorf("Failed to check chunk %v: %v", chunk.Hash, err)
+			return
+		}
+		// Slaves keep only a part of chunks, the rest is read from master.
+		if utils.HasMasters() {
+			return
+		}
+		f.report.Issues++
+		f.report.MissingChunks = appendFsckChunk(f.report.MissingChunks, item)
+		return
+	}
+	item.StoreSize = storeSize
+
+	if storeSize == chunk.Size && !f.report.Verify {
+		return
+	}
+	// Size mismatch is either a wrong size in DB or a damaged chunk, the hash tells which.
+	corrupt, err := f.isCorrupt(chunk)
+	if err != nil {
+		f.errorf("Failed to read chunk %v: %v", chunk.Hash, err)
+		return
+	}
+
+	switch {
+	case corrupt:
+		f.report.Issues++
+		f.report.CorruptChunks = appendFsckChunk(f.report.CorruptChunks, item)
+		if !f.report.Repair {
+			return
+		}
+		// Slaves download the chunk from master again.
+		if err = io.Store().Delete(chunk.Hash, chunk.Version); err != nil {
+			f.errorf("Failed to delete corrupt chunk %v: %v", chunk.Hash, err)
+			return
+		}
+		logrus.Infof("[Fsck] Deleted corrupt chunk %v.", chunk.Hash)
+		f.report.Repaired++
+	case storeSize != chunk.Size:
+		f.report.Issues++
+		f.report.WrongSizeChunks = appendFsckChunk(f.report.WrongSizeChunks, item)
+		if !f.report.Repair {
+			return
+		}
+		chunk.Size = storeSize
+		if _, err = f.mgr.UpdateChunk(chunk); err != nil {
+			f.errorf("Failed to update size of chunk %v: %v", chunk.Hash, err)
+			return
+		}
+		f.report.Repaired++
+	}
+}
+
+func (f *fsck) isCorrupt(chunk *db.Chunk) (bool, error) {
+	reader, err := io.GetChunkByHash(chunk.Hash, chunk.Version)
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		// Damaged compressed data can not be read at all.
+		if io.IsCompressed(chunk.Version) {
+			return true, nil
+		}
+		return false, err
+	}
+	return io.VerifyChunk(chunk.Hash, chunk.Version, data) != nil, nil
+}
+
+func appendFsckChunk(chunks []types.FsckChunk, chunk types.FsckChunk) []types.FsckChunk {
+	if len(chunks) >= types.FsckReportItems {
+		return chunks
+	}
+	return append(chunks, chunk)
+}
@@ -2,9 +2,6 @@ package gc
 
 import (
 	"fmt"
-	"log"
-	"os"
-	"path/filepath"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -21,6 +18,10 @@ import (
 const (
 	gcInterval = time.Hour
 	gcChunks   = time.Hour * 24
+	// Upload sessions without new parts for this time are deleted.
+	uploadSessionTTL = time.Hour * 24 * 7
+	// Push sessions without new chunks for this time are deleted.
+	pushSessionTTL = time.Hour * 24
 )
 
 var (
@@ -98,8 +99,17 @@ func Start() {
 }
 
 func GoGC() {
+	runGC(false)
+}
+
+// DryRunGC reports what GoGC would delete. All changes are made
+// in a single transaction which is rolled back in the end.
+func DryRunGC() *types.GCReport {
+	return runGC(true)
+}
+
+func runGC(dryRun bool) *types.GCReport {
 	go datasets.RunDeleteLoop()
-	go datasets.RunChunkDBDeleteLoop()
 
 	utils.AcqureSem(1)
 	lock.Lock()
@@ -109,96 +119,151 @@ func GoGC() {
 		lock.Unlock()
 		setInactive()
 	}()
-	logrus.Info("[GC] Starting garbage collector...")
-	mgr := db.DbMgr
+	r := newRun(KindGC, dryRun)
+	logrus.Infof("%v Starting garbage collector...", r.prefix)
+
+	var mgr db.DataMgr = db.DbMgr
+	if dryRun {
+		dryTx := db.DbMgr.Begin()
+		defer dryTx.Rollback()
+		mgr = dryTx
+	}
+	// Dry run works in the single transaction.
+	begin := func() db.DataMgr {
+		if dryRun {
+			return mgr
+		}
+		return mgr.Begin()
+	}
+	var err error
+	endTx := func(tx db.DataMgr, err error) {
+		if dryRun {
+			return
+		}
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}
 
+	r.setPhase(PhaseDatasets)
 	vDatasets, err := mgr.ListDatasets(db.Dataset{Deleted: true})
 	if err != nil {
-		logrus.Error(err)
-		return
+		r.errorf("%v", err)
+		return r.finish()
 	}
 
 	// TODO: sqlite allows only 1 transaction at a time.
 	// TODO: So, if we create transaction here, all another requests
 	// TODO: (like list dataset or versions) will hang until transaction is completed
 	// Done: Using WAL mode (Write ahead log) for SQLite.
-	tx := mgr.Begin()
-	endTx := func() {
-		if err != nil {
-			tx.Rollback()
-		} else {
-			tx.Commit()
-		}
-	}
+	tx := begin()
 
 	// First: check if repo exists.
 	for _, ds := range vDatasets {
-		if err = deleteDatasetVersion(tx, ds, ""); err != nil {
-			logrus.Error(err)
+		if err = deleteDatasetVersion(tx, r, ds, ""); err != nil {
+			r.errorf("Failed to delete %v %v/%v: %v", ds.Type, ds.Workspace, ds.Name, err)
 			//return
 		}
 	}
 
 	// Second: Iterate over versions and see if the corresponding version deleted.
-	endTx()
-	tx = mgr.Begin()
+	endTx(tx, err)
+	r.setPhase(PhaseVersions)
+	tx = begin()
 
 	deletedVersions, err := tx.ListDatasetVersions(db.DatasetVersion{Deleted: true})
 	if err != nil {
-		logrus.Error(err)
+		r.errorf("%v", err)
 	}
 	for _, dsv := range deletedVersions {
 		err = deleteDatasetVersion(
-			tx,
+			tx, r,
 			&db.Dataset{Workspace: dsv.Workspace, Name: dsv.Name, Type: dsv.Type}, dsv.Version,
 		)
 		if err != nil {
-			logrus.Error(err)
+			r.errorf("Failed to delete %v %v/%v:%v: %v", dsv.Type, dsv.Workspace, dsv.Name, dsv.Version, err)
 		}
 	}
-	endTx()
+	endTx(tx, err)
+
+	r.setPhase(PhaseSessions)
+	if rows, err := mgr.DeleteStaleUploadSessions(time.Now().Add(-uploadSessionTTL)); err != nil {
+		r.errorf("%v", err)
+	} else if rows != 0 {
+		r.addSessions(rows)
+		logrus.Infof("%v Deleted %v stale upload sessions.", r.prefix, rows)
+	}
+	if rows, err := mgr.DeleteStalePushSessions(time.Now().Add(-pushSessionTTL)); err != nil {
+		r.errorf("%v", err)
+	} else if rows != 0 {
+		r.addSessions(rows)
+		logrus.Infof("%v Deleted %v stale push sessions.", r.prefix, rows)
+	}
+
 	// Third: See if there deleted dataset on master; delete those which don't exist on master
 	// but exist on slave.
 	if utils.HasMasters() {
+		r.setPhase(PhaseMasters)
 		// Sync with master and delete obsolete datasets.
-		gcFromMasters(mgr)
+		gcFromMasters(begin(), r, endTx)
+	}
+
+	// Deleted files only released their chunks, now delete chunks nobody refers to.
+	r.setPhase(PhaseChunks)
+	if dryRun {
+		err = reportUnreferencedChunks(mgr, r)
+	} else {
+		_, err = datasets.SweepChunks(mgr, func(chunk db.Chunk) {
+			r.addChunks(1, chunk.Size, chunk.Hash)
+		})
+	}
+	if err != nil {
+		r.errorf("Failed to sweep chunks: %v", err)
 	}
-	logrus.Infof("[GC] Done garbage collecting.")
+	report := r.finish()
+	if report.Chunks != 0 {
+		logrus.Infof("%v Deleted %v chunks.", r.prefix, report.Chunks)
+	}
+	logrus.Infof("%v Done garbage collecting.", r.prefix)
+	return report
 }
 
-func deleteDatasetVersion(mgr db.DataMgr, dataset *db.Dataset, version string) error {
-	// Delete all files within this repo
-	rawFiles, err := mgr.GetRawFiles(dataset.Type, dataset.Workspace, dataset.Name, version, "", false)
+// reportUnreferencedChunks adds chunks which would be swept to the report.
+// Chunks released since the start of the run are swept only after
+// the grace period, but they are reported as well.
+func reportUnreferencedChunks(mgr db.DataMgr, r *run) error {
+	count, size, err := mgr.ReleasedChunksStat(r.report.StartedAt)
+	if err != nil {
+		return err
+	}
+	chunks, err := mgr.ListReleasedChunks(r.report.StartedAt, types.GCReportHashes)
 	if err != nil {
 		return err
 	}
+	hashes := make([]string, len(chunks))
+	for i, c := range chunks {
+		hashes[i] = c.Hash
+	}
+	r.addChunks(count, size, hashes...)
+	return nil
+}
 
+func deleteDatasetVersion(mgr db.DataMgr, r *run, dataset *db.Dataset, version string) error {
+	// Delete all files within this repo, their chunks are swept afterwards.
 	rows, err := mgr.DeleteRelatedFiles(dataset.Type, dataset.Workspace, dataset.Name, version)
 	if err != nil {
 		return err
 	}
-	//var deleted = 0
-	logrus.Infof("[GC] Deleted %v virtual files.", rows)
-
-	for _, raw := range rawFiles {
-		//chunk, err := mgr.GetChunkByID(raw.ChunkID)
-		//if err != nil {
-		//	if err == gorm.ErrRecordNotFound {
-		//		continue
-		//	}
-		//	return err
-		//}
-		chunk := &db.Chunk{Hash: raw.Hash, Size: raw.ChunkSize, ID: raw.ChunkID}
-		datasets.CheckAndDeleteChunk(mgr, chunk)
-		//if datasets.CheckAndDeleteChunk(mgr, chunk) {
-		//	deleted++
-		//}
-		//if deleted%500 == 0 && deleted != 0 {
-		//	logrus.Infof("[GC] Deleted %v chunks.", deleted)
-		//}
-	}
-	deleted := datasets.TriggerDeleteChunks(mgr)
-	logrus.Infof("[GC] Deleted %v chunks.", deleted)
+	logrus.Infof("%v Deleted %v virtual files.", r.prefix, rows)
+	r.addItem(types.GCItem{
+		Type:      dataset.Type,
+		Workspace: dataset.Workspace,
+		Name:      dataset.Name,
+		Version:   version,
+		Files:     rows,
+	})
 
 	if version != "" {
 		dsv, err := mgr.GetDatasetVersion(dataset.Type, dataset.Workspace, dataset.Name, version)
@@ -225,27 +290,18 @@ func deleteDataset(mgr db.DataMgr, d *db.Dataset) {
 	_ = mgr.DeleteDataset(d.ID)
 }
 
-func gcFromMasters(mgr db.DataMgr) {
-	var needCloseTx = true
+func gcFromMasters(tx db.DataMgr, r *run, endTx func(tx db.DataMgr, err error)) {
 	var err error
-	tx := mgr.Begin()
-	endTx := func() {
-		if !needCloseTx {
-			return
-		}
+	defer func() {
 		if err != nil {
-			tx.Rollback()
-		} else {
-			tx.Commit()
+			r.errorf("%v", err)
 		}
-		needCloseTx = false
-	}
-	defer endTx()
+		endTx(tx, err)
+	}()
 
 	dsManager := datasets.NewManager(tx, nil)
 	vDatasets, err := tx.ListDatasets(db.Dataset{})
 	if err != nil {
-		logrus.Error(err)
 		return
 	}
 
@@ -265,9 +321,9 @@ func gcFromMasters(mgr db.DataMgr) {
 	candidates := make([]types.Dataset, 0)
 	for wsType, slaveDatasets := range localDatasets {
 		ws, eType := wsAndType(wsType)
-		remoteDatasets, err := io.MasterClient.ListEntities(eType, ws)
-		if err != nil {
-			logrus.Errorf("[GC] list from master: %v", err)
+		remoteDatasets, errList := io.MasterClient.ListEntities(eType, ws)
+		if errList != nil {
+			err = fmt.Errorf("list from master: %v", errList)
 			return
 		}
 		masterDatasetMap := make(map[string]bool)
@@ -284,10 +340,14 @@ func gcFromMasters(mgr db.DataMgr) {
 		}
 	}
 
+	// Datasets are marked as deleted and removed on the next run.
 	for _, candidate := range candidates {
-		logrus.Infof("[GC] Delete %v %v/%v from slave", candidate.DType, candidate.Workspace, candidate.Name)
+		logrus.Infof("%v Delete %v %v/%v from slave", r.prefix, candidate.DType, candidate.Workspace, candidate.Name)
+		r.addItem(types.GCItem{Type: candidate.DType, Workspace: candidate.Workspace, Name: candidate.Name})
+		if r.dryRun() {
+			continue
+		}
 		if err = dsManager.DeleteDataset(candidate.DType, candidate.Workspace, candidate.Name, nil, false); err != nil {
-			logrus.Error(err)
 			return
 		}
 	}
@@ -308,11 +368,23 @@ type ClearChunk struct {
 }
 
 func ClearChunks(mgr db.DataMgr) {
+	clearChunks(mgr, false)
+}
+
+// DryRunClearChunks reports chunks which ClearChunks would delete.
+// It returns nil if clearing is already running.
+func DryRunClearChunks(mgr db.DataMgr) *types.GCReport {
+	return clearChunks(mgr, true)
+}
+
+func clearChunks(mgr db.DataMgr, dryRun bool) *types.GCReport {
 	if isClearChunkActive() {
-		return
+		return nil
 	}
 	setClearChunkActive()
 	defer setClearChunkInactive()
+	r := newRun(KindClearChunks, dryRun)
+	r.setPhase(PhaseStore)
 
 	var err error
 
@@ -324,11 +396,38 @@ func ClearChunks(mgr db.DataMgr) {
 	//		tx.Commit()
 	//	}
 	//}()
+	// Chunks in the store by their paths, the same hash may be stored in several versions.
 	hashMap := make(map[string]*db.RawFile)
+	// Sizes of chunks in the store, which may be compressed.
+	storeSizes := make(map[string]int64)
 	limit := 500
-	deleted := 0
+
+	// Chunks of unfinished upload sessions are not in DB yet.
+	sessionChunks, err := mgr.ListUploadChunks(db.UploadChunk{})
+	if err != nil {
+		r.errorf("Failed get upload sessions from DB: %v", err)
+		return r.finish()
+	}
+	keep := make(map[string]bool)
+	for _, c := range sessionChunks {
+		keep[c.Hash] = true
+	}
+	// Chunks of open pushes get DB rows only when the file structure is saved.
+	pushChunks, err := mgr.ListPushChunks(db.PushChunk{})
+	if err != nil {
+		r.errorf("Failed get push sessions from DB: %v", err)
+		return r.finish()
+	}
+	for _, c := range pushChunks {
+		keep[c.Hash] = true
+	}
+	// Chunks which are written recently may belong to pushes without session.
+	graceTime := time.Now().Add(-utils.GCGracePeriod())
 
 	checkAndDelete := func() error {
+		if len(hashMap) == 0 {
+			return nil
+		}
 		raws := make([]*db.RawFile, 0)
 		for _, v := range hashMap {
 			raws = append(raws, v)
@@ -342,28 +441,42 @@ func ClearChunks(mgr db.DataMgr) {
 			if ch == nil {
 				continue
 			}
-			if fromHash, ok := hashMap[ch.Hash]; ok {
+			path := utils.GetHashedFilename(ch.Hash, ch.Version)
+			if fromHash, ok := hashMap[path]; ok {
 				if fromHash.ChunkSize == ch.Size {
 					// Don't delete
-					delete(hashMap, ch.Hash)
+					delete(hashMap, path)
 				}
 			}
 		}
 
 		for _, v := range hashMap {
+			if keep[v.Hash] {
+				continue
+			}
+			r.addChunks(1, storeSizes[v.Path], v.Hash)
+			if dryRun {
+				continue
+			}
 			logrus.Debugf("Delete unused/wrong chunk at %v", v.Path)
 			datasets.SendDeletePath(v.Path)
-			deleted++
 		}
 		return nil
 	}
 
-	err = filepath.Walk(utils.DataDir(), func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
+	err = io.Store().List(func(hash string, version byte, size int64, modTime time.Time) error {
+		if modTime.After(graceTime) {
 			return nil
 		}
-		hash, _ := utils.GetHashFromPath(path)
-		hashMap[hash] = &db.RawFile{ChunkSize: info.Size(), Hash: hash, Path: path}
+		path := utils.GetHashedFilename(hash, version)
+		storeSizes[path] = size
+		if io.IsCompressed(version) {
+			// DB keeps the size of uncompressed content.
+			if contentSize, err := io.ChunkContentSize(hash, version); err == nil {
+				size = contentSize
+			}
+		}
+		hashMap[path] = &db.RawFile{ChunkSize: size, Hash: hash, Path: path, Version: version}
 
 		if len(hashMap) < limit {
 			return nil
@@ -371,22 +484,29 @@ func ClearChunks(mgr db.DataMgr) {
 		// >= limit
 
 		if err := checkAndDelete(); err != nil {
-			logrus.Errorf("[ClearChunks] Failed get from DB: %v", err)
-			return err
+			return fmt.Errorf("Failed get from DB: %v", err)
 		}
 		hashMap = nil
 		hashMap = make(map[string]*db.RawFile)
+		storeSizes = make(map[string]int64)
 
 		return nil
 	})
 	if err != nil {
-		log.Println(err)
-		return
+		r.errorf("%v", err)
+		return r.finish()
 	}
 	if err := checkAndDelete(); err != nil {
-		logrus.Errorf("[ClearChunks] Failed get from DB: %v", err)
-		return
+		r.errorf("Failed get from DB: %v", err)
+		return r.finish()
+	}
+	if compactor, ok := io.Store().(io.Compactor); ok && !dryRun {
+		if err := compactor.Compact(); err != nil {
+			r.errorf("Failed to compact chunk store: %v", err)
+		}
 	}
-	logrus.Infof("[ClearChunks] Deleted %v chunks.", deleted)
-	logrus.Info("[ClearChunks] Done.")
+	report := r.finish()
+	logrus.Infof("%v Deleted %v chunks.", r.prefix, report.Chunks)
+	logrus.Infof("%v Done.", r.prefix)
+	return report
 }
@@ -0,0 +1,160 @@
+package gc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kuberlab/pluk/pkg/types"
+)
+
+// Kinds of runs.
+const (
+	KindGC          = "gc"
+	KindClearChunks = "clear-chunks"
+)
+
+// Phases of runs.
+const (
+	PhaseDatasets = "datasets"
+	PhaseVersions = "versions"
+	PhaseSessions = "sessions"
+	PhaseMasters  = "masters"
+	PhaseChunks   = "chunks"
+	PhaseStore    = "store"
+	PhaseDone     = "done"
+)
+
+var (
+	statusLock            sync.RWMutex
+	lastGC                *types.GCReport
+	lastClearChunks       *types.GCReport
+	lastGCDryRun          *types.GCReport
+	lastClearChunksDryRun *types.GCReport
+)
+
+// run collects the report of the current run, it is safe to read
+// the report with Status while the run goes on.
+type run struct {
+	report *types.GCReport
+	prefix string
+}
+
+func newRun(kind string, dryRun bool) *run {
+	r := &run{
+		report: &types.GCReport{
+			Kind:      kind,
+			DryRun:    dryRun,
+			Running:   true,
+			StartedAt: time.Now(),
+			Datasets:  make([]types.GCItem, 0),
+			Versions:  make([]types.GCItem, 0),
+			Hashes:    make([]string, 0),
+			Errors:    make([]string, 0),
+		},
+		prefix: "[GC]",
+	}
+	if kind == KindClearChunks {
+		r.prefix = "[ClearChunks]"
+	}
+	if dryRun {
+		r.prefix += "[dry-run]"
+	}
+
+	statusLock.Lock()
+	switch {
+	case kind == KindGC && dryRun:
+		lastGCDryRun = r.report
+	case kind == KindGC:
+		lastGC = r.report
+	case dryRun:
+		lastClearChunksDryRun = r.report
+	default:
+		lastClearChunks = r.report
+	}
+	statusLock.Unlock()
+	return r
+}
+
+func (r *run) dryRun() bool {
+	return r.report.DryRun
+}
+
+func (r *run) setPhase(phase string) {
+	statusLock.Lock()
+	r.report.Phase = phase
+	statusLock.Unlock()
+}
+
+func (r *run) errorf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	logrus.Errorf("%v %v", r.prefix, msg)
+	statusLock.Lock()
+	r.report.Errors = append(r.report.Errors, msg)
+	statusLock.Unlock()
+}
+
+func (r *run) addItem(item types.GCItem) {
+	statusLock.Lock()
+	if item.Version == "" {
+		r.report.Datasets = append(r.report.Datasets, item)
+	} else {
+		r.report.Versions = append(r.report.Versions, item)
+	}
+	r.report.Files += item.Files
+	statusLock.Unlock()
+}
+
+func (r *run) addSessions(count int64) {
+	statusLock.Lock()
+	r.report.Sessions += count
+	statusLock.Unlock()
+}
+
+func (r *run) addChunks(count, size int64, hashes ...string) {
+	statusLock.Lock()
+	r.report.Chunks += count
+	r.report.Size += size
+	for _, h := range hashes {
+		if len(r.report.Hashes) >= types.GCReportHashes {
+			break
+		}
+		r.report.Hashes = append(r.report.Hashes, h)
+	}
+	statusLock.Unlock()
+}
+
+func (r *run) finish() *types.GCReport {
+	statusLock.Lock()
+	defer statusLock.Unlock()
+	now := time.Now()
+	r.report.Phase = PhaseDone
+	r.report.Running = false
+	r.report.FinishedAt = &now
+	return copyReport(r.report)
+}
+
+func copyReport(report *types.GCReport) *types.GCReport {
+	if report == nil {
+		return nil
+	}
+	c := *report
+	c.Datasets = append([]types.GCItem{}, report.Datasets...)
+	c.Versions = append([]types.GCItem{}, report.Versions...)
+	c.Hashes = append([]string{}, report.Hashes...)
+	c.Errors = append([]string{}, report.Errors...)
+	return &c
+}
+
+// Status returns reports of the current or the last runs.
+func Status() *types.GCStatus {
+	statusLock.RLock()
+	defer statusLock.RUnlock()
+	return &types.GCStatus{
+		GC:                copyReport(lastGC),
+		ClearChunks:       copyReport(lastClearChunks),
+		GCDryRun:          copyReport(lastGCDryRun),
+		ClearChunksDryRun: copyReport(lastClearChunksDryRun),
+	}
+}
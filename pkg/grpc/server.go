@@ -3,14 +3,17 @@ package grpc
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/kuberlab/pluk/pkg/api"
 	plukio "github.com/kuberlab/pluk/pkg/io"
+	"github.com/kuberlab/pluk/pkg/types"
 	"github.com/kuberlab/pluk/pkg/utils"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/keepalive"
@@ -30,9 +33,35 @@ func (s *Server) GetChunk(ctx context.Context, in *ChunkRequest) (*ChunkResponse
 		logrus.Error(err)
 		return nil, err
 	}
+	defer reader.Close()
+
+	// The whole chunk is sent in a single message, so its size is limited.
+	// Local chunks are read at once into a buffer of the exact size.
+	if file, ok := reader.(*os.File); ok {
+		if stat, err := file.Stat(); err == nil {
+			if stat.Size() > types.MaxChunkSize {
+				err = fmt.Errorf("Chunk %v is too large: %v", in.Path, stat.Size())
+				logrus.Error(err)
+				return nil, err
+			}
+			data := make([]byte, stat.Size())
+			if _, err = io.ReadFull(file, data); err != nil {
+				logrus.Error(err)
+				return nil, err
+			}
+			return &ChunkResponse{Data: data}, nil
+		}
+	}
 	bt := bytes.NewBuffer(make([]byte, 0, 16384))
-	io.Copy(bt, reader)
-	reader.Close()
+	if _, err = io.Copy(bt, io.LimitReader(reader, types.MaxChunkSize+1)); err != nil {
+		logrus.Error(err)
+		return nil, err
+	}
+	if bt.Len() > types.MaxChunkSize {
+		err = fmt.Errorf("Chunk %v is too large", in.Path)
+		logrus.Error(err)
+		return nil, err
+	}
 	return &ChunkResponse{Data: bt.Bytes()}, nil
 }
 
@@ -0,0 +1,196 @@
+package io
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+const (
+	cacheIndexName     = ".chunk-cache.json"
+	cacheIndexInterval = time.Minute
+)
+
+// ChunkCache tracks chunks which are only cached from the master and evicts
+// the least recently used of them once their total size exceeds the limit.
+// Chunks pushed to this instance are never tracked, so never evicted.
+type ChunkCache struct {
+	MaxSize int64
+
+	lock  sync.Mutex
+	size  int64
+	ll    *list.List
+	items map[string]*list.Element
+	dirty bool
+}
+
+type cacheEntry struct {
+	Hash    string `json:"hash"`
+	Version byte   `json:"version"`
+	Size    int64  `json:"size"`
+}
+
+var (
+	chunkCache     *ChunkCache
+	chunkCacheOnce sync.Once
+)
+
+// Cache returns the slave chunk cache or nil if it is not enabled.
+func Cache() *ChunkCache {
+	chunkCacheOnce.Do(func() {
+		if !utils.HasMasters() || !utils.SaveChunks() || utils.ChunkCacheSize() <= 0 {
+			return
+		}
+		chunkCache = NewChunkCache(utils.ChunkCacheSize())
+		if err := chunkCache.Load(cacheIndexPath()); err != nil && !os.IsNotExist(err) {
+			logrus.Errorf("Failed to load chunk cache index: %v", err)
+		}
+		go chunkCache.saveLoop(cacheIndexPath())
+	})
+	return chunkCache
+}
+
+func NewChunkCache(maxSize int64) *ChunkCache {
+	return &ChunkCache{
+		MaxSize: maxSize,
+		ll:      list.New(),
+		items:   make(map[string]*list.Element),
+	}
+}
+
+func cacheIndexPath() string {
+	return filepath.Join(utils.DataDir(), cacheIndexName)
+}
+
+func cacheKey(hash string, version byte) string {
+	return fmt.Sprintf("%v/%v", version, hash)
+}
+
+// Add registers a chunk downloaded from the master and evicts
+// the least recently used chunks if the cache is over the limit.
+func (c *ChunkCache) Add(hash string, version byte, size int64) {
+	c.lock.Lock()
+	key := cacheKey(hash, version)
+	if el, ok := c.items[key]; ok {
+		c.size -= el.Value.(*cacheEntry).Size
+		c.ll.Remove(el)
+	}
+	c.items[key] = c.ll.PushFront(&cacheEntry{Hash: hash, Version: version, Size: size})
+	c.size += size
+	c.dirty = true
+
+	evicted := make([]*cacheEntry, 0)
+	for c.size > c.MaxSize && c.ll.Len() > 1 {
+		el := c.ll.Back()
+		entry := el.Value.(*cacheEntry)
+		c.ll.Remove(el)
+		delete(c.items, cacheKey(entry.Hash, entry.Version))
+		c.size -= entry.Size
+		evicted = append(evicted, entry)
+	}
+	c.lock.Unlock()
+
+	for _, entry := range evicted {
+		logrus.Debugf("[ChunkCache] Evict chunk %v", entry.Hash)
+		if err := Store().Delete(entry.Hash, entry.Version); err != nil && !os.IsNotExist(err) {
+			logrus.Errorf("[ChunkCache] Failed to evict chunk %v: %v", entry.Hash, err)
+		}
+	}
+}
+
+// Touch marks the chunk as recently used.
+func (c *ChunkCache) Touch(hash string, version byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if el, ok := c.items[cacheKey(hash, version)]; ok {
+		c.ll.MoveToFront(el)
+		c.dirty = true
+	}
+}
+
+// Remove stops tracking the chunk, e.g. when it was pushed to this instance.
+func (c *ChunkCache) Remove(hash string, version byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := cacheKey(hash, version)
+	if el, ok := c.items[key]; ok {
+		c.size -= el.Value.(*cacheEntry).Size
+		c.ll.Remove(el)
+		delete(c.items, key)
+		c.dirty = true
+	}
+}
+
+func (c *ChunkCache) Size() int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.size
+}
+
+// Load reads the cache index saved by Save.
+func (c *ChunkCache) Load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	entries := make([]*cacheEntry, 0)
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, entry := range entries {
+		key := cacheKey(entry.Hash, entry.Version)
+		if _, ok := c.items[key]; ok {
+			continue
+		}
+		c.items[key] = c.ll.PushBack(entry)
+		c.size += entry.Size
+	}
+	return nil
+}
+
+// Save writes the cache index from the most to the least recently used chunk.
+func (c *ChunkCache) Save(path string) error {
+	c.lock.Lock()
+	entries := make([]*cacheEntry, 0, c.ll.Len())
+	for el := c.ll.Front(); el != nil; el = el.Next() {
+		entries = append(entries, el.Value.(*cacheEntry))
+	}
+	c.dirty = false
+	c.lock.Unlock()
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (c *ChunkCache) saveLoop(path string) {
+	ticker := time.NewTicker(cacheIndexInterval)
+	for range ticker.C {
+		c.lock.Lock()
+		dirty := c.dirty
+		c.lock.Unlock()
+		if !dirty {
+			continue
+		}
+		if err := c.Save(path); err != nil {
+			logrus.Errorf("Failed to save chunk cache index: %v", err)
+		}
+	}
+}
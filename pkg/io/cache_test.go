@@ -0,0 +1,78 @@
+package io
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kuberlab/pluk/pkg/types"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+func TestChunkCacheEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pluk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldDir := utils.DataDirValue
+	utils.DataDirValue = dir
+	defer func() { utils.DataDirValue = oldDir }()
+	SetStore(NewLocalStore())
+	defer SetStore(nil)
+
+	put := func(data string) string {
+		hash := CalcHash([]byte(data), types.ChunkVersion)
+		if _, err := Store().Put(hash, 2, bytes.NewBufferString(data)); err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	exists := func(hash string) bool {
+		_, ok := CheckLocalChunk(hash, 2)
+		return ok
+	}
+
+	cache := NewChunkCache(20)
+	// Pushed chunk, not tracked by cache.
+	local := put("local chunk data")
+
+	hash1 := put("0123456789")
+	cache.Add(hash1, 2, 10)
+	hash2 := put("abcdefghij")
+	cache.Add(hash2, 2, 10)
+	cache.Touch(hash1, 2)
+
+	hash3 := put("ABCDEFGHIJ")
+	cache.Add(hash3, 2, 10)
+
+	utils.Assert(int64(20), cache.Size(), t)
+	utils.Assert(true, exists(hash1), t)
+	utils.Assert(false, exists(hash2), t)
+	utils.Assert(true, exists(hash3), t)
+	utils.Assert(true, exists(local), t)
+
+	// Index survives restart.
+	index := filepath.Join(dir, cacheIndexName)
+	if err = cache.Save(index); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewChunkCache(20)
+	if err = loaded.Load(index); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(int64(20), loaded.Size(), t)
+
+	loaded.Remove(hash3, 2)
+	hash4 := put("klmnopqrst")
+	loaded.Add(hash4, 2, 10)
+	hash5 := put("uvwxyz0123")
+	loaded.Add(hash5, 2, 10)
+
+	utils.Assert(false, exists(hash1), t)
+	utils.Assert(true, exists(hash3), t)
+	utils.Assert(true, exists(hash4), t)
+	utils.Assert(true, exists(hash5), t)
+}
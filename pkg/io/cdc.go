@@ -0,0 +1,81 @@
+package io
+
+import (
+	"math"
+)
+
+// Content-defined chunking based on FastCDC
+// (https://www.usenix.org/conference/atc16/technical-sessions/presentation/xia).
+// Chunk boundaries depend only on the data around them, so an insertion
+// at the start of a file changes only the chunks around the insertion.
+
+// gearTable must be the same on all clients and servers,
+// therefore it is generated from the fixed seed.
+var gearTable = func() (table [256]uint64) {
+	var seed uint64 = 0x706c756b
+	for i := range table {
+		// splitmix64
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return
+}()
+
+type cdcParams struct {
+	min int
+	avg int
+	max int
+	// maskS is used before the average size is reached and makes cut
+	// less likely, maskL is used after it (normalized chunking).
+	maskS uint64
+	maskL uint64
+}
+
+func newCDCParams(avgSize int) cdcParams {
+	if avgSize < 64 {
+		avgSize = 64
+	}
+	bits := uint(math.Floor(math.Log2(float64(avgSize)) + 0.5))
+	return cdcParams{
+		min:   avgSize / 4,
+		avg:   avgSize,
+		max:   avgSize * 4,
+		maskS: ^uint64(0) << (64 - bits - 2),
+		maskL: ^uint64(0) << (64 - bits + 2),
+	}
+}
+
+// cutPoint returns the length of the first chunk in data.
+// data must contain at least max bytes unless it is the end of the stream.
+func (p cdcParams) cutPoint(data []byte) int {
+	n := len(data)
+	if n <= p.min {
+		return n
+	}
+	if n > p.max {
+		n = p.max
+	}
+	normal := p.avg
+	if normal > n {
+		normal = n
+	}
+
+	var fp uint64
+	i := p.min
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&p.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&p.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
@@ -0,0 +1,59 @@
+package io
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/kuberlab/pluk/pkg/types"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+func cdcHashes(t *testing.T, data []byte) []string {
+	r := NewCDCChunkedReader(1024000, bytes.NewReader(data))
+	utils.Assert(byte(types.ChunkVersionCDC), r.Version, t)
+
+	hashes := make([]string, 0)
+	var total int
+	for {
+		chunk, hash, err := r.NextChunk()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		utils.Assert(CalcHash(chunk, types.ChunkVersion), hash, t)
+		total += len(chunk)
+		hashes = append(hashes, hash)
+	}
+	utils.Assert(len(data), total, t)
+	return hashes
+}
+
+func TestCDCInsertShiftsOnlyNearbyChunks(t *testing.T) {
+	data := make([]byte, 20*1024000)
+	rand.New(rand.NewSource(42)).Read(data)
+
+	modified := append([]byte{}, data[:100]...)
+	modified = append(modified, 'x')
+	modified = append(modified, data[100:]...)
+
+	before := cdcHashes(t, data)
+	after := cdcHashes(t, modified)
+
+	known := make(map[string]bool)
+	for _, h := range before {
+		known[h] = true
+	}
+	shared := 0
+	for _, h := range after {
+		if known[h] {
+			shared++
+		}
+	}
+	if shared < len(after)-2 {
+		t.Fatalf("Expected all chunks except the first ones to be shared, got %v of %v", shared, len(after))
+	}
+}
@@ -18,6 +18,7 @@ import (
 
 type PlukClient interface {
 	CheckChunk(hash string, version byte) (*types.ChunkCheck, error)
+	CheckChunks(hashes []types.Hash) ([]types.Hash, error)
 	CheckEntityPermission(entityType, workspace, name string, write bool) (*types.Dataset, error)
 	CheckEntityExists(entityType, workspace, name string) (*types.Dataset, error)
 	CheckWorkspace(workspace string) (*types.Workspace, error)
@@ -28,7 +29,9 @@ type PlukClient interface {
 	DeleteVersion(entityType, workspace, name, version string) error
 	DownloadChunk(hash string, version byte) (io.ReadCloser, error)
 	DownloadEntity(entityType, workspace, name, version string, w io.Writer) error
-	EntityTarSize(entityType, workspace, name, version string) (int64, error)
+	DownloadEntityArchive(entityType, workspace, name, version string, opts types.ArchiveOpts, w io.Writer) error
+	EntityTarSize(entityType, workspace, name, version string, opts types.ArchiveOpts) (int64, error)
+	CherryPick(entityType, workspace, name, version string, pick types.CherryPick) (*types.FileOperationResult, error)
 	GetFSStructure(entityType, workspace, name, version string) (*ChunkedFileFS, error)
 	ListEntities(entityType, workspace string) (*types.DataSetList, error)
 	GetEntity(entityType, workspace, name string) (*types.Dataset, error)
@@ -36,11 +39,23 @@ type PlukClient interface {
 	CreateEntity(entityType, workspace, name string) (*types.Dataset, error)
 	CreateVersion(entityType, workspace, name, version string) (*types.Version, error)
 	ListVersions(entityType, workspace, datasetName string) (*types.VersionList, error)
+	ListVersionAliases(entityType, workspace, name string) (*types.VersionAliasList, error)
+	GetVersionAlias(entityType, workspace, name, alias string) (*types.VersionAlias, error)
+	SetVersionAlias(entityType, workspace, name, alias, version string) (*types.VersionAlias, error)
+	DeleteVersionAlias(entityType, workspace, name, alias string) error
 
 	UploadFile(entityType, workspace, entityName, version, fileName string, body io.ReadCloser) (*types.HashedFile, error)
 	DownloadFile(entityType, workspace, entityName, version, fileName string) (io.ReadCloser, error)
 	DeleteFile(entityType, workspace, entityName, version, fileName string) error
 
+	CreateUploadSession(entityType, workspace, entityName, version, fileName string, size int64) (*types.UploadSession, error)
+	GetUploadSession(entityType, workspace, entityName, version, id string) (*types.UploadSession, error)
+	UploadPart(entityType, workspace, entityName, version, id string, part uint, body io.Reader) (*types.UploadPart, error)
+	CompleteUploadSession(entityType, workspace, entityName, version, id string) (*types.HashedFile, error)
+	AbortUploadSession(entityType, workspace, entityName, version, id string) error
+	CreatePushSession(entityType, workspace, entityName, version string) (*types.PushSession, error)
+	ClosePushSession(entityType, workspace, entityName, version, id string) error
+
 	SaveChunk(hash string, data []byte, version byte) error
 	SaveChunkReader(hash string, reader io.Reader, version byte) error
 	SaveFileStructure(structure types.FileStructure,
@@ -121,9 +136,14 @@ func (fs *ChunkedFileFS) GetDir(dirname string) *ChunkedFileFS {
 	return curDir
 }
 
+// Walk calls walkFunc for the root dir and all its files and dirs.
+// If walkFunc returns filepath.SkipDir for a dir, its content is skipped.
 func (fs *ChunkedFileFS) Walk(root string, walkFunc func(path string, f *ChunkedFile, err error) error) error {
 	rootDir := fs.GetDir(root)
 	if err := walkFunc(root, fs.dirObj(root, rootDir.ModTime), nil); err != nil {
+		if err == filepath.SkipDir {
+			return nil
+		}
 		return err
 	}
 	if rootDir == nil {
@@ -429,19 +449,29 @@ func (f *ChunkedFile) Seek(offset int64, whence int) (res int64, err error) {
 	case io.SeekCurrent:
 		absoluteOffset = f.offset + offset
 	case io.SeekEnd:
-		absoluteOffset = f.Size - offset
+		absoluteOffset = f.Size + offset
+	}
+	if absoluteOffset < 0 {
+		return 0, fmt.Errorf("seek before the start of the file")
 	}
 
 	prevCurrentChunk := f.currentChunk
 	ofs := absoluteOffset
+	found := false
 	for i, ch := range f.Chunks {
 		if ofs-ch.Size < 0 {
 			f.currentChunk = i
 			f.chunkOffset = ofs
+			found = true
 			break
 		}
 		ofs -= ch.Size
 	}
+	if !found && len(f.Chunks) > 0 {
+		// Seek to the end of file: stay at the end of the last chunk.
+		f.currentChunk = len(f.Chunks) - 1
+		f.chunkOffset = f.Chunks[f.currentChunk].Size
+	}
 	f.offset = absoluteOffset
 
 	if f.currentChunkReader != nil && prevCurrentChunk != f.currentChunk {
@@ -1,36 +1,70 @@
 package io
 
 import (
-	"bytes"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
-	"strings"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/kuberlab/lib/pkg/errors"
 	"github.com/kuberlab/pluk/pkg/types"
 	"github.com/kuberlab/pluk/pkg/utils"
 )
 
 type ChunkedReader struct {
 	ChunkSize int
-	reader    io.Reader
+	// Version is the chunk version which should be recorded for produced chunks.
+	// It also defines the hash algorithm.
+	Version byte
+	reader  io.Reader
+
+	cdc *cdcParams
+	buf []byte
+	eof bool
 }
 
+// NewChunkedReader returns a reader which cuts data at fixed offsets.
 func NewChunkedReader(chunkSize int, reader io.Reader) *ChunkedReader {
 	return &ChunkedReader{
 		ChunkSize: chunkSize,
-		reader:    reader,
+		Version:   types.ChunkVersion,
+		reader:    utils.NewPreciseReader(reader),
+	}
+}
+
+// NewCDCChunkedReader returns a reader which cuts data at content-defined
+// boundaries. chunkSize is the average chunk size.
+func NewCDCChunkedReader(chunkSize int, reader io.Reader) *ChunkedReader {
+	params := newCDCParams(chunkSize)
+	return &ChunkedReader{
+		ChunkSize: chunkSize,
+		Version:   types.ChunkVersionCDC,
+		reader:    utils.NewPreciseReader(reader),
+		cdc:       &params,
+	}
+}
+
+// MaxChunkedSize returns the size of the largest chunk cut for the chunk size.
+func MaxChunkedSize(chunkSize int, cdc bool) int {
+	if cdc {
+		return newCDCParams(chunkSize).max
 	}
+	return chunkSize
 }
 
 func (c *ChunkedReader) NextChunk() ([]byte, string, error) {
+	if c.cdc != nil {
+		return c.nextCDCChunk()
+	}
 	data := make([]byte, c.ChunkSize)
 
 	n, err := c.reader.Read(data)
 	if n > 0 {
 		res := data[:n]
-		sum := utils.CalcHash(res)
+		sum := CalcHash(res, c.Version)
 		return res, sum, nil
 	}
 	if err != nil {
@@ -39,6 +73,27 @@ func (c *ChunkedReader) NextChunk() ([]byte, string, error) {
 	return nil, "", io.EOF
 }
 
+func (c *ChunkedReader) nextCDCChunk() ([]byte, string, error) {
+	if !c.eof && len(c.buf) < c.cdc.max {
+		data := make([]byte, c.cdc.max-len(c.buf))
+		n, err := c.reader.Read(data)
+		if err == io.EOF {
+			c.eof = true
+		} else if err != nil {
+			return nil, "", err
+		}
+		c.buf = append(c.buf, data[:n]...)
+	}
+	if len(c.buf) == 0 {
+		return nil, "", io.EOF
+	}
+
+	cut := c.cdc.cutPoint(c.buf)
+	res := c.buf[:cut]
+	c.buf = append(make([]byte, 0, c.cdc.max), c.buf[cut:]...)
+	return res, CalcHash(res, c.Version), nil
+}
+
 func CheckChunk(hash string, version byte) (*types.ChunkCheck, error) {
 	size, exists := CheckLocalChunk(hash, version)
 
@@ -62,13 +117,37 @@ func CheckChunk(hash string, version byte) (*types.ChunkCheck, error) {
 	return &types.ChunkCheck{Hash: hash, Exists: exists, Size: size}, nil
 }
 
+// CheckChunks returns the chunks which are absent or have another size
+// on this instance or, for slaves, on the master.
+func CheckChunks(hashes []types.Hash) ([]types.Hash, error) {
+	missing := make([]types.Hash, 0)
+	present := make([]types.Hash, 0, len(hashes))
+	for _, h := range hashes {
+		size, exists := CheckLocalChunk(h.Hash, h.Version)
+		if !exists || size != h.Size {
+			missing = append(missing, h)
+			continue
+		}
+		present = append(present, h)
+	}
+
+	// Chunks absent locally are uploaded anyway, so ask master only about the rest.
+	if utils.HasMasters() && len(present) > 0 {
+		missingM, err := MasterClient.CheckChunks(present)
+		if err != nil {
+			return nil, err
+		}
+		missing = append(missing, missingM...)
+	}
+	return missing, nil
+}
+
 func CheckLocalChunk(hash string, version byte) (int64, bool) {
-	filePath := utils.GetHashedFilename(hash, version)
-	stat, err := os.Stat(filePath)
+	size, err := ChunkContentSize(hash, version)
 	if err != nil {
 		return 0, false
 	}
-	return stat.Size(), err == nil
+	return size, true
 }
 
 func GetChunkByHash(hash string, version byte) (reader io.ReadCloser, err error) {
@@ -77,94 +156,106 @@ func GetChunkByHash(hash string, version byte) (reader io.ReadCloser, err error)
 }
 
 func GetChunk(chunkPath string, version byte) (reader ReaderInterface, err error) {
-	reader, err = os.Open(chunkPath)
+	hash, _ := utils.GetHashFromPath(chunkPath)
+	reader, err = Store().Get(hash, version)
 	if err != nil {
-		hash, _ := utils.GetHashFromPath(chunkPath)
-		if reader != nil {
-			reader.Close()
-		}
 		if os.IsNotExist(err) && utils.HasMasters() {
-			// Read from master
-			//logrus.Debugf("download")
-			//t := time.Now()
+			// Read from master: the chunk is streamed through a temporary file
+			// which is moved to the store afterwards if SaveChunks() is set.
 			readerRaw, err := MasterClient.DownloadChunk(hash, version)
-
 			if err != nil {
 				return nil, err
 			}
-			if utils.SaveChunks() {
-				data, err := ioutil.ReadAll(readerRaw)
-				if err != nil {
-					return nil, err
-				}
-				//logrus.Debugf("download complete! %v", time.Since(t))
-				readerRaw.Close()
-				err = SaveChunk(hash, version, ioutil.NopCloser(bytes.NewBuffer(data)), false)
-				if err != nil {
-					logrus.Errorf("Could not save chunk: %v", err)
-				}
-				return NewChunkReaderFromData(data), nil
-			} else {
-				data, err := ioutil.ReadAll(readerRaw)
-				if err != nil {
-					return nil, err
-				}
-				//logrus.Debugf("download complete! %v", time.Since(t))
+			tee, err := NewTeeChunkReader(hash, version, readerRaw, utils.SaveChunks())
+			if err != nil {
 				readerRaw.Close()
-				return NewChunkReaderFromData(data), nil
-				//if err != nil {
-				//	return nil, err
-				//}
-				//return reader, nil
+				return nil, err
 			}
+			return tee, nil
 		} else {
 			return nil, err
 		}
 	}
+	if cache := Cache(); cache != nil {
+		cache.Touch(hash, version)
+	}
+	if IsCompressed(version) {
+		return decompressChunk(hash, version, reader)
+	}
 	return reader, err
 }
 
+// VerifyChunk checks that data matches the given hash.
+func VerifyChunk(hash string, version byte, data []byte) error {
+	return verifyHash(hash, CalcHash(data, version))
+}
+
+func verifyHash(hash, actual string) error {
+	if actual != hash {
+		return errors.NewStatus(
+			http.StatusBadRequest,
+			fmt.Sprintf("Chunk hash mismatch: expected %v, got %v", hash, actual),
+		)
+	}
+	return nil
+}
+
 func SaveChunk(hash string, version byte, data io.ReadCloser, sendToMaster bool) error {
 	//logrus.Debugf("Save")
 	//t := time.Now()
-	filePath := utils.GetHashedFilename(hash, version)
-
-	splitted := strings.Split(filePath, "/")
-	baseDir := splitted[:len(splitted)-1]
+	defer data.Close()
 
-	if err := os.MkdirAll(strings.Join(baseDir, "/"), os.ModePerm); err != nil {
-		data.Close()
+	// The chunk is streamed into a temporary file and verified before it becomes visible.
+	if err := os.MkdirAll(utils.DataDir(), os.ModePerm); err != nil {
 		return err
 	}
-
-	file, err := os.Create(filePath)
+	file, err := ioutil.TempFile(utils.DataDir(), tempFilePrefix)
 	if err != nil {
-		data.Close()
 		return err
 	}
-	logrus.Debugf("Created %v", filePath)
-
+	defer os.Remove(file.Name())
 	defer file.Close()
 
-	buf := bytes.NewBuffer([]byte{})
-	var written int64
-	var writer io.Writer = file
+	hasher := NewHasher(version)
+	written, err := io.Copy(io.MultiWriter(file, hasher), io.LimitReader(data, types.MaxChunkSize+1))
+	if err != nil {
+		return err
+	}
+	if written > types.MaxChunkSize {
+		return errors.NewStatus(
+			http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Chunk size exceeds the maximum of %v", types.MaxChunkSize),
+		)
+	}
+	if err = verifyHash(hash, hex.EncodeToString(hasher.Sum(nil))); err != nil {
+		return err
+	}
+
+	var raw []byte
 	if utils.HasMasters() && sendToMaster {
-		// If we have masters, then also write to buf in order to use it for further push.
-		writer = io.MultiWriter(writer, buf)
+		if raw, err = ioutil.ReadFile(file.Name()); err != nil {
+			return err
+		}
 	}
-	written, err = io.Copy(writer, data)
-	if err != nil {
-		data.Close()
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = putChunkFile(hash, version, file.Name()); err != nil {
 		return err
 	}
-	data.Close()
 
 	logrus.Debugf("Written %v bytes.", written)
 
+	if sendToMaster {
+		// Chunk is pushed to this instance and must not be evicted.
+		if cache := Cache(); cache != nil {
+			cache.Remove(hash, version)
+		}
+	}
+
 	if utils.HasMasters() && sendToMaster {
 		// TODO: decide whether it can go in async
-		return MasterClient.SaveChunk(hash, buf.Bytes(), version)
+		return MasterClient.SaveChunk(hash, raw, version)
 	}
 	//logrus.Debugf("Save complete! %v", time.Since(t))
 	return nil
@@ -0,0 +1,69 @@
+package io
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/kuberlab/lib/pkg/errors"
+	"github.com/kuberlab/pluk/pkg/types"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestSaveChunk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pluk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldDir := utils.DataDirValue
+	utils.DataDirValue = dir
+	defer func() { utils.DataDirValue = oldDir }()
+	SetStore(NewLocalStore())
+	defer SetStore(nil)
+
+	data := []byte("chunk data")
+	hash := CalcHash(data, types.ChunkVersion)
+
+	// Wrong data is not stored.
+	err = SaveChunk(hash, types.ChunkVersion, ioutil.NopCloser(bytes.NewBufferString("other data")), false)
+	utils.Assert(http.StatusBadRequest, err.(*errors.Error).Status, t)
+	_, err = Store().Stat(hash, types.ChunkVersion)
+	utils.Assert(true, os.IsNotExist(err), t)
+
+	// Data is not read beyond the maximum chunk size.
+	body := io.LimitReader(zeroReader{}, types.MaxChunkSize*2)
+	err = SaveChunk(hash, types.ChunkVersion, ioutil.NopCloser(body), false)
+	utils.Assert(http.StatusRequestEntityTooLarge, err.(*errors.Error).Status, t)
+	utils.Assert(int64(types.MaxChunkSize-1), body.(*io.LimitedReader).N, t)
+
+	if err = SaveChunk(hash, types.ChunkVersion, ioutil.NopCloser(bytes.NewReader(data)), false); err != nil {
+		t.Fatal(err)
+	}
+	size, err := Store().Stat(hash, types.ChunkVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(int64(len(data)), size, t)
+
+	// Temporary files are removed.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		utils.Assert(false, f.Mode().IsRegular(), t)
+	}
+}
@@ -0,0 +1,192 @@
+package io
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/kuberlab/pluk/pkg/types"
+)
+
+// Compressed chunks are stored as the 8-byte big-endian size of
+// the uncompressed content followed by the gzip stream.
+const compressedHeaderSize = 8
+
+func IsCompressed(version byte) bool {
+	return chunkFlags(version)&types.ChunkFlagCompressed != 0
+}
+
+func compressChunk(data io.Reader) (io.Reader, error) {
+	raw, err := ioutil.ReadAll(io.LimitReader(data, types.MaxChunkSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > types.MaxChunkSize {
+		return nil, errChunkTooLarge(int64(len(raw)))
+	}
+
+	buf := bytes.NewBuffer(make([]byte, compressedHeaderSize, compressedHeaderSize+len(raw)/2))
+	binary.BigEndian.PutUint64(buf.Bytes()[:compressedHeaderSize], uint64(len(raw)))
+
+	w := gzip.NewWriter(buf)
+	if _, err = w.Write(raw); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func errChunkTooLarge(size int64) error {
+	return fmt.Errorf("Chunk size %v exceeds the maximum of %v", size, types.MaxChunkSize)
+}
+
+// decompressReader streams the content of the compressed chunk.
+// Seeking only moves the position: data is skipped on the next Read
+// and seeking backwards reopens the chunk in the store.
+type decompressReader struct {
+	hash    string
+	version byte
+	file    io.ReadCloser
+	gz      *gzip.Reader
+	size    int64
+	// pos is the position of the decompressed stream.
+	pos    int64
+	offset int64
+}
+
+func decompressChunk(hash string, version byte, reader io.ReadCloser) (ReaderInterface, error) {
+	r := &decompressReader{hash: hash, version: version}
+	if err := r.open(reader); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *decompressReader) open(reader io.ReadCloser) error {
+	size, err := readCompressedSize(reader)
+	if err != nil {
+		reader.Close()
+		return err
+	}
+	gz, err := gzip.NewReader(reader)
+	if err != nil {
+		reader.Close()
+		return fmt.Errorf("Failed to decompress chunk: %v", err)
+	}
+	r.file, r.gz, r.size, r.pos = reader, gz, size, 0
+	return nil
+}
+
+// skip moves the decompressed stream to the current offset.
+func (r *decompressReader) skip() error {
+	if r.offset < r.pos || r.gz == nil {
+		reader, err := Store().Get(r.hash, r.version)
+		if err != nil {
+			return err
+		}
+		r.Close()
+		if err = r.open(reader); err != nil {
+			return err
+		}
+	}
+	if r.offset > r.pos {
+		n, err := io.CopyN(ioutil.Discard, r.gz, r.offset-r.pos)
+		r.pos += n
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to decompress chunk: %v", err)
+		}
+	}
+	return nil
+}
+
+func (r *decompressReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if err := r.skip(); err != nil {
+		return 0, err
+	}
+	if int64(len(p)) > r.size-r.offset {
+		p = p[:r.size-r.offset]
+	}
+	n, err := r.gz.Read(p)
+	r.pos += int64(n)
+	r.offset = r.pos
+	if err == io.EOF && r.offset < r.size {
+		err = fmt.Errorf("Failed to decompress chunk: %v", io.ErrUnexpectedEOF)
+	}
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *decompressReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return r.offset, fmt.Errorf("Negative position %v", offset)
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *decompressReader) Close() error {
+	if r.gz == nil {
+		return nil
+	}
+	r.gz.Close()
+	r.gz = nil
+	return r.file.Close()
+}
+
+func readCompressedSize(reader io.Reader) (int64, error) {
+	header := make([]byte, compressedHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return 0, fmt.Errorf("Failed to read compressed chunk header: %v", err)
+	}
+	// A damaged header must not make readers allocate arbitrary amounts of memory.
+	size := binary.BigEndian.Uint64(header)
+	if size > types.MaxChunkSize {
+		return 0, errChunkTooLarge(int64(size))
+	}
+	return int64(size), nil
+}
+
+// rangeGetter is implemented by remote stores which can read
+// a part of the chunk without downloading all of it.
+type rangeGetter interface {
+	GetRange(hash string, version byte, offset, length int64) (io.ReadCloser, error)
+}
+
+// ChunkContentSize returns the size of the chunk content
+// which may differ from the stored size for compressed chunks.
+func ChunkContentSize(hash string, version byte) (int64, error) {
+	if !IsCompressed(version) {
+		return Store().Stat(hash, version)
+	}
+	var reader io.ReadCloser
+	var err error
+	if getter, ok := Store().(rangeGetter); ok {
+		reader, err = getter.GetRange(hash, version, 0, compressedHeaderSize)
+	} else {
+		reader, err = Store().Get(hash, version)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	return readCompressedSize(reader)
+}
@@ -0,0 +1,175 @@
+package io
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kuberlab/pluk/pkg/types"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+func TestCompressedChunk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pluk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldDir := utils.DataDirValue
+	utils.DataDirValue = dir
+	defer func() { utils.DataDirValue = oldDir }()
+	SetStore(NewLocalStore())
+	defer SetStore(nil)
+
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	hash := CalcHash(data, types.ChunkVersion)
+	if err = SaveChunk(hash, types.ChunkVersionCompressed, ioutil.NopCloser(bytes.NewReader(data)), false); err != nil {
+		t.Fatal(err)
+	}
+	size, err := ChunkContentSize(hash, types.ChunkVersionCompressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(int64(len(data)), size, t)
+
+	stored, err := Store().Get(hash, types.ChunkVersionCompressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := decompressChunk(hash, types.ChunkVersionCompressed, stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	buf := make([]byte, 10)
+	if _, err = r.Seek(50005, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert("5678901234", string(buf), t)
+
+	// Seeking back reopens the chunk.
+	if _, err = r.Seek(3, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert("3456789012", string(buf), t)
+
+	end, err := r.Seek(-10, io.SeekEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(int64(len(data)-10), end, t)
+	rest, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert("0123456789", string(rest), t)
+}
+
+type countingStore struct {
+	ChunkStore
+	gets int
+}
+
+func (s *countingStore) Get(hash string, version byte) (ReaderInterface, error) {
+	s.gets++
+	return s.ChunkStore.Get(hash, version)
+}
+
+func TestCompressedChunkServeContent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pluk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldDir := utils.DataDirValue
+	utils.DataDirValue = dir
+	defer func() { utils.DataDirValue = oldDir }()
+	s := &countingStore{ChunkStore: NewLocalStore()}
+	SetStore(s)
+	defer SetStore(nil)
+
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	hash := CalcHash(data, types.ChunkVersion)
+	if err = SaveChunk(hash, types.ChunkVersionCompressed, ioutil.NopCloser(bytes.NewReader(data)), false); err != nil {
+		t.Fatal(err)
+	}
+	r, err := GetChunkByHash(hash, types.ChunkVersionCompressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Range requests find out the size first, it must not read the chunk.
+	w := httptest.NewRecorder()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Range", "bytes=10-19")
+	http.ServeContent(w, req, hash, time.Time{}, r.(io.ReadSeeker))
+	utils.Assert(http.StatusPartialContent, w.Code, t)
+	utils.Assert("0123456789", w.Body.String(), t)
+	utils.Assert(1, s.gets, t)
+}
+
+func TestCompressedChunkContentSizeS3(t *testing.T) {
+	fake := &fakeS3{bucket: "chunks", objects: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	s, err := NewS3Store(server.URL, "chunks", "", "access", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetStore(s)
+	defer SetStore(nil)
+
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	hash := CalcHash(data, types.ChunkVersion)
+	if err = SaveChunk(hash, types.ChunkVersionCompressed, ioutil.NopCloser(bytes.NewReader(data)), false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the header is downloaded.
+	size, err := ChunkContentSize(hash, types.ChunkVersionCompressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(int64(len(data)), size, t)
+	utils.Assert([]string{"bytes=0-7"}, fake.ranges, t)
+}
+
+func TestCompressedChunkDamagedHeader(t *testing.T) {
+	data := []byte("0123456789")
+	stored := &bytes.Buffer{}
+	header := make([]byte, compressedHeaderSize)
+	binary.BigEndian.PutUint64(header, 1<<62)
+	stored.Write(header)
+	w := gzip.NewWriter(stored)
+	w.Write(data)
+	w.Close()
+
+	// A huge size in the header is rejected before anything is allocated.
+	_, err := decompressChunk("hash", types.ChunkVersionCompressed, ioutil.NopCloser(bytes.NewReader(stored.Bytes())))
+	utils.Assert(true, err != nil, t)
+
+	// Content shorter than the header says is an error.
+	binary.BigEndian.PutUint64(stored.Bytes(), uint64(len(data)+5))
+	r, err := decompressChunk("hash", types.ChunkVersionCompressed, ioutil.NopCloser(bytes.NewReader(stored.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	_, err = ioutil.ReadAll(r)
+	utils.Assert(true, err != nil, t)
+}
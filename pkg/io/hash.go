@@ -0,0 +1,54 @@
+package io
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/kuberlab/pluk/pkg/types"
+)
+
+const (
+	HashSHA512 = "sha512"
+	HashSHA256 = "sha256"
+)
+
+// HashAlgorithm returns the name of hash algorithm used for the chunk version.
+func HashAlgorithm(version byte) string {
+	if chunkFlags(version)&types.ChunkFlagSHA256 != 0 {
+		return HashSHA256
+	}
+	return HashSHA512
+}
+
+// HashVersion returns the chunk version for the given hash algorithm name.
+func HashVersion(algorithm string) (byte, error) {
+	switch strings.ToLower(algorithm) {
+	case "", HashSHA512:
+		return types.ChunkVersion, nil
+	case HashSHA256:
+		return types.ChunkVersionSHA256, nil
+	default:
+		return 0, fmt.Errorf("Unknown hash algorithm %q: must be one of %v, %v", algorithm, HashSHA512, HashSHA256)
+	}
+}
+
+func NewHasher(version byte) hash.Hash {
+	if HashAlgorithm(version) == HashSHA256 {
+		return sha256.New()
+	}
+	return sha512.New()
+}
+
+// CalcHash calculates the hash of chunk data using the algorithm of the chunk version.
+func CalcHash(data []byte, version byte) string {
+	if HashAlgorithm(version) == HashSHA256 {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+	sum := sha512.Sum512(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -1,6 +1,7 @@
 package io
 
 import (
+	"errors"
 	"io"
 	"io/ioutil"
 
@@ -52,6 +53,15 @@ func (r *ChunkReader) Close() error {
 }
 
 func (r *ChunkReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += int64(len(r.data))
+	}
+	if offset < 0 {
+		return r.offset, errors.New("ChunkReader: negative position")
+	}
 	r.offset = offset
 	return r.offset, nil
 }
@@ -0,0 +1,86 @@
+package io
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+// ChunkStore is a storage backend for chunk data.
+// Chunks are addressed by hash and chunk format version.
+type ChunkStore interface {
+	Put(hash string, version byte, data io.Reader) (int64, error)
+	Get(hash string, version byte) (ReaderInterface, error)
+	Stat(hash string, version byte) (int64, error)
+	Delete(hash string, version byte) error
+	// List calls walkFunc for every stored chunk.
+	// modTime is the time the chunk was last written.
+	List(walkFunc func(hash string, version byte, size int64, modTime time.Time) error) error
+}
+
+var (
+	store     ChunkStore
+	storeLock sync.Mutex
+)
+
+// InitStore creates the chunk store configured by CHUNK_STORE.
+// It is called on startup so a wrong configuration fails early.
+func InitStore() error {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	return initStore()
+}
+
+func initStore() error {
+	if store != nil {
+		return nil
+	}
+	s, err := NewChunkStore(utils.ChunkStore())
+	if err != nil {
+		return fmt.Errorf("Failed to init %v chunk store: %v", utils.ChunkStore(), err)
+	}
+	store = s
+	return nil
+}
+
+// Store returns the chunk store configured by CHUNK_STORE.
+// Chunks are never written to another store than configured.
+func Store() ChunkStore {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	if err := initStore(); err != nil {
+		logrus.Fatal(err)
+	}
+	return store
+}
+
+// SetStore overrides the chunk store used by the package.
+func SetStore(s ChunkStore) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	store = s
+}
+
+func NewChunkStore(storeType string) (ChunkStore, error) {
+	switch storeType {
+	case "", "local":
+		return NewLocalStore(), nil
+	case "pack":
+		return NewPackStore(NewLocalStore(), filepath.Join(utils.DataDir(), packDirName), utils.PackChunkSize())
+	case "s3":
+		return NewS3Store(
+			utils.S3Endpoint(),
+			utils.S3Bucket(),
+			utils.S3Region(),
+			utils.S3AccessKey(),
+			utils.S3SecretKey(),
+		)
+	default:
+		return nil, fmt.Errorf("Unknown chunk store type %q: must be one of local, pack, s3", storeType)
+	}
+}
@@ -0,0 +1,140 @@
+package io
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+const (
+	tempFilePrefix = ".tmp-"
+	tempFileTTL    = time.Hour
+)
+
+// LocalStore keeps chunks as separate files under DATA_DIR.
+type LocalStore struct{}
+
+func NewLocalStore() *LocalStore {
+	return &LocalStore{}
+}
+
+// Put writes the chunk to a temporary file and renames it into place,
+// so that a partially written chunk is never visible under its hash.
+func (s *LocalStore) Put(hash string, version byte, data io.Reader) (written int64, err error) {
+	filePath := utils.GetHashedFilename(hash, version)
+	dir := filepath.Dir(filePath)
+
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return 0, err
+	}
+
+	file, err := ioutil.TempFile(dir, tempFilePrefix)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+
+	if written, err = io.Copy(file, data); err != nil {
+		return 0, err
+	}
+	if err = file.Sync(); err != nil {
+		return 0, err
+	}
+	if err = file.Close(); err != nil {
+		return 0, err
+	}
+	if err = os.Chmod(file.Name(), 0644); err != nil {
+		return 0, err
+	}
+	if err = os.Rename(file.Name(), filePath); err != nil {
+		return 0, err
+	}
+	logrus.Debugf("Created %v", filePath)
+
+	// Persist the rename itself.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return written, nil
+}
+
+// PutFile moves the file written by the caller into place.
+// The file must be located within DATA_DIR.
+func (s *LocalStore) PutFile(hash string, version byte, path string) error {
+	filePath := utils.GetHashedFilename(hash, version)
+	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path, filePath)
+}
+
+func (s *LocalStore) Get(hash string, version byte) (ReaderInterface, error) {
+	file, err := os.Open(utils.GetHashedFilename(hash, version))
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (s *LocalStore) Stat(hash string, version byte) (int64, error) {
+	stat, err := os.Stat(utils.GetHashedFilename(hash, version))
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+func (s *LocalStore) Delete(hash string, version byte) error {
+	path := utils.GetHashedFilename(hash, version)
+	err := os.Remove(path)
+
+	dirName := filepath.Dir(path)
+	remainFiles, _ := ioutil.ReadDir(dirName)
+
+	// If there are no files in this directory, delete it.
+	if len(remainFiles) == 0 {
+		_ = os.RemoveAll(dirName)
+	}
+	return err
+}
+
+func (s *LocalStore) List(walkFunc func(hash string, version byte, size int64, modTime time.Time) error) error {
+	return filepath.Walk(utils.DataDir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if strings.HasPrefix(info.Name(), ".") && path != utils.DataDir() {
+				// Service directories, e.g. pack files.
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".") {
+			// Service files, not chunks.
+			if strings.HasPrefix(info.Name(), tempFilePrefix) && time.Since(info.ModTime()) > tempFileTTL {
+				// Leftovers of interrupted writes.
+				logrus.Debugf("Delete stale temp file %v", path)
+				os.Remove(path)
+			}
+			return nil
+		}
+		hash, version := utils.GetHashFromPath(path)
+		return walkFunc(hash, version, info.Size(), info.ModTime())
+	})
+}
@@ -0,0 +1,412 @@
+package io
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	packDirName = ".packs"
+	// Pack file is closed for appending once it reaches this size.
+	packFileSize = 256 * 1024 * 1024
+	// Packs with more garbage than this ratio are rewritten on compaction.
+	packGarbageRatio = 0.5
+)
+
+// Compactor is implemented by stores which need periodical compaction.
+type Compactor interface {
+	Compact() error
+}
+
+// PackStore appends small chunks into large pack files in order to
+// save inodes. Bigger chunks are passed to the base store.
+//
+// Each pack file NNNNNN.pack has an append-only index log NNNNNN.idx
+// with lines "+ <version> <hash> <offset> <length>" for added chunks and
+// "- <version> <hash>" for deleted ones.
+type PackStore struct {
+	Dir          string
+	MaxChunkSize int64
+
+	base ChunkStore
+
+	lock    sync.RWMutex
+	entries map[string]*packEntry
+	packs   map[int]*packInfo
+	active  *packInfo
+}
+
+type packEntry struct {
+	pack    int
+	offset  int64
+	length  int64
+	hash    string
+	version byte
+}
+
+type packInfo struct {
+	id   int
+	size int64
+	live int64
+	// Time of the last append, it is used as modification time of all pack chunks.
+	modTime time.Time
+
+	data *os.File
+	idx  *os.File
+}
+
+func NewPackStore(base ChunkStore, dir string, maxChunkSize int64) (*PackStore, error) {
+	s := &PackStore{
+		Dir:          dir,
+		MaxChunkSize: maxChunkSize,
+		base:         base,
+		entries:      make(map[string]*packEntry),
+		packs:        make(map[int]*packInfo),
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func packKey(hash string, version byte) string {
+	return fmt.Sprintf("%v/%v", version, hash)
+}
+
+func (s *PackStore) packPath(id int, ext string) string {
+	return filepath.Join(s.Dir, fmt.Sprintf("%06d.%v", id, ext))
+}
+
+func (s *PackStore) load() error {
+	files, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return err
+	}
+	ids := make([]int, 0)
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".pack") {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSuffix(f.Name(), ".pack"))
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+		s.packs[id] = &packInfo{id: id, size: f.Size(), modTime: f.ModTime()}
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		if err = s.replayIndex(s.packs[id]); err != nil {
+			return err
+		}
+	}
+	logrus.Infof("[PackStore] Loaded %v chunks from %v packs", len(s.entries), len(ids))
+	return nil
+}
+
+func (s *PackStore) replayIndex(pack *packInfo) error {
+	file, err := os.Open(s.packPath(pack.id, "idx"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		version, err := strconv.ParseUint(fields[1], 10, 8)
+		if err != nil {
+			continue
+		}
+		key := packKey(fields[2], byte(version))
+
+		switch fields[0] {
+		case "+":
+			if len(fields) != 5 {
+				continue
+			}
+			offset, err1 := strconv.ParseInt(fields[3], 10, 64)
+			length, err2 := strconv.ParseInt(fields[4], 10, 64)
+			if err1 != nil || err2 != nil || offset+length > pack.size {
+				// Partially written record.
+				continue
+			}
+			if old, ok := s.entries[key]; ok {
+				s.packs[old.pack].live -= old.length
+			}
+			s.entries[key] = &packEntry{
+				pack: pack.id, offset: offset, length: length, hash: fields[2], version: byte(version),
+			}
+			pack.live += length
+		case "-":
+			if old, ok := s.entries[key]; ok && old.pack == pack.id {
+				pack.live -= old.length
+				delete(s.entries, key)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// activePack returns the pack for appending, s.lock must be held.
+func (s *PackStore) activePack(length int64) (*packInfo, error) {
+	if s.active != nil && s.active.size+length <= packFileSize {
+		return s.active, nil
+	}
+	if s.active != nil {
+		s.active.close()
+	}
+
+	id := 1
+	for existing := range s.packs {
+		if existing >= id {
+			id = existing + 1
+		}
+	}
+	data, err := os.OpenFile(s.packPath(id, "pack"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := os.OpenFile(s.packPath(id, "idx"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+	s.active = &packInfo{id: id, data: data, idx: idx, modTime: time.Now()}
+	s.packs[id] = s.active
+	return s.active, nil
+}
+
+func (p *packInfo) close() {
+	if p.data != nil {
+		p.data.Close()
+		p.data = nil
+	}
+	if p.idx != nil {
+		p.idx.Close()
+		p.idx = nil
+	}
+}
+
+func (s *PackStore) appendIndex(pack *packInfo, line string) error {
+	if pack.idx != nil {
+		_, err := pack.idx.WriteString(line)
+		return err
+	}
+	idx, err := os.OpenFile(s.packPath(pack.id, "idx"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+	_, err = idx.WriteString(line)
+	return err
+}
+
+// appendChunk writes the chunk to the active pack, s.lock must be held.
+func (s *PackStore) appendChunk(hash string, version byte, data []byte) error {
+	length := int64(len(data))
+	pack, err := s.activePack(length)
+	if err != nil {
+		return err
+	}
+	offset := pack.size
+	if _, err = pack.data.Write(data); err != nil {
+		return err
+	}
+	pack.size += length
+	pack.modTime = time.Now()
+	// Index record must never point to data which is not on disk.
+	if err = pack.data.Sync(); err != nil {
+		return err
+	}
+	line := fmt.Sprintf("+ %v %v %v %v\n", version, hash, offset, length)
+	if err = s.appendIndex(pack, line); err != nil {
+		return err
+	}
+
+	key := packKey(hash, version)
+	if old, ok := s.entries[key]; ok {
+		s.packs[old.pack].live -= old.length
+	}
+	s.entries[key] = &packEntry{pack: pack.id, offset: offset, length: length, hash: hash, version: version}
+	pack.live += length
+	return nil
+}
+
+func (s *PackStore) Put(hash string, version byte, data io.Reader) (int64, error) {
+	// Read one byte more than the limit to find out whether the chunk is small.
+	head, err := ioutil.ReadAll(io.LimitReader(data, s.MaxChunkSize+1))
+	if err != nil {
+		return 0, err
+	}
+	if int64(len(head)) > s.MaxChunkSize {
+		return s.base.Put(hash, version, io.MultiReader(bytes.NewReader(head), data))
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err = s.appendChunk(hash, version, head); err != nil {
+		return 0, err
+	}
+	return int64(len(head)), nil
+}
+
+type packReader struct {
+	*io.SectionReader
+	file *os.File
+}
+
+func (r *packReader) Close() error {
+	return r.file.Close()
+}
+
+func (s *PackStore) Get(hash string, version byte) (ReaderInterface, error) {
+	s.lock.RLock()
+	entry, ok := s.entries[packKey(hash, version)]
+	if !ok {
+		s.lock.RUnlock()
+		return s.base.Get(hash, version)
+	}
+
+	// Compaction removes packs under the write lock, so the pack is opened
+	// before the lock is released. The opened file stays readable after removal.
+	file, err := os.Open(s.packPath(entry.pack, "pack"))
+	s.lock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return &packReader{SectionReader: io.NewSectionReader(file, entry.offset, entry.length), file: file}, nil
+}
+
+func (s *PackStore) Stat(hash string, version byte) (int64, error) {
+	s.lock.RLock()
+	entry, ok := s.entries[packKey(hash, version)]
+	s.lock.RUnlock()
+	if !ok {
+		return s.base.Stat(hash, version)
+	}
+	return entry.length, nil
+}
+
+func (s *PackStore) Delete(hash string, version byte) error {
+	s.lock.Lock()
+	key := packKey(hash, version)
+	entry, ok := s.entries[key]
+	if !ok {
+		s.lock.Unlock()
+		return s.base.Delete(hash, version)
+	}
+	pack := s.packs[entry.pack]
+	err := s.appendIndex(pack, fmt.Sprintf("- %v %v\n", version, hash))
+	if err == nil {
+		pack.live -= entry.length
+		delete(s.entries, key)
+	}
+	s.lock.Unlock()
+	return err
+}
+
+func (s *PackStore) List(walkFunc func(hash string, version byte, size int64, modTime time.Time) error) error {
+	s.lock.RLock()
+	entries := make([]packEntry, 0, len(s.entries))
+	modTimes := make(map[int]time.Time)
+	for _, entry := range s.entries {
+		entries = append(entries, *entry)
+	}
+	for id, pack := range s.packs {
+		modTimes[id] = pack.modTime
+	}
+	s.lock.RUnlock()
+
+	for _, entry := range entries {
+		if err := walkFunc(entry.hash, entry.version, entry.length, modTimes[entry.pack]); err != nil {
+			return err
+		}
+	}
+	return s.base.List(walkFunc)
+}
+
+// Compact rewrites live chunks of packs which contain mostly deleted
+// chunks into the active pack and removes those packs.
+func (s *PackStore) Compact() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for id, pack := range s.packs {
+		if pack == s.active || pack.size == 0 {
+			continue
+		}
+		if float64(pack.size-pack.live)/float64(pack.size) < packGarbageRatio {
+			continue
+		}
+		if err := s.compactPack(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PackStore) compactPack(id int) error {
+	pack := s.packs[id]
+	logrus.Infof("[PackStore] Compact pack %v: %v of %v bytes are live", id, pack.live, pack.size)
+
+	file, err := os.Open(s.packPath(id, "pack"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for _, entry := range s.entries {
+		if entry.pack != id {
+			continue
+		}
+		data := make([]byte, entry.length)
+		if _, err = file.ReadAt(data, entry.offset); err != nil {
+			return err
+		}
+		if err = s.appendChunk(entry.hash, entry.version, data); err != nil {
+			return err
+		}
+	}
+
+	if err = os.Remove(s.packPath(id, "idx")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = os.Remove(s.packPath(id, "pack")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(s.packs, id)
+	return nil
+}
+
+func (s *PackStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.active != nil {
+		s.active.close()
+		s.active = nil
+	}
+	return nil
+}
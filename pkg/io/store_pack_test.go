@@ -0,0 +1,128 @@
+package io
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kuberlab/pluk/pkg/types"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+func TestPackStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pluk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldDir := utils.DataDirValue
+	utils.DataDirValue = dir
+	defer func() { utils.DataDirValue = oldDir }()
+
+	packDir := filepath.Join(dir, packDirName)
+	s, err := NewPackStore(NewLocalStore(), packDir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashes := make([]string, 0)
+	for i := 0; i < 10; i++ {
+		data := fmt.Sprintf("small chunk %v", i)
+		hash := CalcHash([]byte(data), types.ChunkVersion)
+		if _, err = s.Put(hash, 2, bytes.NewBufferString(data)); err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+	}
+	big := bytes.Repeat([]byte("x"), 200)
+	bigHash := CalcHash(big, types.ChunkVersion)
+	if _, err = s.Put(bigHash, 2, bytes.NewReader(big)); err != nil {
+		t.Fatal(err)
+	}
+	// Big chunk is stored as a separate file.
+	utils.Assert(true, utils.Exists(utils.GetHashedFilename(bigHash, 2)), t)
+	utils.Assert(false, utils.Exists(utils.GetHashedFilename(hashes[0], 2)), t)
+
+	reader, err := s.Get(hashes[3], 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(reader)
+	reader.Close()
+	utils.Assert("small chunk 3", string(data), t)
+
+	size, err := s.Stat(hashes[3], 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(int64(len("small chunk 3")), size, t)
+
+	for _, hash := range hashes[:8] {
+		if err = s.Delete(hash, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = s.Stat(hashes[0], 2)
+	utils.Assert(true, os.IsNotExist(err), t)
+
+	listed := 0
+	err = s.List(func(hash string, version byte, size int64, modTime time.Time) error {
+		utils.Assert(false, modTime.IsZero(), t)
+		listed++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(3, listed, t)
+
+	// Compaction moves live chunks from the closed pack to a new one.
+	// Readers opened before and during compaction are not affected.
+	s.Close()
+	opened, err := s.Get(hashes[9], 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readErrs := make(chan error)
+	go func() {
+		for i := 0; i < 100; i++ {
+			r, err := s.Get(hashes[8], 2)
+			if err != nil {
+				readErrs <- err
+				return
+			}
+			r.Close()
+		}
+		readErrs <- nil
+	}()
+	if err = s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(nil, <-readErrs, t)
+	data, _ = ioutil.ReadAll(opened)
+	opened.Close()
+	utils.Assert("small chunk 9", string(data), t)
+	s.Close()
+	utils.Assert(false, utils.Exists(filepath.Join(packDir, "000001.pack")), t)
+
+	// Index is restored from disk.
+	s, err = NewPackStore(NewLocalStore(), packDir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, hash := range hashes {
+		_, err = s.Stat(hash, 2)
+		utils.Assert(i >= 8, err == nil, t)
+	}
+	reader, err = s.Get(hashes[9], 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = ioutil.ReadAll(reader)
+	reader.Close()
+	utils.Assert("small chunk 9", string(data), t)
+}
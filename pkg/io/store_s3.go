@@ -0,0 +1,424 @@
+package io
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+const (
+	s3Algorithm   = "AWS4-HMAC-SHA256"
+	s3Service     = "s3"
+	s3TimeFormat  = "20060102T150405Z"
+	s3DateFormat  = "20060102"
+	s3ListMaxKeys = "1000"
+	// Payload of uploads is not hashed in order to stream it, TLS protects it instead.
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// S3Store keeps chunks in a bucket of an S3-compatible object storage
+// (AWS S3, MinIO, Ceph RGW). Path-style addressing is used:
+// <endpoint>/<bucket>/<chunk-key>.
+type S3Store struct {
+	Endpoint  *url.URL
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+
+	client *http.Client
+}
+
+func NewS3Store(endpoint, bucket, region, accessKey, secretKey string) (*S3Store, error) {
+	if endpoint == "" || bucket == "" {
+		return nil, errors.New("S3 endpoint and bucket must be set")
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3Store{
+		Endpoint:  u,
+		Bucket:    bucket,
+		Region:    region,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		client:    &http.Client{Timeout: time.Minute * 5},
+	}, nil
+}
+
+func (s *S3Store) objectPath(hash string, version byte) string {
+	return "/" + s.Bucket + "/" + utils.GetHashedKey(hash, version)
+}
+
+func (s *S3Store) Put(hash string, version byte, data io.Reader) (int64, error) {
+	// S3 requires the length of the object in advance, data of unknown
+	// length is spooled to a temporary file instead of memory.
+	size, ok := readerSize(data)
+	if !ok {
+		tmp, err := ioutil.TempFile("", "pluk-s3")
+		if err != nil {
+			return 0, err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if size, err = io.Copy(tmp, data); err != nil {
+			return 0, err
+		}
+		if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+		data = tmp
+	}
+	resp, err := s.do(http.MethodPut, s.objectPath(hash, version), nil, nil, data, size)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return size, nil
+}
+
+func readerSize(data io.Reader) (int64, bool) {
+	switch r := data.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len()), true
+	case *os.File:
+		stat, err := r.Stat()
+		if err != nil || !stat.Mode().IsRegular() {
+			return 0, false
+		}
+		offset, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false
+		}
+		return stat.Size() - offset, true
+	}
+	return 0, false
+}
+
+func (s *S3Store) Get(hash string, version byte) (ReaderInterface, error) {
+	r := &s3Reader{store: s, path: s.objectPath(hash, version)}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *S3Store) GetRange(hash string, version byte, offset, length int64) (io.ReadCloser, error) {
+	header := http.Header{"Range": []string{fmt.Sprintf("bytes=%v-%v", offset, offset+length-1)}}
+	resp, err := s.do(http.MethodGet, s.objectPath(hash, version), nil, header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		// The range is ignored, read only the requested part anyway.
+		if _, err = io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(resp.Body, length), resp.Body}, nil
+	}
+	return resp.Body, nil
+}
+
+// s3Reader streams the object from the response body. Seeking only moves
+// the position, the object is requested again with a range on the next Read.
+type s3Reader struct {
+	store  *S3Store
+	path   string
+	body   io.ReadCloser
+	size   int64
+	pos    int64
+	offset int64
+}
+
+func (r *s3Reader) open() error {
+	var header http.Header
+	if r.offset > 0 {
+		header = http.Header{"Range": []string{fmt.Sprintf("bytes=%v-", r.offset)}}
+	}
+	resp, err := r.store.do(http.MethodGet, r.path, nil, header, nil, 0)
+	if err != nil {
+		return err
+	}
+	if r.offset == 0 {
+		r.size = resp.ContentLength
+	} else if resp.StatusCode != http.StatusPartialContent {
+		// The range is ignored, skip to the position.
+		if _, err = io.CopyN(ioutil.Discard, resp.Body, r.offset); err != nil {
+			resp.Body.Close()
+			return err
+		}
+	}
+	r.body, r.pos = resp.Body, r.offset
+	return nil
+}
+
+func (r *s3Reader) Read(p []byte) (int, error) {
+	if r.size >= 0 && r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil || r.pos != r.offset {
+		if r.body != nil {
+			r.body.Close()
+			r.body = nil
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.body.Read(p)
+	r.pos += int64(n)
+	r.offset = r.pos
+	return n, err
+}
+
+func (r *s3Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		if r.size < 0 {
+			size, err := r.store.stat(r.path)
+			if err != nil {
+				return r.offset, err
+			}
+			r.size = size
+		}
+		offset += r.size
+	}
+	if offset < 0 {
+		return r.offset, fmt.Errorf("Negative position %v", offset)
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *s3Reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+func (s *S3Store) Stat(hash string, version byte) (int64, error) {
+	return s.stat(s.objectPath(hash, version))
+}
+
+func (s *S3Store) stat(path string) (int64, error) {
+	resp, err := s.do(http.MethodHead, path, nil, nil, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+func (s *S3Store) Delete(hash string, version byte) error {
+	resp, err := s.do(http.MethodDelete, s.objectPath(hash, version), nil, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3Store) List(walkFunc func(hash string, version byte, size int64, modTime time.Time) error) error {
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("max-keys", s3ListMaxKeys)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(http.MethodGet, "/"+s.Bucket, query, nil, nil, 0)
+		if err != nil {
+			return err
+		}
+		result := &s3ListResult{}
+		err = xml.NewDecoder(resp.Body).Decode(result)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, obj := range result.Contents {
+			hash, version := utils.GetHashFromKey(obj.Key)
+			if err = walkFunc(hash, version, obj.Size, obj.LastModified); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3Store) do(method, path string, query url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	u := *s.Endpoint
+	u.Path = path
+	u.RawQuery = canonicalQuery(query)
+
+	if size == 0 {
+		body = nil
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	payloadHash := sha256Hex(nil)
+	if body != nil {
+		payloadHash = s3UnsignedPayload
+	}
+	s.sign(req, payloadHash, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, &os.PathError{Op: strings.ToLower(method), Path: path, Err: os.ErrNotExist}
+	}
+	if resp.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("S3 %v %v: %v %v", method, path, resp.Status, string(message))
+	}
+	return resp, nil
+}
+
+// sign adds AWS Signature Version 4 headers to the request.
+func (s *S3Store) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format(s3TimeFormat)
+	date := now.Format(s3DateFormat)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL.Path),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, s.Region, s3Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, s3Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set(
+		"Authorization",
+		fmt.Sprintf(
+			"%v Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+			s3Algorithm, s.AccessKey, scope, signedHeaders, signature,
+		),
+	)
+}
+
+func canonicalURI(path string) string {
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		segments[i] = s3Escape(seg)
+	}
+	return strings.Join(segments, "/")
+}
+
+func canonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape escapes everything except the unreserved characters as required by SigV4.
+func s3Escape(s string) string {
+	buf := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			buf.WriteByte(c)
+		} else {
+			buf.WriteString(fmt.Sprintf("%%%02X", c))
+		}
+	}
+	return buf.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
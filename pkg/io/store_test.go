@@ -0,0 +1,234 @@
+package io
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kuberlab/pluk/pkg/types"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+// fakeS3 is a minimal in-memory stand-in for an S3-compatible server.
+type fakeS3 struct {
+	lock    sync.Mutex
+	bucket  string
+	objects map[string][]byte
+	ranges  []string
+}
+
+type fakeS3Content struct {
+	Key  string `xml:"Key"`
+	Size int64  `xml:"Size"`
+}
+
+type fakeS3List struct {
+	XMLName  xml.Name        `xml:"ListBucketResult"`
+	Contents []fakeS3Content `xml:"Contents"`
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), s3Algorithm+" Credential=access/") ||
+		r.Header.Get("X-Amz-Date") == "" ||
+		r.Header.Get("X-Amz-Content-Sha256") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+s.bucket), "/")
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if key == "" && r.Method == http.MethodGet {
+		keys := make([]string, 0)
+		for k := range s.objects {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		result := fakeS3List{}
+		for _, k := range keys {
+			result.Contents = append(result.Contents, fakeS3Content{Key: k, Size: int64(len(s.objects[k]))})
+		}
+		xml.NewEncoder(w).Encode(result)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		if r.ContentLength < 0 {
+			w.WriteHeader(http.StatusLengthRequired)
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		s.objects[key] = data
+	case http.MethodGet, http.MethodHead:
+		data, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Range") != "" {
+			s.ranges = append(s.ranges, r.Header.Get("Range"))
+		}
+		http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(data))
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestS3Store(t *testing.T) {
+	fake := &fakeS3{bucket: "chunks", objects: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	s, err := NewS3Store(server.URL, "chunks", "", "access", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := "0123456789abcdef0123456789abcdef"
+	data := []byte("chunk data")
+
+	n, err := s.Put(hash, 2, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(int64(len(data)), n, t)
+	utils.Assert(true, fake.objects[utils.GetHashedKey(hash, 2)] != nil, t)
+
+	size, err := s.Stat(hash, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(int64(len(data)), size, t)
+
+	reader, err := s.Get(hash, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(reader)
+	reader.Close()
+	utils.Assert(string(data), string(got), t)
+
+	// Data of unknown length is uploaded with its length as well.
+	n, err = s.Put(hash, 3, io.MultiReader(bytes.NewBufferString("chunk"), bytes.NewBufferString(" data")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(int64(len(data)), n, t)
+	utils.Assert(string(data), string(fake.objects[utils.GetHashedKey(hash, 3)]), t)
+	if err = s.Delete(hash, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// Seeking requests only the rest of the object.
+	reader, err = s.Get(hash, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	end, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(int64(len(data)), end, t)
+	if _, err = reader.Seek(6, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = ioutil.ReadAll(reader)
+	reader.Close()
+	utils.Assert("data", string(got), t)
+
+	var listed []string
+	err = s.List(func(h string, version byte, size int64, modTime time.Time) error {
+		utils.Assert(byte(2), version, t)
+		utils.Assert(int64(len(data)), size, t)
+		listed = append(listed, h)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert([]string{hash}, listed, t)
+
+	if err = s.Delete(hash, 2); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Stat(hash, 2)
+	utils.Assert(true, os.IsNotExist(err), t)
+	_, err = s.Get(hash, 2)
+	utils.Assert(true, os.IsNotExist(err), t)
+}
+
+type failingReader struct {
+	data []byte
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestLocalStoreInterruptedPut(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pluk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldDir := utils.DataDirValue
+	utils.DataDirValue = dir
+	defer func() { utils.DataDirValue = oldDir }()
+
+	s := NewLocalStore()
+	hash := CalcHash([]byte("chunk data"), types.ChunkVersion)
+
+	_, err = s.Put(hash, 2, &failingReader{data: []byte("chunk")})
+	utils.Assert(true, err != nil, t)
+
+	_, err = s.Stat(hash, 2)
+	utils.Assert(true, os.IsNotExist(err), t)
+	files, _ := ioutil.ReadDir(filepath.Dir(utils.GetHashedFilename(hash, 2)))
+	utils.Assert(0, len(files), t)
+
+	n, err := s.Put(hash, 2, io.MultiReader(bytes.NewBufferString("chunk"), bytes.NewBufferString(" data")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(int64(10), n, t)
+	size, err := s.Stat(hash, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(int64(10), size, t)
+}
+
+func TestInitStoreUnknown(t *testing.T) {
+	SetStore(nil)
+	defer SetStore(nil)
+	os.Setenv("CHUNK_STORE", "unknown")
+	defer os.Unsetenv("CHUNK_STORE")
+
+	// Chunks must not go to the local store instead.
+	utils.Assert(true, InitStore() != nil, t)
+	utils.Assert(true, store == nil, t)
+
+	os.Setenv("CHUNK_STORE", "local")
+	utils.Assert(nil, InitStore(), t)
+	_, ok := Store().(*LocalStore)
+	utils.Assert(true, ok, t)
+}
@@ -0,0 +1,200 @@
+package io
+
+import (
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+// filePutter is implemented by stores which can take ownership
+// of an already written local file instead of copying it.
+type filePutter interface {
+	PutFile(hash string, version byte, path string) error
+}
+
+// TeeChunkReader serves a chunk downloaded from the master while writing it
+// to a temporary file under DATA_DIR. Data which is already downloaded
+// is read back from that file, so the reader is seekable and doesn't keep
+// the chunk in memory. When the download completes and the hash matches,
+// the file is moved to the chunk store if keep is set.
+type TeeChunkReader struct {
+	hash    string
+	version byte
+	keep    bool
+
+	src    io.ReadCloser
+	file   *os.File
+	hasher hash.Hash
+
+	written int64
+	offset  int64
+	done    bool
+	err     error
+}
+
+func NewTeeChunkReader(hash string, version byte, src io.ReadCloser, keep bool) (*TeeChunkReader, error) {
+	if err := os.MkdirAll(utils.DataDir(), os.ModePerm); err != nil {
+		return nil, err
+	}
+	file, err := ioutil.TempFile(utils.DataDir(), tempFilePrefix)
+	if err != nil {
+		return nil, err
+	}
+	return &TeeChunkReader{
+		hash:    hash,
+		version: version,
+		keep:    keep,
+		src:     src,
+		file:    file,
+		hasher:  NewHasher(version),
+	}, nil
+}
+
+func (r *TeeChunkReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.offset < r.written {
+		// Serve from already downloaded data.
+		if remain := r.written - r.offset; int64(len(p)) > remain {
+			p = p[:remain]
+		}
+		n, err := r.file.ReadAt(p, r.offset)
+		r.offset += int64(n)
+		if err == io.EOF {
+			err = nil
+		}
+		return n, err
+	}
+	if r.done {
+		return 0, io.EOF
+	}
+	return r.download(p)
+}
+
+func (r *TeeChunkReader) download(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	if n > 0 {
+		// Writes are always sequential: reads use ReadAt and don't move the file offset.
+		if _, werr := r.file.Write(p[:n]); werr != nil {
+			r.err = werr
+			return 0, werr
+		}
+		r.hasher.Write(p[:n])
+		r.written += int64(n)
+		r.offset += int64(n)
+	}
+	if err == io.EOF {
+		r.done = true
+		if n > 0 {
+			return n, nil
+		}
+		return 0, io.EOF
+	}
+	if err != nil {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *TeeChunkReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		// The size is known only when the download completes.
+		if err := r.drain(); err != nil {
+			return r.offset, err
+		}
+		offset += r.written
+	default:
+		return r.offset, errors.New("TeeChunkReader: unsupported whence")
+	}
+	if offset < 0 {
+		return r.offset, errors.New("TeeChunkReader: negative position")
+	}
+	// Download up to the requested position.
+	r.offset = r.written
+	buf := make([]byte, 32*1024)
+	for r.written < offset && !r.done && r.err == nil {
+		if remain := offset - r.written; remain < int64(len(buf)) {
+			buf = buf[:remain]
+		}
+		if _, err := r.download(buf); err != nil && err != io.EOF {
+			return r.offset, err
+		}
+	}
+	r.offset = offset
+	return r.offset, nil
+}
+
+// drain downloads the rest of the chunk keeping the current position.
+func (r *TeeChunkReader) drain() error {
+	if r.done || r.err != nil {
+		return r.err
+	}
+	offset := r.offset
+	r.offset = r.written
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		r.err = err
+	}
+	r.offset = offset
+	return r.err
+}
+
+// Close finishes the download, so the chunk is cached even if
+// it was read partially, and releases the temporary file.
+func (r *TeeChunkReader) Close() error {
+	r.drain()
+	r.src.Close()
+	r.file.Close()
+	defer os.Remove(r.file.Name())
+
+	if r.err != nil || !r.keep {
+		return nil
+	}
+	if sum := hex.EncodeToString(r.hasher.Sum(nil)); sum != r.hash {
+		logrus.Errorf("Chunk %v from master has wrong hash %v, not saving", r.hash, sum)
+		return nil
+	}
+	if err := putChunkFile(r.hash, r.version, r.file.Name()); err != nil {
+		logrus.Errorf("Could not save chunk: %v", err)
+		return nil
+	}
+	if cache := Cache(); cache != nil {
+		if size, err := Store().Stat(r.hash, r.version); err == nil {
+			cache.Add(r.hash, r.version, size)
+		}
+	}
+	return nil
+}
+
+// putChunkFile moves the verified chunk file to the store
+// or copies it if the store can not take the file.
+func putChunkFile(hash string, version byte, path string) error {
+	if putter, ok := Store().(filePutter); ok && !IsCompressed(version) {
+		return putter.PutFile(hash, version, path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if IsCompressed(version) {
+		if reader, err = compressChunk(reader); err != nil {
+			return err
+		}
+	}
+	_, err = Store().Put(hash, version, reader)
+	return err
+}
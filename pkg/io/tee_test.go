@@ -0,0 +1,89 @@
+package io
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/kuberlab/pluk/pkg/types"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+func TestTeeChunkReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pluk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldDir := utils.DataDirValue
+	utils.DataDirValue = dir
+	defer func() { utils.DataDirValue = oldDir }()
+	SetStore(NewLocalStore())
+	defer SetStore(nil)
+
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	hash := CalcHash(data, types.ChunkVersion)
+
+	r, err := NewTeeChunkReader(hash, 2, ioutil.NopCloser(bytes.NewReader(data)), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 10)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert("0123456789", string(buf), t)
+
+	// Seek forward beyond downloaded data and back.
+	if _, err = r.Seek(50005, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert("5678901234", string(buf), t)
+	if _, err = r.Seek(3, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert("3456789012", string(buf), t)
+
+	// Seek from the end downloads the whole chunk.
+	end, err := r.Seek(-10, io.SeekEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert(int64(len(data)-10), end, t)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	utils.Assert("0123456789", string(buf), t)
+
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	size, exists := CheckLocalChunk(hash, 2)
+	utils.Assert(true, exists, t)
+	utils.Assert(int64(len(data)), size, t)
+
+	// Chunk with wrong content is served but not saved.
+	wrongHash := CalcHash([]byte("other"), types.ChunkVersion)
+	r, err = NewTeeChunkReader(wrongHash, 2, ioutil.NopCloser(bytes.NewReader(data)), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, exists = CheckLocalChunk(wrongHash, 2)
+	utils.Assert(false, exists, t)
+
+	files, _ := ioutil.ReadDir(dir)
+	for _, f := range files {
+		utils.Assert(true, f.IsDir(), t)
+	}
+}
@@ -0,0 +1,42 @@
+package io
+
+import (
+	"github.com/kuberlab/pluk/pkg/types"
+)
+
+// chunkFlags returns the features of the chunk version.
+func chunkFlags(version byte) byte {
+	switch version {
+	case types.ChunkVersionCDC:
+		return types.ChunkFlagCDC
+	case types.ChunkVersionCompressed:
+		return types.ChunkFlagCompressed
+	case types.ChunkVersionSHA256:
+		return types.ChunkFlagSHA256
+	}
+	if version&types.ChunkVersionFlags != 0 {
+		return version &^ types.ChunkVersionFlags
+	}
+	return 0
+}
+
+// CombineChunkVersions returns the chunk version which has
+// the features of all given versions.
+func CombineChunkVersions(versions ...byte) byte {
+	var flags byte
+	for _, version := range versions {
+		flags |= chunkFlags(version)
+	}
+	// Versions of single features are known to older servers.
+	switch flags {
+	case 0:
+		return types.ChunkVersion
+	case types.ChunkFlagCDC:
+		return types.ChunkVersionCDC
+	case types.ChunkFlagCompressed:
+		return types.ChunkVersionCompressed
+	case types.ChunkFlagSHA256:
+		return types.ChunkVersionSHA256
+	}
+	return types.ChunkVersionFlags | flags
+}
@@ -0,0 +1,35 @@
+package io
+
+import (
+	"testing"
+
+	"github.com/kuberlab/pluk/pkg/types"
+	"github.com/kuberlab/pluk/pkg/utils"
+)
+
+func TestCombineChunkVersions(t *testing.T) {
+	utils.Assert(byte(types.ChunkVersion), CombineChunkVersions(types.ChunkVersion, types.ChunkVersion), t)
+	utils.Assert(byte(types.ChunkVersionCDC), CombineChunkVersions(types.ChunkVersionCDC, types.ChunkVersion), t)
+	utils.Assert(
+		byte(types.ChunkVersionCompressed),
+		CombineChunkVersions(types.ChunkVersion, types.ChunkVersionCompressed),
+		t,
+	)
+
+	version := CombineChunkVersions(types.ChunkVersionSHA256, types.ChunkVersionCompressed)
+	utils.Assert(byte(types.ChunkVersionFlags|types.ChunkFlagCompressed|types.ChunkFlagSHA256), version, t)
+	utils.Assert(true, IsCompressed(version), t)
+	utils.Assert(HashSHA256, HashAlgorithm(version), t)
+
+	version = CombineChunkVersions(version, types.ChunkVersionCDC)
+	utils.Assert(true, IsCompressed(version), t)
+	utils.Assert(HashSHA256, HashAlgorithm(version), t)
+	utils.Assert(false, IsCompressed(types.ChunkVersionCDC), t)
+	utils.Assert(HashSHA512, HashAlgorithm(types.ChunkVersionCompressed), t)
+
+	// Versions are kept in the path of the chunk.
+	hash := CalcHash([]byte("data"), version)
+	h, v := utils.GetHashFromKey(utils.GetHashedKey(hash, version))
+	utils.Assert(hash, h, t)
+	utils.Assert(version, v, t)
+}
@@ -1,6 +1,7 @@
 package plukclient
 
 import (
+	"fmt"
 	"io"
 	"net/http"
 	"strings"
@@ -205,6 +206,50 @@ func (c *MultiMasterClient) ListVersions(entityType, workspace, datasetName stri
 	return nil, err
 }
 
+func (c *MultiMasterClient) ListVersionAliases(entityType, workspace, name string) (res *types.VersionAliasList, err error) {
+	for _, cl := range c.baseClients {
+		res, err = cl.ListVersionAliases(entityType, workspace, name)
+		if err != nil {
+			continue
+		}
+		return res, err
+	}
+	return nil, err
+}
+
+func (c *MultiMasterClient) GetVersionAlias(entityType, workspace, name, alias string) (res *types.VersionAlias, err error) {
+	for _, cl := range c.baseClients {
+		res, err = cl.GetVersionAlias(entityType, workspace, name, alias)
+		if err != nil {
+			continue
+		}
+		return res, err
+	}
+	return nil, err
+}
+
+func (c *MultiMasterClient) SetVersionAlias(entityType, workspace, name, alias, version string) (res *types.VersionAlias, err error) {
+	for _, cl := range c.baseClients {
+		res, err = cl.SetVersionAlias(entityType, workspace, name, alias, version)
+		if err != nil {
+			continue
+		}
+		return res, err
+	}
+	return nil, err
+}
+
+func (c *MultiMasterClient) DeleteVersionAlias(entityType, workspace, name, alias string) (err error) {
+	for _, cl := range c.baseClients {
+		err = cl.DeleteVersionAlias(entityType, workspace, name, alias)
+		if err != nil {
+			continue
+		}
+		return err
+	}
+	return err
+}
+
 func (c *MultiMasterClient) DownloadChunk(hash string, version byte) (reader io.ReadCloser, err error) {
 	for _, cl := range c.baseClients {
 		reader, err = cl.DownloadChunk(hash, version)
@@ -244,12 +289,26 @@ func (c *MultiMasterClient) DownloadEntity(entityType, workspace, name, version
 	return err
 }
 
-func (c *MultiMasterClient) EntityTarSize(entityType, workspace, name, version string) (res int64, err error) {
+func (c *MultiMasterClient) DownloadEntityArchive(entityType, workspace, name, version string, opts types.ArchiveOpts, w io.Writer) (err error) {
+	for _, cl := range c.baseClients {
+		if err != nil {
+			return err
+		}
+		err = cl.DownloadEntityArchive(entityType, workspace, name, version, opts, w)
+		if err != nil {
+			continue
+		}
+		return err
+	}
+	return err
+}
+
+func (c *MultiMasterClient) EntityTarSize(entityType, workspace, name, version string, opts types.ArchiveOpts) (res int64, err error) {
 	for _, cl := range c.baseClients {
 		if err != nil {
 			return 0, err
 		}
-		res, err = cl.EntityTarSize(entityType, workspace, name, version)
+		res, err = cl.EntityTarSize(entityType, workspace, name, version, opts)
 		if err != nil {
 			continue
 		}
@@ -258,6 +317,17 @@ func (c *MultiMasterClient) EntityTarSize(entityType, workspace, name, version s
 	return res, err
 }
 
+func (c *MultiMasterClient) CherryPick(entityType, workspace, name, version string, pick types.CherryPick) (res *types.FileOperationResult, err error) {
+	for _, cl := range c.baseClients {
+		res, err = cl.CherryPick(entityType, workspace, name, version, pick)
+		if err != nil {
+			continue
+		}
+		return res, err
+	}
+	return nil, err
+}
+
 func (c *MultiMasterClient) SaveChunk(hash string, data []byte, version byte) (err error) {
 	for i, cl := range c.baseClients {
 		if err != nil {
@@ -300,6 +370,72 @@ func (c *MultiMasterClient) UploadFile(entityType, workspace, entityName, versio
 	return nil, err
 }
 
+// Upload session lives on a single master, so the first one is used.
+func (c *MultiMasterClient) sessionClient() (plukio.PlukClient, error) {
+	if len(c.baseClients) == 0 {
+		return nil, fmt.Errorf("No masters configured")
+	}
+	return c.baseClients[0], nil
+}
+
+func (c *MultiMasterClient) CreateUploadSession(entityType, workspace, entityName, version, fileName string, size int64) (*types.UploadSession, error) {
+	cl, err := c.sessionClient()
+	if err != nil {
+		return nil, err
+	}
+	return cl.CreateUploadSession(entityType, workspace, entityName, version, fileName, size)
+}
+
+func (c *MultiMasterClient) GetUploadSession(entityType, workspace, entityName, version, id string) (*types.UploadSession, error) {
+	cl, err := c.sessionClient()
+	if err != nil {
+		return nil, err
+	}
+	return cl.GetUploadSession(entityType, workspace, entityName, version, id)
+}
+
+func (c *MultiMasterClient) UploadPart(entityType, workspace, entityName, version, id string, part uint, body io.Reader) (*types.UploadPart, error) {
+	cl, err := c.sessionClient()
+	if err != nil {
+		return nil, err
+	}
+	return cl.UploadPart(entityType, workspace, entityName, version, id, part, body)
+}
+
+func (c *MultiMasterClient) CompleteUploadSession(entityType, workspace, entityName, version, id string) (*types.HashedFile, error) {
+	cl, err := c.sessionClient()
+	if err != nil {
+		return nil, err
+	}
+	return cl.CompleteUploadSession(entityType, workspace, entityName, version, id)
+}
+
+func (c *MultiMasterClient) AbortUploadSession(entityType, workspace, entityName, version, id string) error {
+	cl, err := c.sessionClient()
+	if err != nil {
+		return err
+	}
+	return cl.AbortUploadSession(entityType, workspace, entityName, version, id)
+}
+
+// Push session pins chunks on the first master only,
+// others rely on the GC grace period.
+func (c *MultiMasterClient) CreatePushSession(entityType, workspace, entityName, version string) (*types.PushSession, error) {
+	cl, err := c.sessionClient()
+	if err != nil {
+		return nil, err
+	}
+	return cl.CreatePushSession(entityType, workspace, entityName, version)
+}
+
+func (c *MultiMasterClient) ClosePushSession(entityType, workspace, entityName, version, id string) error {
+	cl, err := c.sessionClient()
+	if err != nil {
+		return err
+	}
+	return cl.ClosePushSession(entityType, workspace, entityName, version, id)
+}
+
 func (c *MultiMasterClient) DownloadFile(entityType, workspace, entityName, version, fileName string) (res io.ReadCloser, err error) {
 	for i, cl := range c.baseClients {
 		if err != nil {
@@ -357,6 +493,20 @@ func (c *MultiMasterClient) CheckChunk(hash string, version byte) (res *types.Ch
 	return nil, err
 }
 
+func (c *MultiMasterClient) CheckChunks(hashes []types.Hash) (res []types.Hash, err error) {
+	for _, cl := range c.baseClients {
+		if err != nil {
+			return nil, err
+		}
+		res, err = cl.CheckChunks(hashes)
+		if err != nil {
+			continue
+		}
+		return res, err
+	}
+	return nil, err
+}
+
 func (c *MultiMasterClient) DeleteEntity(entityType, workspace, name string, force bool) (err error) {
 	for _, cl := range c.baseClients {
 		if err != nil {
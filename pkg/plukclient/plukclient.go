@@ -36,6 +36,8 @@ type Client struct {
 	auth *AuthOpts
 	conn *websocket.Conn
 	ws   *types.WebsocketClient
+	// ID of the open push session which is sent with all requests.
+	pushSession string
 }
 
 type AuthOpts struct {
@@ -169,6 +171,9 @@ func (c *Client) setNeededHeaders(req *http.Request) {
 	if c.UserAgent != "" {
 		req.Header.Set("User-Agent", c.UserAgent)
 	}
+	if c.pushSession != "" {
+		req.Header.Set("X-Push-Session", c.pushSession)
+	}
 }
 
 func (c *Client) authHeaders() http.Header {
@@ -433,6 +438,99 @@ func (c *Client) UploadFile(entityType, workspace, entityName, version, fileName
 	return &f, err
 }
 
+func (c *Client) CreateUploadSession(entityType, workspace, entityName, version, fileName string, size int64) (*types.UploadSession, error) {
+	u := fmt.Sprintf(
+		"/%v/%v/%v/versions/%v/sessions?path=%v&size=%v",
+		entityType, workspace, entityName, version, url.QueryEscape(fileName), size,
+	)
+	req, err := c.NewRequest("POST", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	session := &types.UploadSession{}
+	_, err = c.Do(req, session)
+	return session, err
+}
+
+func (c *Client) GetUploadSession(entityType, workspace, entityName, version, id string) (*types.UploadSession, error) {
+	u := fmt.Sprintf("/%v/%v/%v/versions/%v/sessions/%v", entityType, workspace, entityName, version, id)
+	req, err := c.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	session := &types.UploadSession{}
+	_, err = c.Do(req, session)
+	return session, err
+}
+
+func (c *Client) UploadPart(entityType, workspace, entityName, version, id string, part uint, body io.Reader) (*types.UploadPart, error) {
+	u := fmt.Sprintf(
+		"/%v/%v/%v/versions/%v/sessions/%v/parts/%v",
+		entityType, workspace, entityName, version, id, part,
+	)
+	u = strings.TrimSuffix(c.BaseURL.String(), "/") + u
+
+	req, err := http.NewRequest("PUT", u, body)
+	if err != nil {
+		return nil, err
+	}
+	c.setNeededHeaders(req)
+
+	res := &types.UploadPart{}
+	_, err = c.Do(req, res)
+	return res, err
+}
+
+func (c *Client) CompleteUploadSession(entityType, workspace, entityName, version, id string) (*types.HashedFile, error) {
+	u := fmt.Sprintf("/%v/%v/%v/versions/%v/sessions/%v/complete", entityType, workspace, entityName, version, id)
+	req, err := c.NewRequest("POST", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	f := &types.HashedFile{}
+	_, err = c.Do(req, f)
+	return f, err
+}
+
+func (c *Client) AbortUploadSession(entityType, workspace, entityName, version, id string) error {
+	u := fmt.Sprintf("/%v/%v/%v/versions/%v/sessions/%v", entityType, workspace, entityName, version, id)
+	req, err := c.NewRequest("DELETE", u, nil)
+	if err != nil {
+		return err
+	}
+	_, err = c.Do(req, nil)
+	return err
+}
+
+// CreatePushSession opens the push session. Chunks checked and uploaded by
+// the client are pinned to it until ClosePushSession is called.
+func (c *Client) CreatePushSession(entityType, workspace, entityName, version string) (*types.PushSession, error) {
+	u := fmt.Sprintf("/%v/%v/%v/versions/%v/push-sessions", entityType, workspace, entityName, version)
+	req, err := c.NewRequest("POST", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	session := &types.PushSession{}
+	if _, err = c.Do(req, session); err != nil {
+		return nil, err
+	}
+	c.pushSession = session.ID
+	return session, nil
+}
+
+func (c *Client) ClosePushSession(entityType, workspace, entityName, version, id string) error {
+	u := fmt.Sprintf("/%v/%v/%v/versions/%v/push-sessions/%v", entityType, workspace, entityName, version, id)
+	req, err := c.NewRequest("DELETE", u, nil)
+	if err != nil {
+		return err
+	}
+	if c.pushSession == id {
+		c.pushSession = ""
+	}
+	_, err = c.Do(req, nil)
+	return err
+}
+
 func (c *Client) DownloadFile(entityType, workspace, entityName, version, fileName string) (io.ReadCloser, error) {
 	u := fmt.Sprintf(
 		"/%v/%v/%v/versions/%v/raw/%v",
@@ -487,6 +585,22 @@ func (c *Client) CheckChunk(hash string, version byte) (*types.ChunkCheck, error
 	return res, err
 }
 
+// CheckChunks returns the chunks which need to be uploaded.
+func (c *Client) CheckChunks(hashes []types.Hash) ([]types.Hash, error) {
+	req, err := c.NewRequest("POST", "/chunks/check", hashes)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]types.Hash, 0)
+	_, err = c.Do(req, &res)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res, err
+}
+
 func (c *Client) DownloadChunk(hash string, version byte) (io.ReadCloser, error) {
 	u := fmt.Sprintf("/chunks/%v/download/%v", hash, version)
 
@@ -604,7 +718,13 @@ func (c *Client) Close() error {
 }
 
 func (c *Client) DownloadEntity(entityType, workspace, name, version string, w io.Writer) error {
-	u := fmt.Sprintf("/%v/%v/%v/versions/%v", entityType, workspace, name, version)
+	return c.DownloadEntityArchive(entityType, workspace, name, version, types.ArchiveOpts{Format: "tar"}, w)
+}
+
+// DownloadEntityArchive downloads the version as tar, tar.gz, tar.zst or zip archive
+// containing files selected by opts.
+func (c *Client) DownloadEntityArchive(entityType, workspace, name, version string, opts types.ArchiveOpts, w io.Writer) error {
+	u := fmt.Sprintf("/%v/%v/%v/versions/%v?%v", entityType, workspace, name, version, opts.Query().Encode())
 
 	req, err := c.NewRequest("GET", u, nil)
 	if err != nil {
@@ -618,8 +738,8 @@ func (c *Client) DownloadEntity(entityType, workspace, name, version string, w i
 	return nil
 }
 
-func (c *Client) EntityTarSize(entityType, workspace, name, version string) (int64, error) {
-	u := fmt.Sprintf("/%v/%v/%v/versions/%v/tarsize", entityType, workspace, name, version)
+func (c *Client) EntityTarSize(entityType, workspace, name, version string, opts types.ArchiveOpts) (int64, error) {
+	u := fmt.Sprintf("/%v/%v/%v/versions/%v/tarsize?%v", entityType, workspace, name, version, opts.Query().Encode())
 
 	req, err := c.NewRequest("GET", u, nil)
 	if err != nil {
@@ -635,6 +755,24 @@ func (c *Client) EntityTarSize(entityType, workspace, name, version string) (int
 	return strconv.ParseInt(out, 10, 64)
 }
 
+// CherryPick copies paths of the source version into the editing version.
+func (c *Client) CherryPick(entityType, workspace, name, version string, pick types.CherryPick) (*types.FileOperationResult, error) {
+	u := fmt.Sprintf("/%v/%v/%v/versions/%v/cherry-pick", entityType, workspace, name, version)
+
+	req, err := c.NewRequest("POST", u, &pick)
+	if err != nil {
+		return nil, err
+	}
+	res := new(types.FileOperationResult)
+	_, err = c.Do(req, res)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res, err
+}
+
 func (c *Client) DeleteEntity(entityType, workspace, name string, force bool) error {
 	u := fmt.Sprintf("/%v/%v/%v", entityType, workspace, name)
 
@@ -663,6 +801,69 @@ func (c *Client) DeleteVersion(entityType, workspace, name, version string) erro
 	return err
 }
 
+func (c *Client) ListVersionAliases(entityType, workspace, name string) (*types.VersionAliasList, error) {
+	u := fmt.Sprintf("/%v/%v/%v/aliases", entityType, workspace, name)
+
+	req, err := c.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	res := new(types.VersionAliasList)
+	_, err = c.Do(req, res)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res, err
+}
+
+func (c *Client) GetVersionAlias(entityType, workspace, name, alias string) (*types.VersionAlias, error) {
+	u := fmt.Sprintf("/%v/%v/%v/aliases/%v", entityType, workspace, name, alias)
+
+	req, err := c.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	res := new(types.VersionAlias)
+	_, err = c.Do(req, res)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res, err
+}
+
+func (c *Client) SetVersionAlias(entityType, workspace, name, alias, version string) (*types.VersionAlias, error) {
+	u := fmt.Sprintf("/%v/%v/%v/aliases/%v", entityType, workspace, name, alias)
+
+	req, err := c.NewRequest("PUT", u, &types.VersionAlias{Version: version})
+	if err != nil {
+		return nil, err
+	}
+	res := new(types.VersionAlias)
+	_, err = c.Do(req, res)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res, err
+}
+
+func (c *Client) DeleteVersionAlias(entityType, workspace, name, alias string) error {
+	u := fmt.Sprintf("/%v/%v/%v/aliases/%v", entityType, workspace, name, alias)
+
+	req, err := c.NewRequest("DELETE", u, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Do(req, nil)
+	return err
+}
+
 func (c *Client) WebdavAuth(user, pass, path string) (bool, error) {
 	u := path
 
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"net/url"
 	"os"
 	"sync"
 	"time"
@@ -14,6 +15,22 @@ import (
 
 const (
 	ChunkVersion = 2
+	// ChunkVersionCDC is used for chunks cut at content-defined boundaries.
+	ChunkVersionCDC = 3
+	// ChunkVersionCompressed is used for chunks stored compressed.
+	// The hash is computed over the uncompressed content.
+	ChunkVersionCompressed = 4
+	// ChunkVersionSHA256 is used for chunks hashed with SHA256 instead of SHA512.
+	ChunkVersionSHA256 = 5
+	// Chunks which combine the features above have ChunkVersionFlags set
+	// together with the flags of their features. Chunks with a single feature
+	// keep its own version.
+	ChunkVersionFlags   = 0x80
+	ChunkFlagCDC        = 0x01
+	ChunkFlagCompressed = 0x02
+	ChunkFlagSHA256     = 0x04
+	// MaxChunkSize limits the size of chunk content.
+	MaxChunkSize = 64 * 1024 * 1024
 )
 
 type Workspace dealerclient.Workspace
@@ -35,9 +52,11 @@ func (d DataSetList) Swap(i, j int) {
 }
 
 type Dataset struct {
-	Workspace string `json:"workspace"`
-	Name      string `json:"name"`
-	DType     string `json:"type"`
+	Workspace   string            `json:"workspace"`
+	Name        string            `json:"name"`
+	DType       string            `json:"type"`
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
 }
 
 func (d *Dataset) Type() string {
@@ -84,12 +103,67 @@ type Version struct {
 	Name      string     `json:"name"`
 	DType     string     `json:"type,omitempty"`
 	Editing   bool       `json:"editing"`
+
+	Description string `json:"description,omitempty"`
 }
 
 func (dv *Version) Type() string {
 	return "dataset_version"
 }
 
+// Metadata describes a dataset or a version.
+type Metadata struct {
+	Description string            `json:"description"`
+	Labels      map[string]string `json:"labels"`
+}
+
+// FileOperation moves or copies a file or a dir inside the version.
+type FileOperation struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// FromVersion is the version to copy from, the same version by default.
+	FromVersion string `json:"from_version,omitempty"`
+	// Conflict is the policy for existing files at the destination:
+	// "fail" (default), "skip" or "overwrite".
+	Conflict string `json:"conflict,omitempty"`
+}
+
+// CherryPick copies paths of the source version into the editing version.
+// Empty source workspace, name and type mean the same as of the target.
+type CherryPick struct {
+	Workspace string   `json:"workspace,omitempty"`
+	Name      string   `json:"name,omitempty"`
+	DType     string   `json:"type,omitempty"`
+	Version   string   `json:"version"`
+	Paths     []string `json:"paths"`
+	Conflict  string   `json:"conflict,omitempty"`
+}
+
+type FileOperationResult struct {
+	Files   int   `json:"files"`
+	Size    int64 `json:"size"`
+	Skipped int   `json:"skipped"`
+}
+
+// VersionAlias is a mutable name pointing to a version, such as "latest".
+type VersionAlias struct {
+	Alias     string `json:"alias"`
+	Version   string `json:"version"`
+	Workspace string `json:"workspace"`
+	Name      string `json:"name"`
+	DType     string `json:"type,omitempty"`
+	// Deleted is set in the message about alias deletion.
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+func (a *VersionAlias) Type() string {
+	return "version_alias"
+}
+
+type VersionAliasList struct {
+	Aliases []VersionAlias `json:"aliases"`
+}
+
 type SaveOpts struct {
 	Comment string
 	Create  bool
@@ -97,6 +171,31 @@ type SaveOpts struct {
 	Editing bool
 }
 
+// ArchiveOpts selects the archive format and the subset of files to download.
+type ArchiveOpts struct {
+	Format   string
+	Prefixes []string
+	Include  []string
+	Exclude  []string
+}
+
+func (o ArchiveOpts) Query() url.Values {
+	q := url.Values{}
+	if o.Format != "" {
+		q.Set("format", o.Format)
+	}
+	for _, p := range o.Prefixes {
+		q.Add("prefix", p)
+	}
+	for _, p := range o.Include {
+		q.Add("include", p)
+	}
+	for _, p := range o.Exclude {
+		q.Add("exclude", p)
+	}
+	return q
+}
+
 type FileStructure struct {
 	Files []*HashedFile `json:"files"`
 }
@@ -109,6 +208,161 @@ type HashedFile struct {
 	ModeTime time.Time   `json:"mode_time"`
 }
 
+// UploadSession is a resumable upload of a single file sent in parts.
+// Size and PartCount are expected by the session, -1 and 0 if unknown.
+type UploadSession struct {
+	ID        string       `json:"id"`
+	Workspace string       `json:"workspace"`
+	Name      string       `json:"name"`
+	Version   string       `json:"version"`
+	Path      string       `json:"path"`
+	Size      int64        `json:"size"`
+	PartCount uint         `json:"part_count"`
+	Parts     []UploadPart `json:"parts"`
+}
+
+type UploadPart struct {
+	Number uint  `json:"number"`
+	Size   int64 `json:"size"`
+}
+
+// PushSession pins chunks uploaded during the push until it is closed.
+type PushSession struct {
+	ID        string `json:"id"`
+	Workspace string `json:"workspace"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+}
+
+// VersionDiff describes changes between two versions.
+type VersionDiff struct {
+	From     string     `json:"from"`
+	To       string     `json:"to"`
+	Added    []FileDiff `json:"added"`
+	Removed  []FileDiff `json:"removed"`
+	Modified []FileDiff `json:"modified"`
+	Moved    []FileDiff `json:"moved"`
+	Stats    DiffStats  `json:"stats"`
+}
+
+// FileDiff is a changed file. OldPath and OldSize are set
+// for moved and modified files respectively.
+type FileDiff struct {
+	Path    string `json:"path"`
+	OldPath string `json:"old_path,omitempty"`
+	Size    int64  `json:"size"`
+	OldSize int64  `json:"old_size,omitempty"`
+}
+
+type DiffStats struct {
+	Added         int   `json:"added"`
+	Removed       int   `json:"removed"`
+	Modified      int   `json:"modified"`
+	Moved         int   `json:"moved"`
+	Unchanged     int   `json:"unchanged"`
+	AddedBytes    int64 `json:"added_bytes"`
+	RemovedBytes  int64 `json:"removed_bytes"`
+	ModifiedBytes int64 `json:"modified_bytes"`
+	MovedBytes    int64 `json:"moved_bytes"`
+	// SizeDelta is the difference of total version sizes.
+	SizeDelta int64 `json:"size_delta"`
+}
+
+// GCReport describes a run of garbage collection or of unused chunks clearing.
+// Files and chunks are counted, Hashes contains at most GCReportHashes
+// hashes of deleted chunks.
+type GCReport struct {
+	Kind       string     `json:"kind"`
+	DryRun     bool       `json:"dry_run"`
+	Running    bool       `json:"running"`
+	Phase      string     `json:"phase"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Datasets   []GCItem   `json:"datasets"`
+	Versions   []GCItem   `json:"versions"`
+	Sessions   int64      `json:"sessions"`
+	Files      int64      `json:"files"`
+	Chunks     int64      `json:"chunks"`
+	Size       int64      `json:"size"`
+	Hashes     []string   `json:"hashes"`
+	Errors     []string   `json:"errors"`
+}
+
+const GCReportHashes = 1000
+
+// GCItem is a deleted dataset or version.
+type GCItem struct {
+	Type      string `json:"type"`
+	Workspace string `json:"workspace"`
+	Name      string `json:"name"`
+	Version   string `json:"version,omitempty"`
+	Files     int64  `json:"files"`
+}
+
+// GCStatus contains the current or the last runs, dry runs are kept apart.
+type GCStatus struct {
+	GC                *GCReport `json:"gc"`
+	ClearChunks       *GCReport `json:"clear_chunks"`
+	GCDryRun          *GCReport `json:"gc_dry_run"`
+	ClearChunksDryRun *GCReport `json:"clear_chunks_dry_run"`
+}
+
+// FsckReport describes inconsistencies between the DB and the chunk store.
+// Every list contains at most FsckReportItems entries, counts are complete.
+type FsckReport struct {
+	Repair     bool       `json:"repair"`
+	Verify     bool       `json:"verify"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Chunks is the number of checked chunks.
+	Chunks int64 `json:"chunks"`
+	// Issues is the number of found inconsistencies.
+	Issues   int64 `json:"issues"`
+	Repaired int64 `json:"repaired"`
+
+	MissingChunks    []FsckChunk   `json:"missing_chunks"`
+	WrongSizeChunks  []FsckChunk   `json:"wrong_size_chunks"`
+	CorruptChunks    []FsckChunk   `json:"corrupt_chunks"`
+	WrongRefCounts   int64         `json:"wrong_ref_counts"`
+	OrphanFileChunks int64         `json:"orphan_file_chunks"`
+	BrokenFiles      []FsckFile    `json:"broken_files"`
+	Versions         []FsckVersion `json:"versions"`
+	Errors           []string      `json:"errors"`
+}
+
+const FsckReportItems = 1000
+
+// FsckChunk is a chunk which data is absent or differs from the DB.
+type FsckChunk struct {
+	Hash    string `json:"hash"`
+	Version byte   `json:"version"`
+	Size    int64  `json:"size"`
+	// StoreSize is the content size of the chunk in the store.
+	StoreSize int64 `json:"store_size"`
+}
+
+// FsckFile is a file which chunk indexes have gaps or duplicates.
+type FsckFile struct {
+	Type      string `json:"type"`
+	Workspace string `json:"workspace"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+}
+
+// FsckVersion is a version which size or file count differ from its files.
+type FsckVersion struct {
+	Type            string `json:"type"`
+	Workspace       string `json:"workspace"`
+	Name            string `json:"name"`
+	Version         string `json:"version"`
+	Size            int64  `json:"size"`
+	FileCount       int64  `json:"file_count"`
+	ActualSize      int64  `json:"actual_size"`
+	ActualFileCount int64  `json:"actual_file_count"`
+}
+
 type Hash struct {
 	Hash    string `json:"hash"`
 	Size    int64  `json:"size"`
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	labelKeyRegex   = regexp.MustCompile("^[a-zA-Z0-9]([-a-zA-Z0-9_./]{0,61}[a-zA-Z0-9])?$")
+	labelValueRegex = regexp.MustCompile("^[-a-zA-Z0-9_.:/]{0,255}$")
+)
+
+func CheckLabels(labels map[string]string) error {
+	for k, v := range labels {
+		if !labelKeyRegex.MatchString(k) {
+			return fmt.Errorf("Invalid label key %q: must be up to 63 alphanumeric characters, '-', '_', '.' or '/'", k)
+		}
+		if !labelValueRegex.MatchString(v) {
+			return fmt.Errorf("Invalid value %q of label %v: must be up to 255 alphanumeric characters, '-', '_', '.', ':' or '/'", v, k)
+		}
+	}
+	return nil
+}
+
+type labelRequirement struct {
+	key      string
+	value    string
+	operator string
+}
+
+// LabelSelector filters labeled objects. All requirements must match.
+type LabelSelector []labelRequirement
+
+// ParseLabelSelector parses comma-separated requirements:
+// "key=value", "key!=value", "key" (label exists) and "!key" (label doesn't exist).
+func ParseLabelSelector(selector string) (LabelSelector, error) {
+	result := make(LabelSelector, 0)
+	for _, raw := range strings.Split(selector, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		var req labelRequirement
+		switch {
+		case strings.Contains(raw, "!="):
+			parts := strings.SplitN(raw, "!=", 2)
+			req = labelRequirement{key: parts[0], value: parts[1], operator: "!="}
+		case strings.Contains(raw, "="):
+			parts := strings.SplitN(strings.Replace(raw, "==", "=", 1), "=", 2)
+			req = labelRequirement{key: parts[0], value: parts[1], operator: "="}
+		case strings.HasPrefix(raw, "!"):
+			req = labelRequirement{key: strings.TrimPrefix(raw, "!"), operator: "!"}
+		default:
+			req = labelRequirement{key: raw, operator: "exists"}
+		}
+		req.key = strings.TrimSpace(req.key)
+		req.value = strings.TrimSpace(req.value)
+		if err := CheckLabels(map[string]string{req.key: req.value}); err != nil {
+			return nil, fmt.Errorf("Invalid selector %q: %v", raw, err)
+		}
+		result = append(result, req)
+	}
+	return result, nil
+}
+
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, req := range s {
+		value, ok := labels[req.key]
+		switch req.operator {
+		case "=":
+			if !ok || value != req.value {
+				return false
+			}
+		case "!=":
+			if ok && value == req.value {
+				return false
+			}
+		case "!":
+			if ok {
+				return false
+			}
+		case "exists":
+			if !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
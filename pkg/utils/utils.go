@@ -1,10 +1,10 @@
 package utils
 
 import (
-	"crypto/sha512"
 	"fmt"
 	"os"
 	"reflect"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
@@ -31,17 +31,31 @@ const (
 	readConcurrencyVar   = "READ_CONCURRENCY"
 	uploadConcurrencyVar = "UPLOAD_CONCURRENCY"
 	dataVar              = "DATA_DIR"
+	chunkStoreVar        = "CHUNK_STORE"
+	chunkCacheSizeVar    = "CHUNK_CACHE_SIZE"
+	packChunkSizeVar     = "PACK_CHUNK_SIZE"
+	gcGracePeriodVar     = "GC_GRACE_PERIOD"
+	s3EndpointVar        = "S3_ENDPOINT"
+	s3BucketVar          = "S3_BUCKET"
+	s3RegionVar          = "S3_REGION"
+	s3AccessKeyVar       = "S3_ACCESS_KEY"
+	s3SecretKeyVar       = "S3_SECRET_KEY"
 	dbNameVar            = "DB_NAME"
 	dbHostVar            = "DB_HOST"
 	dbUserVar            = "DB_USER"
 	dbPassVar            = "DB_PASSWORD"
 	dbPortVar            = "DB_PORT"
+	dbMigrateVar         = "DB_MIGRATE"
 	MastersVar           = "MASTERS"
 	portVar              = "PLUK_HTTP_PORT"
 	PortGrpcVar          = "PLUK_GRPC_PORT"
 	defaultPort          = "8082"
 	defaultGrpcPort      = "8085"
 	defaultDataDir       = "/data"
+	defaultChunkStore    = "local"
+	defaultS3Region      = "us-east-1"
+	defaultPackChunkSize = 128 * 1024
+	defaultGCGracePeriod = time.Hour * 24
 	defaultDBName        = "/pluk/pluke.db"
 	ChunkDirLength       = 8
 )
@@ -90,6 +104,59 @@ func DataDir() string {
 	return dataDir
 }
 
+func ChunkStore() string {
+	return strings.ToLower(FromEnv(chunkStoreVar, defaultChunkStore))
+}
+
+// ChunkCacheSize returns the limit in bytes for chunks cached from master.
+// 0 means that cached chunks are never evicted.
+func ChunkCacheSize() int64 {
+	size, err := strconv.ParseInt(os.Getenv(chunkCacheSizeVar), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+// PackChunkSize returns the maximum size of chunk which is put to a pack file.
+func PackChunkSize() int64 {
+	size, err := strconv.ParseInt(os.Getenv(packChunkSizeVar), 10, 64)
+	if err != nil {
+		return defaultPackChunkSize
+	}
+	return size
+}
+
+// GCGracePeriod returns the age which chunks must reach before
+// they can be deleted as unused.
+func GCGracePeriod() time.Duration {
+	period, err := time.ParseDuration(os.Getenv(gcGracePeriodVar))
+	if err != nil || period < 0 {
+		return defaultGCGracePeriod
+	}
+	return period
+}
+
+func S3Endpoint() string {
+	return FromEnv(s3EndpointVar, "")
+}
+
+func S3Bucket() string {
+	return FromEnv(s3BucketVar, "")
+}
+
+func S3Region() string {
+	return FromEnv(s3RegionVar, defaultS3Region)
+}
+
+func S3AccessKey() string {
+	return FromEnv(s3AccessKeyVar, "")
+}
+
+func S3SecretKey() string {
+	return FromEnv(s3SecretKeyVar, "")
+}
+
 func HttpPort() string {
 	port := os.Getenv(portVar)
 	if port == "" {
@@ -199,6 +266,11 @@ func SaveChunks() bool {
 	return true
 }
 
+// DBMigrate tells whether pending DB migrations are applied on start.
+func DBMigrate() bool {
+	return strings.ToLower(os.Getenv(dbMigrateVar)) != "false"
+}
+
 func HasMasters() bool {
 	return len(Masters()) > 0
 }
@@ -207,32 +279,49 @@ func String(s string) *string {
 	return &s
 }
 
-func CalcHash(data []byte) string {
-	//sum := sha256.Sum256(data)
-	sum := sha512.Sum512(data)
-	return fmt.Sprintf("%x", sum[:])
+func GetHashedFilename(hash string, version byte) string {
+	key := GetHashedKey(hash, version)
+	if key == "" {
+		return ""
+	}
+	return fmt.Sprintf("%v/%v", DataDir(), key)
 }
 
-func GetHashedFilename(hash string, version byte) string {
-	if version == 2 {
-		return fmt.Sprintf("%v/%v/%v/%v", DataDir(), hash[:2], hash[2:4], hash[4:])
+// GetHashedKey returns the chunk location relative to the storage root.
+// Chunks of version 3 and newer are kept in a separate "v<version>" tree.
+func GetHashedKey(hash string, version byte) string {
+	if version >= 3 {
+		return fmt.Sprintf("v%v/%v/%v/%v", version, hash[:2], hash[2:4], hash[4:])
+	} else if version == 2 {
+		return fmt.Sprintf("%v/%v/%v", hash[:2], hash[2:4], hash[4:])
 	} else if version == 1 {
-		return fmt.Sprintf("%v/%v/%v/%v/%v", DataDir(), hash[:2], hash[2:4], hash[4:6], hash[6:])
+		return fmt.Sprintf("%v/%v/%v/%v", hash[:2], hash[2:4], hash[4:6], hash[6:])
 	} else if version == 0 {
 		hashDir := hash[:ChunkDirLength]
 		hashFile := hash[ChunkDirLength:]
-		return fmt.Sprintf("%v/%v/%v", DataDir(), hashDir, hashFile)
+		return fmt.Sprintf("%v/%v", hashDir, hashFile)
 	} else {
 		return ""
 	}
 }
 
 func GetHashFromPath(path string) (hash string, version byte) {
-	hash = strings.TrimPrefix(path, DataDir())
+	return GetHashFromKey(strings.TrimPrefix(path, DataDir()))
+}
+
+func GetHashFromKey(key string) (hash string, version byte) {
+	hash = strings.TrimPrefix(key, "/")
+	if strings.HasPrefix(hash, "v") {
+		parts := strings.SplitN(hash, "/", 2)
+		v, err := strconv.ParseUint(strings.TrimPrefix(parts[0], "v"), 10, 8)
+		if err == nil && len(parts) == 2 {
+			return strings.Replace(parts[1], "/", "", -1), byte(v)
+		}
+	}
 	cnt := strings.Count(hash, "/")
-	if cnt == 3 {
+	if cnt == 2 {
 		version = 2
-	} else if cnt == 4 {
+	} else if cnt == 3 {
 		version = 1
 	} else {
 		version = 0
@@ -241,15 +330,37 @@ func GetHashFromPath(path string) (hash string, version byte) {
 	return
 }
 
+// GetHashFromChunkPath returns the hash of the chunk stored at the path
+// of any data dir, e.g. the path given by master.
+func GetHashFromChunkPath(path string, version byte) string {
+	// Number of path segments the hash is split into.
+	segments := 3
+	switch version {
+	case 0:
+		segments = 2
+	case 1:
+		segments = 4
+	}
+	parts := strings.Split(path, "/")
+	if len(parts) > segments {
+		parts = parts[len(parts)-segments:]
+	}
+	return strings.Join(parts, "")
+}
+
 func PrintEnvInfo() {
 	fmt.Printf("DEBUG = %v\n", DebugEnabled())
 	fmt.Printf("DATA_DIR = %q\n", DataDir())
+	fmt.Printf("CHUNK_STORE = %q\n", ChunkStore())
 	fmt.Printf("HTTP_PORT = %q\n", HttpPort())
 	fmt.Printf("AUTH_VALIDATION = %q\n", AuthValidationURL())
 	fmt.Printf("MASTERS = %q\n", Masters())
 	fmt.Printf("READ_CONCURRENCY = %v\n", ReadConcurrency())
 	fmt.Printf("UPLOAD_CONCURRENCY = %v\n", UploadConcurrency())
 	fmt.Printf("SAVE_CHUNKS = %v\n", SaveChunks())
+	fmt.Printf("CHUNK_CACHE_SIZE = %v\n", ChunkCacheSize())
+	fmt.Printf("GC_GRACE_PERIOD = %v\n", GCGracePeriod())
+	fmt.Printf("DB_MIGRATE = %v\n", DBMigrate())
 }
 
 func GetFirstN(s []string, n int) []string {
@@ -299,6 +410,17 @@ func LoadAsJson(m map[string]interface{}, v interface{}) error {
 	return json.Unmarshal(data, v)
 }
 
+var aliasRegex = regexp.MustCompile("^[a-z][-a-z0-9]*$")
+
+// CheckAlias validates the version alias name.
+// Aliases must not look like versions, so they never shadow them.
+func CheckAlias(alias string) error {
+	if !aliasRegex.MatchString(alias) {
+		return fmt.Errorf("Invalid alias %v: must start with a letter and contain only lowercase letters, digits and '-'", alias)
+	}
+	return nil
+}
+
 func CheckVersion(version string) error {
 	v, err := semver.NewVersion(version)
 	if err != nil {
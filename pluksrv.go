@@ -6,6 +6,7 @@ import (
 	"github.com/kuberlab/pluk/pkg/db"
 	"github.com/kuberlab/pluk/pkg/gc"
 	"github.com/kuberlab/pluk/pkg/grpc"
+	"github.com/kuberlab/pluk/pkg/io"
 	"github.com/kuberlab/pluk/pkg/utils"
 )
 
@@ -14,6 +15,9 @@ func main() {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
 	logrus.SetFormatter(&logrus.TextFormatter{FullTimestamp: true, TimestampFormat: "2006-01-02 15:04:05"})
+	if err := io.InitStore(); err != nil {
+		logrus.Fatal(err)
+	}
 	db.DbMgr = db.NewMainDatabaseMgr()
 	go gc.Start()
 	go grpc.Start()